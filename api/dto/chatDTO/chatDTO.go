@@ -0,0 +1,53 @@
+package chatDTO
+
+import (
+	"fmt"
+	"time"
+)
+
+func ErrParamIsRequired(name, typ string) error {
+	return fmt.Errorf("param %s (type: %s) is required", name, typ)
+}
+
+// ErrorResponse reports the error in the chatDTO request
+type ErrorResponse struct {
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+}
+
+type ExportFormat string
+
+const (
+	ExportHTML     ExportFormat = "html"
+	ExportMarkdown ExportFormat = "md"
+	ExportJSON     ExportFormat = "json"
+)
+
+type ExportChatRequest struct {
+	TableID uint
+	Format  ExportFormat
+	// From and To limit the export to a session, nil exports from the first or up to the last message
+	From *time.Time
+	To   *time.Time
+	// Location of the times written in the export, UTC when nil
+	Location *time.Location
+}
+
+func (r *ExportChatRequest) Validate() error {
+
+	if r.TableID == 0 {
+		return ErrParamIsRequired("tableID", "uint")
+	}
+
+	switch r.Format {
+	case ExportHTML, ExportMarkdown, ExportJSON:
+	default:
+		return fmt.Errorf("format must be %s, %s or %s", ExportHTML, ExportMarkdown, ExportJSON)
+	}
+
+	if r.From != nil && r.To != nil && !r.To.After(*r.From) {
+		return fmt.Errorf("to must be after from")
+	}
+
+	return nil
+}
@@ -1,6 +1,12 @@
 package gameDTO
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+
+	"github.com/GarotoCowboy/vttProject/api/models"
+	"github.com/GarotoCowboy/vttProject/api/service/gameService/dice"
+)
 
 func ErrParamIsRequired(name, typ string) error {
 	return fmt.Errorf("param %s (type: %s) is required", name, typ)
@@ -15,9 +21,15 @@ type RollResultRequest struct {
 	Sides    int   `json:"sides"`
 	NumDices int   `json:"numDices"`
 	Bonuses  []int `json:"bonuses"`
+	// Expression replaces sides, numDices and bonuses, e.g. "4d6kh3+2"
+	Expression string `json:"expression"`
 }
 
 func (r *RollResultRequest) Validate() error {
+	if r.Expression != "" {
+		return nil
+	}
+
 	if r.Sides == 0 {
 		return ErrParamIsRequired("sides", "int")
 	}
@@ -36,4 +48,38 @@ type RollResultResponse struct {
 	SumOfRolls int    `json:"sum_of_rolls"`
 	Total      int    `json:"total"`
 	UserName   string `json:"userName"`
+	// Expression, Breakdown and Terms are set for expression rolls
+	Expression string      `json:"expression,omitempty"`
+	Breakdown  string      `json:"breakdown,omitempty"`
+	Terms      []dice.Term `json:"terms,omitempty"`
+	// the roll can be verified with its roll_id once the seed of the table is revealed
+	dice.Proof
+}
+
+// DiceSeedResponse is the commitment of the seed the rolls of a table are drawn from, Seed is only set once
+// it is revealed
+type DiceSeedResponse struct {
+	Commitment  string     `json:"commitment"`
+	NextNonce   uint64     `json:"next_nonce"`
+	CommittedAt time.Time  `json:"committed_at"`
+	Seed        string     `json:"seed,omitempty"`
+	RevealedAt  *time.Time `json:"revealed_at,omitempty"`
+}
+
+type RevealDiceSeedResponse struct {
+	Revealed DiceSeedResponse `json:"revealed"`
+	Next     DiceSeedResponse `json:"next"`
+}
+
+func NewDiceSeedResponse(seed *models.DiceSeed) DiceSeedResponse {
+	resp := DiceSeedResponse{
+		Commitment:  seed.Commitment,
+		NextNonce:   seed.NextNonce,
+		CommittedAt: seed.CreatedAt,
+		RevealedAt:  seed.RevealedAt,
+	}
+	if seed.RevealedAt != nil {
+		resp.Seed = seed.Seed
+	}
+	return resp
 }
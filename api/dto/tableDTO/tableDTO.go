@@ -53,12 +53,14 @@ func (r *CreateTableRequest) Validate() error {
 type UpdateTableRequest struct {
 	Name     string `json:"name"`
 	Password string `json:"password"`
+	// MastersReadWhispers is a pointer so it can be turned off
+	MastersReadWhispers *bool `json:"masters_read_whispers"`
 	//Members []models.TableUser `json:"members"`
 }
 
 func (r *UpdateTableRequest) Validate() error {
 	//If any field is provided, validation is truthy
-	if r.Password != "" || r.Name != "" /*|| r.Members != nil*/ {
+	if r.Password != "" || r.Name != "" || r.MastersReadWhispers != nil /*|| r.Members != nil*/ {
 		return nil
 	}
 
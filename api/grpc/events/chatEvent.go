@@ -1,8 +1,11 @@
 package events
 
 import (
+	"time"
+
 	"github.com/GarotoCowboy/vttProject/api/grpc/pb/chat"
 	"github.com/GarotoCowboy/vttProject/api/grpc/pb/sync"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func NewSendChatMessage(c *chat.ChatMessageResponse) *sync.SyncResponse {
@@ -44,3 +47,53 @@ func NewRemoveChatMessage(messageUUID string, tableID uint64) *sync.SyncResponse
 		},
 	}
 }
+
+func NewChatMessageStatusChanged(tableID, tableUserID uint64, messageStatus chat.MessageStatus, upTo time.Time) *sync.SyncResponse {
+
+	return &sync.SyncResponse{
+		SceneId: 0,
+		TableId: tableID,
+		Action: &sync.SyncResponse_MessageStatusChanged{
+			MessageStatusChanged: &chat.ChatMessageStatusChanged{
+				TableId:     tableID,
+				TableUserId: tableUserID,
+				Status:      messageStatus,
+				UpTo:        timestamppb.New(upTo),
+			},
+		},
+	}
+}
+
+func NewChatUserMuted(tableID, tableUserID uint64, mutedUntil *time.Time, reason *string) *sync.SyncResponse {
+
+	muted := &chat.ChatUserMuted{
+		TableId:     tableID,
+		TableUserId: tableUserID,
+		Reason:      reason,
+	}
+	if mutedUntil != nil {
+		muted.MutedUntil = timestamppb.New(*mutedUntil)
+	}
+
+	return &sync.SyncResponse{
+		SceneId: 0,
+		TableId: tableID,
+		Action: &sync.SyncResponse_ChatUserMuted{
+			ChatUserMuted: muted,
+		},
+	}
+}
+
+func NewChatSlowModeChanged(tableID uint64, intervalSeconds int32) *sync.SyncResponse {
+
+	return &sync.SyncResponse{
+		SceneId: 0,
+		TableId: tableID,
+		Action: &sync.SyncResponse_ChatSlowModeChanged{
+			ChatSlowModeChanged: &chat.ChatSlowModeChanged{
+				TableId:         tableID,
+				IntervalSeconds: intervalSeconds,
+			},
+		},
+	}
+}
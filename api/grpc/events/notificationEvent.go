@@ -0,0 +1,17 @@
+package events
+
+import (
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/notification"
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/sync"
+)
+
+func NewNotificationReceivedEvent(n *notification.Notification) *sync.SyncResponse {
+
+	return &sync.SyncResponse{
+		SceneId: 0,
+		TableId: n.GetTableId(),
+		Action: &sync.SyncResponse_NotificationReceived{
+			NotificationReceived: n,
+		},
+	}
+}
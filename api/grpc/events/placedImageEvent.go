@@ -56,3 +56,19 @@ func NewPlacedImageMovedEvent(sceneId, placedImageID uint64, posX, posY int32) *
 		},
 	}
 }
+
+func NewPlacedImageMovePreviewEvent(sceneId, placedImageID uint64, posX, posY int32, userId uint64) *sync.SyncResponse {
+
+	return &sync.SyncResponse{
+		SceneId: sceneId,
+		Action: &sync.SyncResponse_PlacedImageMovePreview{
+			PlacedImageMovePreview: &placed_image.PlacedImageMovePreview{
+				PlacedImageId: placedImageID,
+				SceneId:       sceneId,
+				PosY:          posY,
+				PosX:          posX,
+				UserId:        userId,
+			},
+		},
+	}
+}
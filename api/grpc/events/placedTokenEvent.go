@@ -56,3 +56,19 @@ func NewPlacedTokenMovedEvent(sceneId, placedTokenId uint64, posX, posY int32) *
 		},
 	}
 }
+
+func NewPlacedTokenMovePreviewEvent(sceneId, placedTokenId uint64, posX, posY int32, userId uint64) *sync.SyncResponse {
+
+	return &sync.SyncResponse{
+		SceneId: sceneId,
+		Action: &sync.SyncResponse_PlacedTokenMovePreview{
+			PlacedTokenMovePreview: &placed_token.PlacedTokenMovePreview{
+				PlacedTokenId: placedTokenId,
+				SceneId:       sceneId,
+				PosX:          posX,
+				PosY:          posY,
+				UserId:        userId,
+			},
+		},
+	}
+}
@@ -0,0 +1,48 @@
+package events
+
+import (
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/sync"
+)
+
+func NewUserJoinedEvent(tableID uint64, member *sync.OnlineMember) *sync.SyncResponse {
+
+	return &sync.SyncResponse{
+		SceneId: 0,
+		TableId: tableID,
+		Action: &sync.SyncResponse_UserJoined{
+			UserJoined: &sync.UserJoined{
+				Member: member,
+			},
+		},
+	}
+}
+
+func NewUserLeftEvent(tableID, userID, tableUserID uint64) *sync.SyncResponse {
+
+	return &sync.SyncResponse{
+		SceneId: 0,
+		TableId: tableID,
+		Action: &sync.SyncResponse_UserLeft{
+			UserLeft: &sync.UserLeft{
+				UserId:      userID,
+				TableUserId: tableUserID,
+			},
+		},
+	}
+}
+
+func NewUserChangedSceneEvent(tableID, userID, tableUserID, previousSceneID, sceneID uint64) *sync.SyncResponse {
+
+	return &sync.SyncResponse{
+		SceneId: 0,
+		TableId: tableID,
+		Action: &sync.SyncResponse_UserChangedScene{
+			UserChangedScene: &sync.UserChangedScene{
+				UserId:          userID,
+				TableUserId:     tableUserID,
+				PreviousSceneId: previousSceneID,
+				SceneId:         sceneID,
+			},
+		},
+	}
+}
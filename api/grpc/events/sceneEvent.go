@@ -43,3 +43,20 @@ func NewDeleteSceneEvent(tableId, sceneId uint64) *sync.SyncResponse {
 		},
 	}
 }
+
+func NewScenePingedEvent(tableId, sceneId, userId uint64, kind scene.PingKind, points []*scene.PingPoint, focusView bool) *sync.SyncResponse {
+
+	return &sync.SyncResponse{
+		SceneId: sceneId,
+		TableId: tableId,
+		Action: &sync.SyncResponse_ScenePinged{
+			ScenePinged: &scene.ScenePinged{
+				SceneId:   sceneId,
+				UserId:    userId,
+				Kind:      kind,
+				Points:    points,
+				FocusView: focusView,
+			},
+		},
+	}
+}
@@ -0,0 +1,66 @@
+package events
+
+import (
+	"time"
+
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/sync"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func NewResyncRequiredEvent(tableID, sceneID uint64, topic string, lastSeen, current uint64) *sync.SyncResponse {
+
+	return &sync.SyncResponse{
+		SceneId:  sceneID,
+		TableId:  tableID,
+		Sequence: current,
+		Topic:    topic,
+		Action: &sync.SyncResponse_ResyncRequired{
+			ResyncRequired: &sync.ResyncRequired{
+				Topic:            topic,
+				LastSeenSequence: lastSeen,
+				CurrentSequence:  current,
+			},
+		},
+	}
+}
+
+func NewSnapshotEvent(tableID, sceneID uint64, snapshot *sync.Snapshot) *sync.SyncResponse {
+
+	return &sync.SyncResponse{
+		SceneId:  sceneID,
+		TableId:  tableID,
+		Sequence: snapshot.TableSequence,
+		Action: &sync.SyncResponse_Snapshot{
+			Snapshot: snapshot,
+		},
+	}
+}
+
+func NewSceneSwitchedEvent(tableID, sceneID, previousSceneID uint64, snapshot *sync.Snapshot) *sync.SyncResponse {
+
+	return &sync.SyncResponse{
+		SceneId:  sceneID,
+		TableId:  tableID,
+		Sequence: snapshot.SceneSequence,
+		Action: &sync.SyncResponse_SceneSwitched{
+			SceneSwitched: &sync.SceneSwitched{
+				PreviousSceneId: previousSceneID,
+				Snapshot:        snapshot,
+			},
+		},
+	}
+}
+
+func NewHeartbeatEvent(tableID, sceneID uint64, idleTimeout time.Duration) *sync.SyncResponse {
+
+	return &sync.SyncResponse{
+		SceneId: sceneID,
+		TableId: tableID,
+		Action: &sync.SyncResponse_Heartbeat{
+			Heartbeat: &sync.Heartbeat{
+				SentAt:             timestamppb.Now(),
+				IdleTimeoutSeconds: uint32(idleTimeout / time.Second),
+			},
+		},
+	}
+}
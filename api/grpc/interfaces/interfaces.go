@@ -3,3 +3,13 @@ package interfaces
 type TableRequest interface {
 	GetTableId() uint64
 }
+
+type SceneRequest interface {
+	GetSceneId() uint64
+}
+
+// AuthTokenRequest is implemented by stream messages that can carry the JWT
+// when the client cannot send it as metadata
+type AuthTokenRequest interface {
+	GetAuthToken() string
+}
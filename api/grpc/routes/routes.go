@@ -4,7 +4,9 @@ import (
 	barProto "github.com/GarotoCowboy/vttProject/api/grpc/pb/bar"
 	characterProto "github.com/GarotoCowboy/vttProject/api/grpc/pb/character"
 	chatProto "github.com/GarotoCowboy/vttProject/api/grpc/pb/chat"
+	eventLogProto "github.com/GarotoCowboy/vttProject/api/grpc/pb/eventLog"
 	imageLibraryProto "github.com/GarotoCowboy/vttProject/api/grpc/pb/imageLibrary"
+	notificationProto "github.com/GarotoCowboy/vttProject/api/grpc/pb/notification"
 	permissionProto "github.com/GarotoCowboy/vttProject/api/grpc/pb/permission"
 	placedImageProto "github.com/GarotoCowboy/vttProject/api/grpc/pb/placedImage"
 	placedTokenProto "github.com/GarotoCowboy/vttProject/api/grpc/pb/placedToken"
@@ -18,7 +20,9 @@ import (
 	"github.com/GarotoCowboy/vttProject/api/grpc/service/bar"
 	characterNewService "github.com/GarotoCowboy/vttProject/api/grpc/service/character"
 	"github.com/GarotoCowboy/vttProject/api/grpc/service/chat"
+	"github.com/GarotoCowboy/vttProject/api/grpc/service/eventLog"
 	imageLibraryS "github.com/GarotoCowboy/vttProject/api/grpc/service/imageLibrary"
+	"github.com/GarotoCowboy/vttProject/api/grpc/service/notification"
 	"github.com/GarotoCowboy/vttProject/api/grpc/service/permission"
 	"github.com/GarotoCowboy/vttProject/api/grpc/service/placedToken"
 	"github.com/GarotoCowboy/vttProject/api/grpc/service/scene"
@@ -31,7 +35,7 @@ import (
 )
 
 // Routes code for GRPC
-func Routes(r *grpc.Server, db *gorm.DB, logger *config.Logger, broker *broker.Broker) {
+func Routes(r *grpc.Server, db *gorm.DB, logger *config.Logger, broker broker.Broker) {
 	characterService := characterNewService.NewCharacterService(db, logger)
 	chatService := chat.NewChatService(db, logger, broker)
 	tokenService := token.NewTokenService(db, logger, broker)
@@ -40,9 +44,11 @@ func Routes(r *grpc.Server, db *gorm.DB, logger *config.Logger, broker *broker.B
 	sceneService := scene.NewSceneService(logger, db, broker)
 	placedTokenService := placedToken.NewPlacedTokenService(db, logger, broker)
 	permissionService := permission.NewPermissionService(db, logger, broker)
-	syncService := sync.NewSyncServer(broker, logger)
+	syncService := sync.NewSyncServer(db, broker, logger)
 	tableUserService := tableUser.NewTableUserService(db, logger, broker)
 	placedImageService := placedImage.NewPlacedImageService(db, logger, broker)
+	eventLogService := eventLog.NewEventLogService(db, logger)
+	notificationService := notification.NewNotificationService(db, logger)
 	//Implements the router for characterServiceGRPC
 
 	characterProto.RegisterCharacterServiceServer(r, characterService)
@@ -76,4 +82,10 @@ func Routes(r *grpc.Server, db *gorm.DB, logger *config.Logger, broker *broker.B
 
 	//Implements the router for placedImage
 	placedImageProto.RegisterPlacedImageServiceServer(r, placedImageService)
+
+	//Implements the router for the table event log
+	eventLogProto.RegisterEventLogServiceServer(r, eventLogService)
+
+	//Implements the router for the notification inbox
+	notificationProto.RegisterNotificationServiceServer(r, notificationService)
 }
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"os"
+	"time"
+
+	"google.golang.org/grpc/keepalive"
+)
+
+const (
+	defaultKeepaliveTime    = 30 * time.Second
+	defaultKeepaliveTimeout = 10 * time.Second
+	// clients may ping at most this often, faster clients are disconnected
+	keepaliveMinClientTime = 10 * time.Second
+)
+
+// keepaliveParams makes the server ping connections idle for GRPC_KEEPALIVE_TIME and close the
+// ones that do not answer within GRPC_KEEPALIVE_TIMEOUT
+func keepaliveParams() keepalive.ServerParameters {
+	return keepalive.ServerParameters{
+		Time:    durationFromEnv("GRPC_KEEPALIVE_TIME", defaultKeepaliveTime),
+		Timeout: durationFromEnv("GRPC_KEEPALIVE_TIMEOUT", defaultKeepaliveTimeout),
+	}
+}
+
+// keepaliveEnforcementPolicy accepts client pings, the grpc default would close clients
+// pinging more than once every 5 minutes
+func keepaliveEnforcementPolicy() keepalive.EnforcementPolicy {
+	return keepalive.EnforcementPolicy{
+		MinTime:             keepaliveMinClientTime,
+		PermitWithoutStream: true,
+	}
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
@@ -19,17 +19,26 @@ const (
 )
 
 // RunGRPCServer This function starts the grpc Server with tcp and the host = localhost:50051
-func RunGRPCServer(db *gorm.DB, logger *config.Logger, broker *broker.Broker) {
+func RunGRPCServer(db *gorm.DB, logger *config.Logger, broker broker.Broker) {
 	listen, err := net.Listen("tcp", host+port)
 	if err != nil {
 		panic(err)
 	}
 
 	grpcServer := grpc.NewServer(
+		// keepalive closes connections of clients that vanished (e.g. behind a NAT), ending their streams
+		grpc.KeepaliveParams(keepaliveParams()),
+		grpc.KeepaliveEnforcementPolicy(keepaliveEnforcementPolicy()),
 		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
+			middleware.GrpcMetricsInterceptor,
 			middleware.GrpcAuthInterceptor,
 			middleware.GrpcTableMemberInterceptor(db),
 		)),
+		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(
+			middleware.GrpcStreamMetricsInterceptor,
+			middleware.GrpcStreamAuthInterceptor,
+			middleware.GrpcStreamTableMemberInterceptor(db),
+		)),
 	)
 	reflection.Register(grpcServer)
 	//Put the grpcRoutes
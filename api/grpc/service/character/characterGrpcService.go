@@ -57,6 +57,7 @@ func (c *CharacterService) CreateCharacter(ctx context.Context, req *character.C
 		PlayerName:  tableUser.User.Username,
 		SystemKey:   consts.SystemKey(req.SystemKey),
 		TableUserID: uint(req.TableUserId),
+		PortraitURL: req.PortraitUrl,
 		SheetData:   sheetBytes,
 	}
 	//create characterModel
@@ -77,7 +78,6 @@ func (c *CharacterService) CreateCharacter(ctx context.Context, req *character.C
 }
 
 func (c *CharacterService) UpdateSheet(stream character.CharacterService_UpdateSheetServer) error {
-	ctx := stream.Context()
 	var subID string
 	var charID uint
 
@@ -96,6 +96,8 @@ func (c *CharacterService) UpdateSheet(stream character.CharacterService_UpdateS
 		if err != nil {
 			return status.Errorf(codes.Internal, "recv error: %v", err)
 		}
+		// read after Recv, the stream interceptor adds the user_id when the first message has the auth token
+		ctx := stream.Context()
 
 		if subID == "" {
 			charID = uint(req.GetCharacterId())
@@ -12,12 +12,14 @@ import (
 	"github.com/GarotoCowboy/vttProject/api/models"
 	"github.com/GarotoCowboy/vttProject/api/models/consts"
 	"github.com/GarotoCowboy/vttProject/api/models/consts/pubSubSyncConst"
+	"github.com/GarotoCowboy/vttProject/api/utils"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func (s *ChatService) SendMessage(ctx context.Context, req *chat.SendMessageRequest) (*emptypb.Empty, error) {
@@ -40,8 +42,8 @@ func (s *ChatService) SendMessage(ctx context.Context, req *chat.SendMessageRequ
 	// --- 3. Authorization: Verify user is a member of the table ---
 	s.Logger.InfoF("Authorizing: checking if user %d is a member of table %d", userID, req.TableId)
 	var tableUserModel models.TableUser
-	// Preload User to get the username for the event payload later
-	if err := s.Db.WithContext(ctx).Preload("User").Where("user_id = ? AND table_id = ?", userID, req.TableId).First(&tableUserModel).Error; err != nil {
+	// Preload User to get the username for the event payload later, and Table for the slow mode
+	if err := s.Db.WithContext(ctx).Preload("User").Preload("Table").Where("user_id = ? AND table_id = ?", userID, req.TableId).First(&tableUserModel).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			s.Logger.ErrorF("Authorization failed: User %d is not in table %d", userID, req.TableId)
 			return nil, status.Errorf(codes.NotFound, "user is not in this table")
@@ -50,6 +52,32 @@ func (s *ChatService) SendMessage(ctx context.Context, req *chat.SendMessageRequ
 		return nil, status.Errorf(codes.Internal, "internal error")
 	}
 
+	if err := s.checkCanSend(ctx, tableUserModel); err != nil {
+		return nil, err
+	}
+
+	// --- 3.1 Channel and the character spoken as ---
+	character, err := s.resolveSpeaker(ctx, tableUserModel, req.GetChannel(), req.CharacterId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkReplyTarget(ctx, tableUserModel, req.ReplyToMessageId); err != nil {
+		return nil, err
+	}
+
+	// --- 3.2 Chat commands (/roll, /gmroll, /me, /w), in the channel and as the character of the request ---
+	if req.GetMessageType() == chat.MessageType_TEXT {
+		command, isCommand, err := parseCommand(req.GetMessageText())
+		if err != nil {
+			s.Logger.WarningF("Invalid chat command from user %d: %v", userID, err)
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		if isCommand {
+			return s.runCommand(ctx, tableUserModel, req.GetChannel(), character, command)
+		}
+	}
+
 	// --- 4. Process Attachments ---
 	var attachmentsJSON datatypes.JSON
 	if len(req.Attachments) > 0 {
@@ -70,10 +98,14 @@ func (s *ChatService) SendMessage(ctx context.Context, req *chat.SendMessageRequ
 		MessageType:   consts.MessageType(req.GetMessageType()),
 		MessageStatus: consts.MessageStatus(chat.MessageStatus_SENT), // Set initial status
 		Attachments:   attachmentsJSON,
+		Channel:       consts.ChatChannel(req.GetChannel()),
 		CreatedAt:     time.Now(),
 		UpdatedAt:     time.Now(),
 	}
 	// Add optional fields
+	if character != nil {
+		chatMessageModel.CharacterID = &character.ID
+	}
 	if req.MediaUrl != nil {
 		chatMessageModel.MediaURL = req.MediaUrl
 	}
@@ -102,6 +134,10 @@ func (s *ChatService) SendMessage(ctx context.Context, req *chat.SendMessageRequ
 		MediaUrl:         req.MediaUrl,
 		Attachments:      req.Attachments,
 		ReplyToMessageId: req.ReplyToMessageId,
+		Channel:          req.GetChannel(),
+	}
+	if character != nil {
+		setCharacter(respProto, *character)
 	}
 
 	// Create the sync event payload
@@ -118,6 +154,9 @@ func (s *ChatService) SendMessage(ctx context.Context, req *chat.SendMessageRequ
 	s.Broker.Publish(pubSubSyncConst.TableSync, req.GetTableId(), syncMsg)
 	s.Logger.InfoF("Published ChatMessageSent event to broker for table %d", req.TableId)
 
+	// --- 8. Notify the mentioned members, on any table they are connected to ---
+	s.notifyMentions(ctx, tableUserModel, chatMessageModel, "")
+
 	return &emptypb.Empty{}, nil
 }
 
@@ -140,12 +179,15 @@ func (s *ChatService) ListMessages(ctx context.Context, req *chat.ListMessagesRe
 
 	// --- 3. Authorization: Verify user is a member ---
 	s.Logger.InfoF("Authorizing: checking if user %d is a member of table %d", userID, req.TableId)
-	var count int64
-	// Efficient check without fetching the full model
-	s.Db.WithContext(ctx).Model(&models.TableUser{}).Where("user_id = ? AND table_id = ?", userID, req.TableId).Count(&count)
-	if count == 0 {
-		s.Logger.ErrorF("Authorization error: User %d is not a member of table %d", userID, req.TableId)
-		return nil, status.Errorf(codes.PermissionDenied, "user is not a member of the specified table")
+	var tableUser models.TableUser
+	// The table is needed to know whether masters can read whispers
+	if err := s.Db.WithContext(ctx).Preload("Table").Where("user_id = ? AND table_id = ?", userID, req.TableId).First(&tableUser).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.Logger.ErrorF("Authorization error: User %d is not a member of table %d", userID, req.TableId)
+			return nil, status.Errorf(codes.PermissionDenied, "user is not a member of the specified table")
+		}
+		s.Logger.ErrorF("Database error checking membership of user %d in table %d: %v", userID, req.TableId, err)
+		return nil, status.Errorf(codes.Internal, "database error")
 	}
 
 	// --- 4. Pagination Setup ---
@@ -161,10 +203,17 @@ func (s *ChatService) ListMessages(ctx context.Context, req *chat.ListMessagesRe
 	// Base query: get messages for the table, preload sender's user info, order by newest first
 	query := s.Db.WithContext(ctx).
 		Preload("TableUser.User"). // Preload user info through TableUser
+		Preload("Recipients").
+		Scopes(withCharacter).
 		Where("table_id = ?", req.GetTableId()).
+		Scopes(utils.VisibleChatMessages(tableUser)).
 		Order("created_at DESC").
 		Limit(int(pageSize))
 
+	if req.Channel != nil {
+		query = query.Where("channel = ?", req.GetChannel())
+	}
+
 	// Cursor-based pagination: if a cursor is provided
 	if req.GetLastMessageId() != "" {
 		s.Logger.InfoF("Using cursor: fetching messages created before message %s", req.GetLastMessageId())
@@ -185,37 +234,17 @@ func (s *ChatService) ListMessages(ctx context.Context, req *chat.ListMessagesRe
 
 	// --- 6. Process Results ---
 	s.Logger.InfoF("Found %d messages, processing response...", len(messages))
+	statusResolver, err := s.newMessageStatusResolver(ctx, tableUser.TableID)
+	if err != nil {
+		s.Logger.ErrorF("Database error loading read states for table %d: %v", req.TableId, err)
+		return nil, status.Errorf(codes.Internal, "failed to retrieve messages")
+	}
 	respMessages := make([]*chat.ChatMessageResponse, 0, len(messages))
 
 	for _, msg := range messages {
-		// Process attachments from JSON
-		var attachments []string
-		if msg.Attachments != nil {
-			if err := json.Unmarshal(msg.Attachments, &attachments); err != nil {
-				s.Logger.ErrorF("Failed to unmarshal attachments for message %s: %v", msg.ID, err)
-				// Don't fail the whole request, just skip attachments for this message
-			}
-		}
-
-		// Build the protobuf response message
-		respMsg := &chat.ChatMessageResponse{
-			MessageUuid:      msg.ID.String(),
-			TableId:          uint64(msg.TableID),
-			SenderId:         uint64(msg.TableUserID),
-			SenderUsername:   msg.TableUser.User.Username, // From preload
-			MessageText:      msg.Message,
-			MessageType:      chat.MessageType(msg.MessageType),
-			MessageStatus:    chat.MessageStatus(msg.MessageStatus),
-			SentAt:           timestamppb.New(msg.CreatedAt),
-			MediaUrl:         msg.MediaURL,
-			Attachments:      attachments,
-			ReplyToMessageId: msg.ReplyToMessageId,
-			IsDeleted:        !msg.DeletedAt.Time.IsZero(), // Check if soft-deleted
-		}
-		// Only add UpdatedAt if it's different from CreatedAt
-		if msg.UpdatedAt.After(msg.CreatedAt) {
-			respMsg.UpdatedAt = timestamppb.New(msg.UpdatedAt)
-		}
+		// Build the protobuf response message, the sender's username comes from the preload
+		respMsg := s.newChatMessageResponse(msg, msg.TableUser.User.Username)
+		respMsg.MessageStatus = statusResolver.status(msg)
 
 		respMessages = append(respMessages, respMsg)
 	}
@@ -228,9 +257,16 @@ func (s *ChatService) ListMessages(ctx context.Context, req *chat.ListMessagesRe
 		s.Logger.InfoF("Next cursor for pagination: %s", nextCursor)
 	}
 
+	unreadCount, err := s.unreadCount(ctx, tableUser)
+	if err != nil {
+		s.Logger.ErrorF("Database error counting unread messages for tableUser %d: %v", tableUser.ID, err)
+		return nil, status.Errorf(codes.Internal, "failed to retrieve messages")
+	}
+
 	return &chat.ListChatMessageResponse{
-		Messages:   respMessages,
-		NextCursor: &nextCursor,
+		Messages:    respMessages,
+		NextCursor:  &nextCursor,
+		UnreadCount: unreadCount,
 	}, nil
 }
 
@@ -253,8 +289,8 @@ func (s *ChatService) UpdateMessage(ctx context.Context, req *chat.UpdateMessage
 	// --- 2. Find Message and Authorize Action ---
 	s.Logger.InfoF("Fetching message %s for update...", req.MessageUuid)
 	var messageToUpdate models.ChatMessage
-	// Preload TableUser to get the UserID of the author
-	if err := s.Db.Preload("TableUser.User").First(&messageToUpdate, "id = ?", req.GetMessageUuid()).Error; err != nil {
+	// Preload TableUser to get the UserID of the author, and its Table for the slow mode
+	if err := s.Db.Preload("TableUser.User").Preload("TableUser.Table").Preload("Recipients").Scopes(withCharacter).First(&messageToUpdate, "id = ?", req.GetMessageUuid()).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			s.Logger.ErrorF("Message with ID %s not found", req.MessageUuid)
 			return nil, status.Errorf(codes.NotFound, "message not found")
@@ -270,18 +306,41 @@ func (s *ChatService) UpdateMessage(ctx context.Context, req *chat.UpdateMessage
 		return nil, status.Errorf(codes.PermissionDenied, "you can only edit your own messages")
 	}
 
+	// a muted or slowed down author cannot edit either, edits are broadcast like new messages
+	if err := s.checkCanSend(ctx, messageToUpdate.TableUser); err != nil {
+		return nil, err
+	}
+
 	// Business Logic: Only allow editing of text messages
 	if messageToUpdate.MessageType != consts.MessageType(chat.MessageType_TEXT) {
 		s.Logger.WarningF("User %d attempted to edit non-text message %s (type: %d)", userID, req.MessageUuid, messageToUpdate.MessageType)
 		return nil, status.Errorf(codes.InvalidArgument, "only text messages can be edited")
 	}
 
-	// --- 3. Update, Save, and Publish ---
+	if messageToUpdate.Message == req.GetNewMessage() {
+		s.Logger.InfoF("Message %s already has this text, nothing to update", req.MessageUuid)
+		return &emptypb.Empty{}, nil
+	}
+
+	// --- 3. Keep the previous text as a revision, Update, Save, and Publish ---
 	s.Logger.InfoF("Authorization successful. Updating message %s...", req.MessageUuid)
+	revision := models.ChatMessageRevision{
+		ChatMessageID: messageToUpdate.ID,
+		Message:       messageToUpdate.Message,
+		WrittenAt:     messageToUpdate.UpdatedAt,
+	}
 	messageToUpdate.Message = req.GetNewMessage()
+	messageToUpdate.RevisionCount++
 	messageToUpdate.UpdatedAt = time.Now() // GORM will update this automatically, but being explicit is good
 
-	if err := s.Db.Save(&messageToUpdate).Error; err != nil {
+	err := s.Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&revision).Error; err != nil {
+			return err
+		}
+		// Omit the associations, Save would upsert the preloaded recipients and sender
+		return tx.Omit(clause.Associations).Save(&messageToUpdate).Error
+	})
+	if err != nil {
 		s.Logger.ErrorF("Database error updating message %s: %v", req.MessageUuid, err)
 		return nil, status.Errorf(codes.Internal, "could not update message")
 	}
@@ -291,30 +350,8 @@ func (s *ChatService) UpdateMessage(ctx context.Context, req *chat.UpdateMessage
 	// Publish the event to the Broker
 	s.Logger.InfoF("Publishing ChatMessageUpdated event for table %d", messageToUpdate.TableID)
 
-	// Re-process attachments for the event payload
-	var attachments []string
-	if messageToUpdate.Attachments != nil {
-		if err := json.Unmarshal(messageToUpdate.Attachments, &attachments); err != nil {
-			s.Logger.ErrorF("Failed to unmarshal attachments for updated message event %s: %v", messageToUpdate.ID, err)
-			// Don't fail the request, just send an empty list
-		}
-	}
-
-	// Build the response payload for the event
-	respProto := &chat.ChatMessageResponse{
-		MessageUuid:      messageToUpdate.ID.String(),
-		TableId:          uint64(messageToUpdate.TableID),
-		SenderId:         uint64(messageToUpdate.TableUserID),
-		SenderUsername:   messageToUpdate.TableUser.User.Username,
-		MessageText:      messageToUpdate.Message,
-		MessageType:      chat.MessageType(messageToUpdate.MessageType),
-		MessageStatus:    chat.MessageStatus(messageToUpdate.MessageStatus),
-		SentAt:           timestamppb.New(messageToUpdate.CreatedAt),
-		UpdatedAt:        timestamppb.New(messageToUpdate.UpdatedAt), // The new updated time
-		Attachments:      attachments,
-		MediaUrl:         messageToUpdate.MediaURL,
-		ReplyToMessageId: messageToUpdate.ReplyToMessageId,
-	}
+	// Build the response payload for the event, UpdatedAt carries the new updated time
+	respProto := s.newChatMessageResponse(messageToUpdate, messageToUpdate.TableUser.User.Username)
 
 	// Create the sync event
 	syncMsg := &syncBroker.SyncResponse{
@@ -329,6 +366,11 @@ func (s *ChatService) UpdateMessage(ctx context.Context, req *chat.UpdateMessage
 	s.Broker.Publish(pubSubSyncConst.TableSync, respProto.TableId, syncMsg)
 	s.Logger.InfoF("Published ChatMessageUpdated event to broker for table %d", respProto.TableId)
 
+	// Only the members the edit added are notified, private messages notify nobody
+	if len(messageToUpdate.Recipients) == 0 {
+		s.notifyMentions(ctx, messageToUpdate.TableUser, messageToUpdate, revision.Message)
+	}
+
 	return &emptypb.Empty{}, nil
 }
 
@@ -361,16 +403,46 @@ func (s *ChatService) DeleteMessage(ctx context.Context, req *chat.DeleteMessage
 		return nil, status.Errorf(codes.Internal, "database error")
 	}
 
-	// Authorization: Only the original author can delete.
+	// Authorization: The author can delete, masters can delete any message of their table giving a reason.
+	deletedBy := messageToDelete.TableUserID
+	var reason *string
 	if messageToDelete.TableUser.UserID != userID {
-		s.Logger.WarningF("Authorization failed: User %d attempted to delete message %s owned by user %d (via TableUser %d)",
-			userID, req.MessageUuid, messageToDelete.TableUser.UserID, messageToDelete.TableUser.ID)
-		return nil, status.Errorf(codes.PermissionDenied, "you can only delete your own messages")
+		if err := utils.CheckUserIsMaster(ctx, s.Db, messageToDelete.TableID); err != nil {
+			if status.Code(err) != codes.PermissionDenied {
+				return nil, err
+			}
+			s.Logger.WarningF("Authorization failed: User %d attempted to delete message %s owned by user %d (via TableUser %d)",
+				userID, req.MessageUuid, messageToDelete.TableUser.UserID, messageToDelete.TableUser.ID)
+			return nil, status.Errorf(codes.PermissionDenied, "you can only delete your own messages")
+		}
+
+		moderationReason := strings.TrimSpace(req.GetReason())
+		if moderationReason == "" || len(moderationReason) > maxModerationReasonLength {
+			s.Logger.ErrorF("Invalid request: master %d deleted message %s without a valid reason", userID, req.MessageUuid)
+			return nil, status.Errorf(codes.InvalidArgument, "a reason of up to %d characters is required to delete the message of someone else", maxModerationReasonLength)
+		}
+		reason = &moderationReason
+
+		var moderator models.TableUser
+		if err := s.Db.WithContext(ctx).Select("id").Where("user_id = ? AND table_id = ?", userID, messageToDelete.TableID).First(&moderator).Error; err != nil {
+			s.Logger.ErrorF("Database error finding moderator profile: %v", err)
+			return nil, status.Errorf(codes.Internal, "database error")
+		}
+		deletedBy = moderator.ID
 	}
 
 	// --- 3. Delete (Soft Delete), Save, and Publish ---
 	s.Logger.InfoF("Authorization successful. Soft-deleting message %s...", req.MessageUuid)
-	if err := s.Db.Delete(&messageToDelete).Error; err != nil { // GORM performs a soft delete
+	err := s.Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&messageToDelete).Updates(map[string]interface{}{
+			"deleted_by_table_user_id": deletedBy,
+			"deletion_reason":          reason,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&messageToDelete).Error // GORM performs a soft delete
+	})
+	if err != nil {
 		s.Logger.ErrorF("Database error deleting message %s: %v", req.MessageUuid, err)
 		return nil, status.Errorf(codes.Internal, "could not delete message")
 	}
@@ -384,6 +456,8 @@ func (s *ChatService) DeleteMessage(ctx context.Context, req *chat.DeleteMessage
 			MessageDeleted: &chat.ChatMessageDeleted{
 				MessageUuid: req.GetMessageUuid(),
 				TableId:     uint64(messageToDelete.TableID),
+				Reason:      reason,
+				DeletedBy:   uint64(deletedBy),
 			},
 		},
 	}
@@ -398,9 +472,17 @@ func (s *ChatService) SendPrivateMessage(ctx context.Context, req *chat.SendPriv
 	s.Logger.InfoF("gRPC ChatService: SendPrivateMessage initiated")
 
 	// --- 1. Validation and Authentication ---
-	if req.GetToTableUserId() == 0 {
-		s.Logger.ErrorF("Invalid request: recipient tableUser ID (to_table_user_id) is required")
-		return nil, status.Errorf(codes.InvalidArgument, "recipient tableUser ID (to_table_user_id) is required")
+	recipientIDs := req.GetToTableUserIds()
+	if req.GetToTableUserId() != 0 {
+		recipientIDs = append([]uint64{req.GetToTableUserId()}, recipientIDs...)
+	}
+	if len(recipientIDs) == 0 && !req.GetToMasters() {
+		s.Logger.ErrorF("Invalid request: at least one recipient is required")
+		return nil, status.Errorf(codes.InvalidArgument, "at least one recipient (to_table_user_id, to_table_user_ids or to_masters) is required")
+	}
+	if err := ValidatePrivateMessage(req); err != nil {
+		s.Logger.ErrorF("Validation error for SendPrivateMessage request: %v", err)
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
 	}
 
 	userIDFromCtx := ctx.Value("user_id")
@@ -410,42 +492,49 @@ func (s *ChatService) SendPrivateMessage(ctx context.Context, req *chat.SendPriv
 		return nil, status.Errorf(codes.Internal, "error processing sender identity")
 	}
 
-	// --- 2. Find Sender and Recipient TableUser profiles ---
-	// NOTE: This logic assumes a user might be in multiple tables, so we must
-	// find the *correct* sender TableUser profile. A simple First() is not enough.
-	// A robust solution would require the TableID in the request.
-
-	s.Logger.InfoF("Fetching recipient TableUser profile for ID %d", req.GetToTableUserId())
-	var recipientTableUser models.TableUser
-	if err := s.Db.Preload("User").First(&recipientTableUser, "id = ?", req.GetToTableUserId()).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			s.Logger.ErrorF("Recipient table user with ID %d not found", req.GetToTableUserId())
-			return nil, status.Errorf(codes.NotFound, "recipient not found")
+	// --- 2. Find the table and the sender's TableUser profile ---
+	// Older clients only send the recipient, the table is the recipient's one
+	tableID := req.GetTableId()
+	if tableID == 0 {
+		if len(recipientIDs) == 0 {
+			s.Logger.ErrorF("Invalid request: table_id is required to whisper to the masters")
+			return nil, status.Errorf(codes.InvalidArgument, "table_id is required to whisper to the masters")
 		}
-		s.Logger.ErrorF("Database error finding recipient: %v", err)
-		return nil, status.Errorf(codes.Internal, "database error")
+		var firstRecipient models.TableUser
+		if err := s.Db.WithContext(ctx).Select("id", "table_id").First(&firstRecipient, "id = ?", recipientIDs[0]).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				s.Logger.ErrorF("Recipient table user with ID %d not found", recipientIDs[0])
+				return nil, status.Errorf(codes.NotFound, "recipient not found")
+			}
+			s.Logger.ErrorF("Database error finding recipient: %v", err)
+			return nil, status.Errorf(codes.Internal, "database error")
+		}
+		tableID = uint64(firstRecipient.TableID)
 	}
 
-	// Now find the sender's TableUser profile *for the same table*
-	s.Logger.InfoF("Fetching sender TableUser profile for user %d in table %d", senderUserID, recipientTableUser.TableID)
+	s.Logger.InfoF("Fetching sender TableUser profile for user %d in table %d", senderUserID, tableID)
 	var senderTableUser models.TableUser
-	if err := s.Db.Preload("User").First(&senderTableUser, "user_id = ? AND table_id = ?", senderUserID, recipientTableUser.TableID).Error; err != nil {
+	if err := s.Db.WithContext(ctx).Preload("User").Preload("Table").First(&senderTableUser, "user_id = ? AND table_id = ?", senderUserID, tableID).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			s.Logger.WarningF("Authorization failed: Sender %d is not in the same table as recipient %d (Table %d)",
-				senderUserID, recipientTableUser.ID, recipientTableUser.TableID)
-			return nil, status.Errorf(codes.PermissionDenied, "sender and recipient are not in the same table")
+			s.Logger.WarningF("Authorization failed: Sender %d is not in table %d", senderUserID, tableID)
+			return nil, status.Errorf(codes.PermissionDenied, "sender and recipients are not in the same table")
 		}
 		s.Logger.ErrorF("Could not find sender's table profile: %v", err)
-		return nil, status.Errorf(codes.FailedPrecondition, "sender profile not found in recipient's table")
+		return nil, status.Errorf(codes.Internal, "database error")
 	}
 
-	// Check for self-message
-	if senderTableUser.ID == recipientTableUser.ID {
-		s.Logger.WarningF("User %d (TableUser %d) attempted to send PM to themselves", senderUserID, senderTableUser.ID)
-		return nil, status.Errorf(codes.InvalidArgument, "cannot send a private message to yourself")
+	if err := s.checkCanSend(ctx, senderTableUser); err != nil {
+		return nil, err
 	}
 
-	// --- 3. (Authorization was handled in step 2) ---
+	// --- 3. Resolve the recipients in the sender's table ---
+	recipients, err := s.resolveRecipients(ctx, senderTableUser, recipientIDs, req.GetToMasters())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkReplyTarget(ctx, senderTableUser, req.ReplyToMessageId); err != nil {
+		return nil, err
+	}
 
 	// --- 4. Assemble and Save Message ---
 	s.Logger.InfoF("All checks passed. Assembling private message...")
@@ -459,11 +548,11 @@ func (s *ChatService) SendPrivateMessage(ctx context.Context, req *chat.SendPriv
 		attachmentsJSON = jsonBytes
 	}
 
-	recipientTableUserID := recipientTableUser.ID // Get ID to use as a pointer
 	privateMessageModel := models.ChatMessage{
 		TableID:       senderTableUser.TableID, // Set the TableID for partitioning
 		TableUserID:   senderTableUser.ID,      // The author
-		ToTableUserId: &recipientTableUserID,   // The recipient (pointer)
+		Recipients:    recipients,
+		ToMasters:     req.GetToMasters(),
 		Message:       req.GetMessage(),
 		MessageType:   consts.MessageType(req.GetMessageType()),
 		MessageStatus: consts.MessageStatus(chat.MessageStatus_SENT),
@@ -479,32 +568,16 @@ func (s *ChatService) SendPrivateMessage(ctx context.Context, req *chat.SendPriv
 		privateMessageModel.ReplyToMessageId = req.ReplyToMessageId
 	}
 
-	s.Logger.InfoF("Saving private message from TableUser %d to %d in table %d...",
-		senderTableUser.ID, recipientTableUser.ID, senderTableUser.TableID)
-	if err := s.Db.Create(&privateMessageModel).Error; err != nil {
+	s.Logger.InfoF("Saving private message from TableUser %d to %d recipients in table %d...",
+		senderTableUser.ID, len(recipients), senderTableUser.TableID)
+	if err := s.Db.WithContext(ctx).Create(&privateMessageModel).Error; err != nil {
 		s.Logger.ErrorF("Database error creating private message: %v", err)
 		return nil, status.Errorf(codes.Internal, "could not save private message")
 	}
 	s.Logger.InfoF("Private message %s saved to database", privateMessageModel.ID)
 
 	// --- 5. Publish to Broker ---
-	recipientID := uint64(recipientTableUser.ID)
-
-	// Build the event payload
-	respProto := &chat.ChatMessageResponse{
-		MessageUuid:        privateMessageModel.ID.String(),
-		TableId:            uint64(senderTableUser.TableID),
-		SenderId:           uint64(senderTableUser.ID),
-		SenderUsername:     senderTableUser.User.Username,
-		MessageText:        privateMessageModel.Message,
-		MessageType:        req.GetMessageType(),
-		MessageStatus:      chat.MessageStatus_SENT,
-		SentAt:             timestamppb.New(privateMessageModel.CreatedAt),
-		MediaUrl:           req.MediaUrl,
-		Attachments:        req.Attachments,
-		ReplyToMessageId:   req.ReplyToMessageId,
-		PrivateRecipientId: &recipientID, // Mark this as a PM
-	}
+	respProto := s.newChatMessageResponse(privateMessageModel, senderTableUser.User.Username)
 
 	syncMsg := &syncBroker.SyncResponse{
 		TableId: respProto.TableId,
@@ -516,7 +589,7 @@ func (s *ChatService) SendPrivateMessage(ctx context.Context, req *chat.SendPriv
 	}
 
 	// Publish to the main table sync
-	// The clients will be responsible for filtering messages
+	// The broker only delivers it to the sender, the recipients and, if the table allows it, the masters
 	s.Broker.Publish(pubSubSyncConst.TableSync, respProto.TableId, syncMsg)
 	s.Logger.InfoF("Published private ChatMessageSent event to broker for table %d", respProto.TableId)
 
@@ -0,0 +1,237 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/GarotoCowboy/vttProject/api/grpc/events"
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/chat"
+	"github.com/GarotoCowboy/vttProject/api/models"
+	"github.com/GarotoCowboy/vttProject/api/models/consts"
+	"github.com/GarotoCowboy/vttProject/api/models/consts/pubSubSyncConst"
+	"github.com/GarotoCowboy/vttProject/api/service/gameService/dice"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const (
+	commandRoll    = "roll"
+	commandGMRoll  = "gmroll"
+	commandMe      = "me"
+	commandWhisper = "w"
+)
+
+// chatCommand is a TEXT message starting with "/", e.g. "/roll 1d20+5" or "/w alice,bob see you later"
+type chatCommand struct {
+	name string
+	// targets are the usernames of /w
+	targets []string
+	args    string
+}
+
+// parseCommand reports whether text is a command and parses it
+func parseCommand(text string) (chatCommand, bool, error) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "/") {
+		return chatCommand{}, false, nil
+	}
+
+	name, args, _ := strings.Cut(text[1:], " ")
+	command := chatCommand{name: strings.ToLower(name), args: strings.TrimSpace(args)}
+
+	switch command.name {
+	case commandRoll, commandGMRoll:
+		if command.args == "" {
+			return command, true, fmt.Errorf("usage: /%s <dice>, e.g. /%s 1d20+5", command.name, command.name)
+		}
+	case commandMe:
+		if command.args == "" {
+			return command, true, fmt.Errorf("usage: /me <action>")
+		}
+	case commandWhisper:
+		targets, message, _ := strings.Cut(command.args, " ")
+		for _, target := range strings.Split(targets, ",") {
+			if target != "" {
+				command.targets = append(command.targets, target)
+			}
+		}
+		command.args = strings.TrimSpace(message)
+		if len(command.targets) == 0 || command.args == "" {
+			return command, true, fmt.Errorf("usage: /w <username>[,<username>...] <message>")
+		}
+	default:
+		return command, true, fmt.Errorf("unknown command /%s", command.name)
+	}
+
+	return command, true, nil
+}
+
+// runCommand executes the command of a member of the table and publishes the resulting message.
+// Rolls are made here so the client cannot choose the result. The channel and the character were resolved by SendMessage
+func (s *ChatService) runCommand(ctx context.Context, sender models.TableUser, channel chat.ChatChannel, character *models.Character, command chatCommand) (*emptypb.Empty, error) {
+	s.Logger.InfoF("Running chat command /%s for TableUser %d in table %d", command.name, sender.ID, sender.TableID)
+
+	now := time.Now()
+	message := models.ChatMessage{
+		TableID:       sender.TableID,
+		TableUserID:   sender.ID,
+		MessageType:   consts.MessageType(chat.MessageType_TEXT),
+		MessageStatus: consts.MessageStatus(chat.MessageStatus_SENT),
+		Channel:       consts.ChatChannel(channel),
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if character != nil {
+		message.CharacterID = &character.ID
+	}
+
+	switch command.name {
+	case commandRoll, commandGMRoll:
+		roll, err := s.roll(ctx, sender, command.args, command.name == commandGMRoll)
+		if err != nil {
+			return nil, err
+		}
+		rollJSON, err := protojson.Marshal(roll)
+		if err != nil {
+			s.Logger.ErrorF("Failed to marshal roll: %v", err)
+			return nil, status.Errorf(codes.Internal, "failed to process roll")
+		}
+
+		message.MessageType = consts.MessageType(chat.MessageType_SYSTEM)
+		message.Message = describeRoll(sender.User.Username, roll)
+		message.Roll = rollJSON
+
+		if command.name == commandGMRoll {
+			recipients, err := s.resolveRecipients(ctx, sender, nil, true)
+			if err != nil {
+				return nil, err
+			}
+			message.Recipients = recipients
+			message.ToMasters = true
+		}
+
+	case commandMe:
+		message.Message = command.args
+		message.Emote = true
+
+	case commandWhisper:
+		var found []models.TableUser
+		if err := s.Db.WithContext(ctx).Joins("User").
+			Where("table_users.table_id = ? AND \"User\".username IN ?", sender.TableID, command.targets).
+			Find(&found).Error; err != nil {
+			s.Logger.ErrorF("Database error finding whisper recipients: %v", err)
+			return nil, status.Errorf(codes.Internal, "database error")
+		}
+		for _, target := range command.targets {
+			if !containsUsername(found, target) {
+				s.Logger.WarningF("Whisper recipient %s not found in table %d", target, sender.TableID)
+				return nil, status.Errorf(codes.NotFound, "user %s is not in this table", target)
+			}
+		}
+
+		tableUserIDs := make([]uint64, len(found))
+		for i, recipient := range found {
+			tableUserIDs[i] = uint64(recipient.ID)
+		}
+		recipients, err := s.resolveRecipients(ctx, sender, tableUserIDs, false)
+		if err != nil {
+			return nil, err
+		}
+
+		message.Message = command.args
+		message.Recipients = recipients
+	}
+
+	if err := s.Db.WithContext(ctx).Create(&message).Error; err != nil {
+		s.Logger.ErrorF("Database error creating message for command /%s: %v", command.name, err)
+		return nil, status.Errorf(codes.Internal, "could not save message")
+	}
+	// set after Create, so gorm does not save the character again
+	message.Character = character
+
+	s.Broker.Publish(pubSubSyncConst.TableSync, uint64(sender.TableID), events.NewSendChatMessage(s.newChatMessageResponse(message, sender.User.Username)))
+	s.Logger.InfoF("Published ChatMessageSent event for command /%s to broker for table %d", command.name, sender.TableID)
+
+	// whispers and gm rolls are private, they notify nobody
+	if len(message.Recipients) == 0 {
+		s.notifyMentions(ctx, sender, message, "")
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *ChatService) roll(ctx context.Context, sender models.TableUser, expression string, toMasters bool) (*chat.DiceRoll, error) {
+	parsed, err := dice.Parse(expression)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	result, err := dice.RollExpression(parsed, sender.TableID, sender.UserID, toMasters, s.Db.WithContext(ctx))
+	if err != nil {
+		if errors.Is(err, dice.ErrLimitExceeded) {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		s.Logger.ErrorF("Error rolling %s for TableUser %d: %v", expression, sender.ID, err)
+		return nil, status.Errorf(codes.Internal, "could not roll dice")
+	}
+
+	roll := &chat.DiceRoll{
+		Expression: expression,
+		Total:      int32(result.Total),
+		Breakdown:  result.Breakdown,
+		RollId:     uint64(result.RollID),
+		Commitment: result.Commitment,
+		Nonce:      result.Nonce,
+	}
+	for _, term := range result.Terms {
+		diceTerm := &chat.DiceTerm{
+			Expression:      term.Expression,
+			Sides:           int32(term.Sides),
+			CountsSuccesses: term.CountsSuccesses,
+			Value:           int32(term.Value),
+		}
+		for _, die := range term.Dice {
+			diceTerm.Dice = append(diceTerm.Dice, &chat.Die{
+				Value:    int32(die.Value),
+				Dropped:  die.Dropped,
+				Rerolled: die.Rerolled,
+				Exploded: die.Exploded,
+				Success:  die.Success,
+			})
+		}
+		roll.Terms = append(roll.Terms, diceTerm)
+
+		// num_dice, sides and rolls describe the first dice of the expression, for clients that only know "NdS"
+		if term.Sides == 0 || roll.Sides != 0 {
+			continue
+		}
+		roll.Sides = int32(term.Sides)
+		for _, die := range term.Dice {
+			if die.Counts() {
+				roll.NumDice++
+				roll.Rolls = append(roll.Rolls, int32(die.Value))
+			}
+		}
+	}
+	return roll, nil
+}
+
+// describeRoll is the text of a roll message for clients that do not render the payload,
+// e.g. "alice rolled 4d6kh3+2: 4d6kh3[6, 5, 4, ~1~]+2 = 17"
+func describeRoll(username string, roll *chat.DiceRoll) string {
+	return fmt.Sprintf("%s rolled %s: %s = %d", username, roll.GetExpression(), roll.GetBreakdown(), roll.GetTotal())
+}
+
+func containsUsername(tableUsers []models.TableUser, username string) bool {
+	for _, tableUser := range tableUsers {
+		if tableUser.User.Username == username {
+			return true
+		}
+	}
+	return false
+}
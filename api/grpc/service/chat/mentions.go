@@ -0,0 +1,100 @@
+package chat
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/GarotoCowboy/vttProject/api/grpc/events"
+	"github.com/GarotoCowboy/vttProject/api/grpc/service/notification"
+	"github.com/GarotoCowboy/vttProject/api/models"
+	"github.com/GarotoCowboy/vttProject/api/models/consts"
+	"github.com/GarotoCowboy/vttProject/api/models/consts/pubSubSyncConst"
+)
+
+const (
+	// mentionGM and mentionAll are the role mentions, they win over members with the same username
+	mentionGM  = "gm"
+	mentionAll = "all"
+	// mentionPreviewLength is how many characters of the message the notification keeps
+	mentionPreviewLength = 140
+)
+
+// mentionPattern matches "@name" at the start of the text or after a character that cannot be part of a name,
+// so e-mail addresses are not mentions
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_.@-])@([\p{L}\p{N}_.-]+)`)
+
+// parseMentions returns the lowercased names mentioned in the text, without repetitions
+func parseMentions(text string) map[string]struct{} {
+	mentions := make(map[string]struct{})
+	for _, match := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		// "@Pedro." ends a sentence, the dot is not part of the name
+		name := strings.TrimRight(match[1], ".-")
+		if name != "" {
+			mentions[strings.ToLower(name)] = struct{}{}
+		}
+	}
+	return mentions
+}
+
+// isMentioned reports whether the member is one of the mentions, by name or by a role mention
+func isMentioned(member models.TableUser, mentions map[string]struct{}) bool {
+	_, all := mentions[mentionAll]
+	_, gm := mentions[mentionGM]
+	_, byName := mentions[strings.ToLower(member.User.Username)]
+	return all || byName || (gm && member.Role == consts.Master)
+}
+
+// notifyMentions saves a notification for each member mentioned in a public message and publishes it to
+// the topic of the user. previous is the text before an edit, the members it already mentioned are not
+// notified again. The message is already sent, so errors are only logged.
+// sender must have its User and Table loaded
+func (s *ChatService) notifyMentions(ctx context.Context, sender models.TableUser, msg models.ChatMessage, previous string) {
+	mentions := parseMentions(msg.Message)
+	if len(mentions) == 0 {
+		return
+	}
+	previousMentions := parseMentions(previous)
+
+	var members []models.TableUser
+	if err := s.Db.WithContext(ctx).Preload("User").Where("table_id = ? AND id <> ?", sender.TableID, sender.ID).Find(&members).Error; err != nil {
+		s.Logger.ErrorF("Database error loading the members of table %d for mentions: %v", sender.TableID, err)
+		return
+	}
+
+	preview := msg.Message
+	if utf8.RuneCountInString(preview) > mentionPreviewLength {
+		preview = string([]rune(preview)[:mentionPreviewLength]) + "…"
+	}
+
+	var notifications []models.Notification
+	for _, member := range members {
+		if !isMentioned(member, mentions) || isMentioned(member, previousMentions) {
+			continue
+		}
+		notifications = append(notifications, models.Notification{
+			UserID:          member.UserID,
+			TableID:         sender.TableID,
+			Type:            consts.Mention,
+			ChatMessageID:   &msg.ID,
+			FromTableUserID: sender.ID,
+			Preview:         preview,
+		})
+	}
+	if len(notifications) == 0 {
+		return
+	}
+
+	if err := s.Db.WithContext(ctx).Create(&notifications).Error; err != nil {
+		s.Logger.ErrorF("Database error saving %d mentions of message %s: %v", len(notifications), msg.ID, err)
+		return
+	}
+
+	for _, n := range notifications {
+		n.Table = sender.Table
+		n.FromTableUser = sender
+		s.Broker.Publish(pubSubSyncConst.UserSync, uint64(n.UserID), events.NewNotificationReceivedEvent(notification.NotificationToProto(n)))
+	}
+	s.Logger.InfoF("Message %s mentioned %d members of table %d", msg.ID, len(notifications), sender.TableID)
+}
@@ -0,0 +1,127 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/GarotoCowboy/vttProject/api/grpc/events"
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/chat"
+	"github.com/GarotoCowboy/vttProject/api/models"
+	"github.com/GarotoCowboy/vttProject/api/models/consts"
+	"github.com/GarotoCowboy/vttProject/api/models/consts/pubSubSyncConst"
+	"github.com/GarotoCowboy/vttProject/api/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"gorm.io/gorm"
+)
+
+const (
+	maxModerationReasonLength = 500
+	maxMuteDuration           = 30 * 24 * time.Hour
+	maxSlowModeSeconds        = 3600
+)
+
+func (s *ChatService) MuteTableUser(ctx context.Context, req *chat.MuteTableUserRequest) (*emptypb.Empty, error) {
+	s.Logger.InfoF("gRPC ChatService: MuteTableUser initiated for tableUser %d in table %d", req.GetTableUserId(), req.GetTableId())
+
+	if err := ValidateMuteTableUser(req); err != nil {
+		s.Logger.ErrorF("Validation error for MuteTableUser request: %v", err)
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	if err := utils.CheckUserIsMaster(ctx, s.Db, uint(req.GetTableId())); err != nil {
+		s.Logger.WarningF("Authorization failed: only masters can mute players of table %d", req.GetTableId())
+		return nil, err
+	}
+
+	var target models.TableUser
+	if err := s.Db.WithContext(ctx).Where("id = ? AND table_id = ?", req.GetTableUserId(), req.GetTableId()).First(&target).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.Logger.ErrorF("TableUser %d not found in table %d", req.GetTableUserId(), req.GetTableId())
+			return nil, status.Errorf(codes.NotFound, "table user not found")
+		}
+		s.Logger.ErrorF("Database error finding tableUser %d: %v", req.GetTableUserId(), err)
+		return nil, status.Errorf(codes.Internal, "database error")
+	}
+	if target.Role == consts.Master {
+		return nil, status.Errorf(codes.FailedPrecondition, "masters cannot be muted")
+	}
+
+	var mutedUntil *time.Time
+	if req.GetDurationSeconds() > 0 {
+		until := time.Now().Add(time.Duration(req.GetDurationSeconds()) * time.Second)
+		mutedUntil = &until
+	}
+
+	if err := s.Db.WithContext(ctx).Model(&target).Update("chat_muted_until", mutedUntil).Error; err != nil {
+		s.Logger.ErrorF("Database error muting tableUser %d: %v", target.ID, err)
+		return nil, status.Errorf(codes.Internal, "could not mute table user")
+	}
+
+	var reason *string
+	if trimmed := strings.TrimSpace(req.GetReason()); trimmed != "" {
+		reason = &trimmed
+	}
+	s.Broker.Publish(pubSubSyncConst.TableSync, req.GetTableId(), events.NewChatUserMuted(req.GetTableId(), uint64(target.ID), mutedUntil, reason))
+	s.Logger.InfoF("TableUser %d of table %d muted until %v", target.ID, req.GetTableId(), mutedUntil)
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *ChatService) SetSlowMode(ctx context.Context, req *chat.SetSlowModeRequest) (*emptypb.Empty, error) {
+	s.Logger.InfoF("gRPC ChatService: SetSlowMode initiated for table %d", req.GetTableId())
+
+	if err := ValidateSetSlowMode(req); err != nil {
+		s.Logger.ErrorF("Validation error for SetSlowMode request: %v", err)
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	if err := utils.CheckUserIsMaster(ctx, s.Db, uint(req.GetTableId())); err != nil {
+		s.Logger.WarningF("Authorization failed: only masters can change the slow mode of table %d", req.GetTableId())
+		return nil, err
+	}
+
+	if err := s.Db.WithContext(ctx).Model(&models.Table{}).Where("id = ?", req.GetTableId()).
+		Update("chat_slow_mode_seconds", req.GetIntervalSeconds()).Error; err != nil {
+		s.Logger.ErrorF("Database error changing slow mode of table %d: %v", req.GetTableId(), err)
+		return nil, status.Errorf(codes.Internal, "could not change slow mode")
+	}
+
+	s.Broker.Publish(pubSubSyncConst.TableSync, req.GetTableId(), events.NewChatSlowModeChanged(req.GetTableId(), req.GetIntervalSeconds()))
+	s.Logger.InfoF("Slow mode of table %d set to %d seconds", req.GetTableId(), req.GetIntervalSeconds())
+
+	return &emptypb.Empty{}, nil
+}
+
+// checkCanSend enforces the mute and the slow mode on a sender, masters are never limited.
+// sender must have its Table loaded
+func (s *ChatService) checkCanSend(ctx context.Context, sender models.TableUser) error {
+	if sender.Role == consts.Master {
+		return nil
+	}
+
+	now := time.Now()
+	if sender.ChatMutedUntil != nil && now.Before(*sender.ChatMutedUntil) {
+		s.Logger.WarningF("Muted TableUser %d attempted to send a message", sender.ID)
+		return status.Errorf(codes.PermissionDenied, "you are muted until %s", sender.ChatMutedUntil.Format(time.RFC3339))
+	}
+
+	if sender.Table.ChatSlowModeSeconds <= 0 {
+		return nil
+	}
+	var lastMessage models.ChatMessage
+	if err := s.Db.WithContext(ctx).Unscoped().Select("created_at").Where("table_user_id = ?", sender.ID).
+		Order("created_at DESC").Limit(1).Find(&lastMessage).Error; err != nil {
+		s.Logger.ErrorF("Database error checking slow mode of TableUser %d: %v", sender.ID, err)
+		return status.Errorf(codes.Internal, "database error")
+	}
+
+	interval := time.Duration(sender.Table.ChatSlowModeSeconds) * time.Second
+	if wait := lastMessage.CreatedAt.Add(interval).Sub(now); wait > 0 {
+		return status.Errorf(codes.ResourceExhausted, "slow mode is on, wait %d seconds", int(wait.Seconds())+1)
+	}
+	return nil
+}
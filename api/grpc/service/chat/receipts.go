@@ -0,0 +1,174 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/GarotoCowboy/vttProject/api/grpc/events"
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/chat"
+	"github.com/GarotoCowboy/vttProject/api/models"
+	"github.com/GarotoCowboy/vttProject/api/models/consts/pubSubSyncConst"
+	"github.com/GarotoCowboy/vttProject/api/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func (s *ChatService) MarkMessages(ctx context.Context, req *chat.MarkMessagesRequest) (*emptypb.Empty, error) {
+	s.Logger.InfoF("gRPC ChatService: MarkMessages initiated for table %d", req.GetTableId())
+
+	if err := ValidateMarkMessages(req); err != nil {
+		s.Logger.ErrorF("Validation error for MarkMessages request: %v", err)
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	userID, err := utils.PickUserIdJWT(ctx)
+	if err != nil {
+		s.Logger.ErrorF("UserID not found in context")
+		return nil, status.Errorf(codes.Internal, "error processing user identity")
+	}
+
+	var tableUser models.TableUser
+	if err := s.Db.WithContext(ctx).Preload("Table").Where("user_id = ? AND table_id = ?", userID, req.GetTableId()).First(&tableUser).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.Logger.ErrorF("Authorization error: User %d is not a member of table %d", userID, req.GetTableId())
+			return nil, status.Errorf(codes.PermissionDenied, "user is not a member of the specified table")
+		}
+		s.Logger.ErrorF("Database error checking membership of user %d in table %d: %v", userID, req.GetTableId(), err)
+		return nil, status.Errorf(codes.Internal, "database error")
+	}
+
+	// The cursor must be a message the caller can see, deleted ones included
+	var cursorMessage models.ChatMessage
+	if err := s.Db.WithContext(ctx).Unscoped().
+		Scopes(utils.VisibleChatMessages(tableUser)).
+		Where("id = ? AND table_id = ?", req.GetUpToMessageId(), req.GetTableId()).
+		First(&cursorMessage).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.Logger.ErrorF("Message %s not found in table %d", req.GetUpToMessageId(), req.GetTableId())
+			return nil, status.Errorf(codes.NotFound, "message not found")
+		}
+		s.Logger.ErrorF("Database error finding message %s: %v", req.GetUpToMessageId(), err)
+		return nil, status.Errorf(codes.Internal, "database error")
+	}
+	upTo := cursorMessage.CreatedAt
+
+	// Cursors only move forward, an old receipt arriving late does not undo a newer one
+	readState := models.ChatReadState{
+		TableUserID:   tableUser.ID,
+		TableID:       tableUser.TableID,
+		DeliveredUpTo: upTo,
+		UpdatedAt:     time.Now(),
+	}
+	updates := map[string]interface{}{
+		"delivered_up_to": gorm.Expr("GREATEST(chat_read_states.delivered_up_to, EXCLUDED.delivered_up_to)"),
+		"updated_at":      gorm.Expr("EXCLUDED.updated_at"),
+	}
+	if req.GetStatus() == chat.MessageStatus_READ {
+		readState.ReadUpTo = upTo
+		updates["read_up_to"] = gorm.Expr("GREATEST(chat_read_states.read_up_to, EXCLUDED.read_up_to)")
+	}
+
+	if err := s.Db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "table_user_id"}},
+		DoUpdates: clause.Assignments(updates),
+	}).Create(&readState).Error; err != nil {
+		s.Logger.ErrorF("Database error saving read state of tableUser %d: %v", tableUser.ID, err)
+		return nil, status.Errorf(codes.Internal, "could not save read state")
+	}
+
+	if err := s.Db.WithContext(ctx).Where("table_user_id = ?", tableUser.ID).First(&readState).Error; err != nil {
+		s.Logger.ErrorF("Database error loading read state of tableUser %d: %v", tableUser.ID, err)
+		return nil, status.Errorf(codes.Internal, "database error")
+	}
+
+	// Nothing to tell the table when the cursor did not move
+	current := readState.DeliveredUpTo
+	if req.GetStatus() == chat.MessageStatus_READ {
+		current = readState.ReadUpTo
+	}
+	if !current.Equal(upTo) {
+		s.Logger.InfoF("Read state of tableUser %d is already past message %s", tableUser.ID, req.GetUpToMessageId())
+		return &emptypb.Empty{}, nil
+	}
+
+	s.Broker.Publish(pubSubSyncConst.TableSync, req.GetTableId(), events.NewChatMessageStatusChanged(req.GetTableId(), uint64(tableUser.ID), req.GetStatus(), upTo))
+	s.Logger.InfoF("Published ChatMessageStatusChanged event to broker for table %d", req.GetTableId())
+
+	return &emptypb.Empty{}, nil
+}
+
+// unreadCount counts the messages visible to the table user sent by someone else after their read cursor
+func (s *ChatService) unreadCount(ctx context.Context, tableUser models.TableUser) (int64, error) {
+	var readState models.ChatReadState
+	if err := s.Db.WithContext(ctx).Where("table_user_id = ?", tableUser.ID).Limit(1).Find(&readState).Error; err != nil {
+		return 0, err
+	}
+
+	var count int64
+	err := s.Db.WithContext(ctx).Model(&models.ChatMessage{}).
+		Scopes(utils.VisibleChatMessages(tableUser)).
+		Where("table_id = ? AND table_user_id <> ? AND created_at > ?", tableUser.TableID, tableUser.ID, readState.ReadUpTo).
+		Count(&count).Error
+	return count, err
+}
+
+// messageStatusResolver computes the status of messages from the read state of their recipients, the Recipients
+// of the messages must be loaded. A public message is only DELIVERED or READ once every other member of the table got there
+type messageStatusResolver struct {
+	members []uint
+	states  map[uint]models.ChatReadState
+}
+
+func (s *ChatService) newMessageStatusResolver(ctx context.Context, tableID uint) (*messageStatusResolver, error) {
+	resolver := &messageStatusResolver{states: make(map[uint]models.ChatReadState)}
+
+	if err := s.Db.WithContext(ctx).Model(&models.TableUser{}).Where("table_id = ?", tableID).Pluck("id", &resolver.members).Error; err != nil {
+		return nil, err
+	}
+
+	var states []models.ChatReadState
+	if err := s.Db.WithContext(ctx).Where("table_id = ?", tableID).Find(&states).Error; err != nil {
+		return nil, err
+	}
+	for _, state := range states {
+		resolver.states[state.TableUserID] = state
+	}
+
+	return resolver, nil
+}
+
+func (r *messageStatusResolver) status(msg models.ChatMessage) chat.MessageStatus {
+	recipients := r.members
+	if len(msg.Recipients) > 0 {
+		recipients = recipientIDs(msg)
+	}
+
+	delivered, read, hasRecipients := true, true, false
+	for _, tableUserID := range recipients {
+		if tableUserID == msg.TableUserID {
+			continue
+		}
+		hasRecipients = true
+		state := r.states[tableUserID]
+		if state.DeliveredUpTo.Before(msg.CreatedAt) {
+			delivered = false
+		}
+		if state.ReadUpTo.Before(msg.CreatedAt) {
+			read = false
+		}
+	}
+
+	switch {
+	case !hasRecipients:
+		return chat.MessageStatus_SENT
+	case read:
+		return chat.MessageStatus_READ
+	case delivered:
+		return chat.MessageStatus_DELIVERED
+	}
+	return chat.MessageStatus_SENT
+}
@@ -0,0 +1,86 @@
+package chat
+
+import (
+	"context"
+	"sort"
+
+	"github.com/GarotoCowboy/vttProject/api/models"
+	"github.com/GarotoCowboy/vttProject/api/models/consts"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const maxRecipients = 20
+
+// resolveRecipients checks that every recipient is in the sender's table and adds the masters when toMasters is set.
+// The sender is never a recipient of their own whisper, unless they are the only master of a whisper to the masters:
+// the message must still have a recipient so it does not become public
+func (s *ChatService) resolveRecipients(ctx context.Context, sender models.TableUser, tableUserIDs []uint64, toMasters bool) ([]models.ChatMessageRecipient, error) {
+	ids := make(map[uint]struct{}, len(tableUserIDs))
+	for _, id := range tableUserIDs {
+		if uint(id) == sender.ID {
+			s.Logger.WarningF("TableUser %d attempted to send PM to themselves", sender.ID)
+			return nil, status.Errorf(codes.InvalidArgument, "cannot send a private message to yourself")
+		}
+		ids[uint(id)] = struct{}{}
+	}
+	if len(ids) > maxRecipients {
+		return nil, status.Errorf(codes.InvalidArgument, "a private message can have at most %d recipients", maxRecipients)
+	}
+
+	if len(ids) > 0 {
+		var count int64
+		if err := s.Db.WithContext(ctx).Model(&models.TableUser{}).
+			Where("id IN ? AND table_id = ?", keys(ids), sender.TableID).
+			Count(&count).Error; err != nil {
+			s.Logger.ErrorF("Database error finding recipients: %v", err)
+			return nil, status.Errorf(codes.Internal, "database error")
+		}
+		if count != int64(len(ids)) {
+			s.Logger.WarningF("TableUser %d whispered to table users outside table %d", sender.ID, sender.TableID)
+			return nil, status.Errorf(codes.NotFound, "recipient not found in this table")
+		}
+	}
+
+	if toMasters {
+		var masterIDs []uint
+		if err := s.Db.WithContext(ctx).Model(&models.TableUser{}).
+			Where("table_id = ? AND role = ?", sender.TableID, consts.Master).
+			Pluck("id", &masterIDs).Error; err != nil {
+			s.Logger.ErrorF("Database error finding masters of table %d: %v", sender.TableID, err)
+			return nil, status.Errorf(codes.Internal, "database error")
+		}
+		for _, id := range masterIDs {
+			if id != sender.ID {
+				ids[id] = struct{}{}
+			}
+		}
+		if len(ids) == 0 {
+			ids[sender.ID] = struct{}{}
+		}
+	}
+
+	recipients := make([]models.ChatMessageRecipient, 0, len(ids))
+	for _, id := range keys(ids) {
+		recipients = append(recipients, models.ChatMessageRecipient{TableUserID: id})
+	}
+	return recipients, nil
+}
+
+// recipientIDs are the table users a message was sent to, empty for public messages
+func recipientIDs(msg models.ChatMessage) []uint {
+	ids := make([]uint, len(msg.Recipients))
+	for i, recipient := range msg.Recipients {
+		ids[i] = recipient.TableUserID
+	}
+	return ids
+}
+
+func keys(set map[uint]struct{}) []uint {
+	ids := make([]uint, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
@@ -0,0 +1,70 @@
+package chat
+
+import (
+	"encoding/json"
+
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/chat"
+	"github.com/GarotoCowboy/vttProject/api/models"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// newChatMessageResponse builds the protobuf of a saved message, its Recipients must be loaded.
+// The attachments and the roll are decoded from their json columns, a broken column is left empty
+func (s *ChatService) newChatMessageResponse(msg models.ChatMessage, senderUsername string) *chat.ChatMessageResponse {
+	var attachments []string
+	if msg.Attachments != nil {
+		if err := json.Unmarshal(msg.Attachments, &attachments); err != nil {
+			s.Logger.ErrorF("Failed to unmarshal attachments for message %s: %v", msg.ID, err)
+		}
+	}
+
+	resp := &chat.ChatMessageResponse{
+		MessageUuid:      msg.ID.String(),
+		TableId:          uint64(msg.TableID),
+		SenderId:         uint64(msg.TableUserID),
+		SenderUsername:   senderUsername,
+		MessageText:      msg.Message,
+		MessageType:      chat.MessageType(msg.MessageType),
+		MessageStatus:    chat.MessageStatus(msg.MessageStatus),
+		SentAt:           timestamppb.New(msg.CreatedAt),
+		MediaUrl:         msg.MediaURL,
+		Attachments:      attachments,
+		ReplyToMessageId: msg.ReplyToMessageId,
+		IsDeleted:        !msg.DeletedAt.Time.IsZero(),
+		IsEmote:          msg.Emote,
+		ToMasters:        msg.ToMasters,
+		RevisionCount:    msg.RevisionCount,
+		Channel:          chat.ChatChannel(msg.Channel),
+	}
+	if msg.Character != nil {
+		setCharacter(resp, *msg.Character)
+	}
+	if msg.UpdatedAt.After(msg.CreatedAt) {
+		resp.UpdatedAt = timestamppb.New(msg.UpdatedAt)
+	}
+	for _, id := range recipientIDs(msg) {
+		resp.RecipientIds = append(resp.RecipientIds, uint64(id))
+	}
+	if len(resp.RecipientIds) == 1 {
+		resp.PrivateRecipientId = &resp.RecipientIds[0]
+	}
+	if msg.Roll != nil {
+		roll := &chat.DiceRoll{}
+		if err := protojson.Unmarshal(msg.Roll, roll); err != nil {
+			s.Logger.ErrorF("Failed to unmarshal roll for message %s: %v", msg.ID, err)
+		} else {
+			resp.Roll = roll
+		}
+	}
+
+	return resp
+}
+
+// setCharacter shows the message as spoken by the character
+func setCharacter(resp *chat.ChatMessageResponse, character models.Character) {
+	characterID := uint64(character.ID)
+	resp.CharacterId = &characterID
+	resp.CharacterName = &character.Name
+	resp.CharacterPortraitUrl = character.PortraitURL
+}
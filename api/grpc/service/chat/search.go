@@ -0,0 +1,152 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"html"
+	"strings"
+
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/chat"
+	"github.com/GarotoCowboy/vttProject/api/models"
+	"github.com/GarotoCowboy/vttProject/api/utils"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+const (
+	maxSearchQueryLength = 200
+	searchQuery          = "websearch_to_tsquery('portuguese', ?)"
+	// the matched words are wrapped in control characters removed from the text, so the snippet can be
+	// escaped as HTML before they become <mark>
+	searchHeadlineOptions = "StartSel=\"\x02\", StopSel=\"\x03\", MaxFragments=2, MaxWords=20, MinWords=5, FragmentDelimiter=\" … \""
+	searchHeadlineText    = "translate(message, chr(2) || chr(3), '')"
+)
+
+// snippetMarker turns the selection markers of ts_headline into HTML, the text was escaped before
+var snippetMarker = strings.NewReplacer("\x02", "<mark>", "\x03", "</mark>")
+
+// snippetHTML escapes the text of a headline and marks the matched words
+func snippetHTML(headline string) string {
+	return snippetMarker.Replace(html.EscapeString(headline))
+}
+
+type searchMatch struct {
+	ID      uuid.UUID
+	Snippet string
+	Rank    float32
+}
+
+func (s *ChatService) SearchMessages(ctx context.Context, req *chat.SearchMessagesRequest) (*chat.SearchMessagesResponse, error) {
+	s.Logger.InfoF("gRPC ChatService: SearchMessages initiated for table %d", req.GetTableId())
+
+	// --- 1. Validation and Authentication ---
+	if err := ValidateSearchMessages(req); err != nil {
+		s.Logger.ErrorF("Validation error for SearchMessages request: %v", err)
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	userID, err := utils.PickUserIdJWT(ctx)
+	if err != nil {
+		s.Logger.ErrorF("UserID not found in context")
+		return nil, status.Errorf(codes.Internal, "error processing user identity")
+	}
+
+	// --- 2. Authorization: Verify user is a member ---
+	var tableUser models.TableUser
+	if err := s.Db.WithContext(ctx).Preload("Table").Where("user_id = ? AND table_id = ?", userID, req.GetTableId()).First(&tableUser).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.Logger.ErrorF("Authorization error: User %d is not a member of table %d", userID, req.GetTableId())
+			return nil, status.Errorf(codes.PermissionDenied, "user is not a member of the specified table")
+		}
+		s.Logger.ErrorF("Database error checking membership of user %d in table %d: %v", userID, req.GetTableId(), err)
+		return nil, status.Errorf(codes.Internal, "database error")
+	}
+
+	pageSize := req.GetPageSize()
+	if pageSize <= 0 || pageSize > 100 {
+		pageSize = 25
+	}
+
+	// --- 3. Search the matches the caller can see ---
+	// One more than the page tells whether there is a next page
+	query := s.Db.WithContext(ctx).Model(&models.ChatMessage{}).
+		Select("id, ts_headline('portuguese', "+searchHeadlineText+", "+searchQuery+", ?) AS snippet, ts_rank("+models.ChatMessageSearchVector+", "+searchQuery+") AS rank",
+			req.GetQuery(), searchHeadlineOptions, req.GetQuery()).
+		Where("table_id = ?", req.GetTableId()).
+		Where(models.ChatMessageSearchVector+" @@ "+searchQuery, req.GetQuery()).
+		Scopes(utils.VisibleChatMessages(tableUser)).
+		Order("rank DESC, created_at DESC").
+		Offset(int(req.GetOffset())).
+		Limit(int(pageSize) + 1)
+
+	if req.SenderId != nil {
+		query = query.Where("table_user_id = ?", req.GetSenderId())
+	}
+	if req.MessageType != nil {
+		query = query.Where("message_type = ?", req.GetMessageType())
+	}
+	if req.GetFrom() != nil {
+		query = query.Where("created_at >= ?", req.GetFrom().AsTime())
+	}
+	if req.GetTo() != nil {
+		query = query.Where("created_at < ?", req.GetTo().AsTime())
+	}
+
+	var matches []searchMatch
+	if err := query.Scan(&matches).Error; err != nil {
+		s.Logger.ErrorF("Database error searching messages of table %d: %v", req.GetTableId(), err)
+		return nil, status.Errorf(codes.Internal, "failed to search messages")
+	}
+
+	resp := &chat.SearchMessagesResponse{}
+	if len(matches) > int(pageSize) {
+		matches = matches[:pageSize]
+		nextOffset := req.GetOffset() + pageSize
+		resp.NextOffset = &nextOffset
+	}
+	if len(matches) == 0 {
+		return resp, nil
+	}
+
+	// --- 4. Load the matched messages, keeping the order of the ranking ---
+	ids := make([]uuid.UUID, len(matches))
+	for i, match := range matches {
+		ids[i] = match.ID
+	}
+	var messages []models.ChatMessage
+	if err := s.Db.WithContext(ctx).Preload("TableUser.User").Preload("Recipients").Scopes(withCharacter).Where("id IN ?", ids).Find(&messages).Error; err != nil {
+		s.Logger.ErrorF("Database error loading matched messages of table %d: %v", req.GetTableId(), err)
+		return nil, status.Errorf(codes.Internal, "failed to search messages")
+	}
+	byID := make(map[uuid.UUID]models.ChatMessage, len(messages))
+	for _, msg := range messages {
+		byID[msg.ID] = msg
+	}
+
+	statusResolver, err := s.newMessageStatusResolver(ctx, tableUser.TableID)
+	if err != nil {
+		s.Logger.ErrorF("Database error loading read states for table %d: %v", req.GetTableId(), err)
+		return nil, status.Errorf(codes.Internal, "failed to search messages")
+	}
+
+	for _, match := range matches {
+		msg, ok := byID[match.ID]
+		if !ok {
+			// deleted between the two queries
+			continue
+		}
+		respMsg := s.newChatMessageResponse(msg, msg.TableUser.User.Username)
+		respMsg.MessageStatus = statusResolver.status(msg)
+
+		resp.Results = append(resp.Results, &chat.SearchMessageResult{
+			Message: respMsg,
+			Snippet: snippetHTML(match.Snippet),
+			Rank:    match.Rank,
+		})
+	}
+
+	s.Logger.InfoF("Found %d messages matching the search in table %d", len(resp.Results), req.GetTableId())
+	return resp, nil
+}
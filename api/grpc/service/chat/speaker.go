@@ -0,0 +1,53 @@
+package chat
+
+import (
+	"context"
+	"errors"
+
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/chat"
+	"github.com/GarotoCowboy/vttProject/api/models"
+	"github.com/GarotoCowboy/vttProject/api/models/consts"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// resolveSpeaker checks that the sender can write in the channel and speak as the character.
+// Players speak as their own characters, masters as theirs and as any NPC of the table, the characters of a master.
+// It returns nil when the sender speaks as themselves
+func (s *ChatService) resolveSpeaker(ctx context.Context, sender models.TableUser, channel chat.ChatChannel, characterID *uint64) (*models.Character, error) {
+	if channel == chat.ChatChannel_GAME_MASTER && sender.Role != consts.Master {
+		s.Logger.WarningF("Authorization failed: TableUser %d is not a master and cannot write in the GM channel", sender.ID)
+		return nil, status.Errorf(codes.PermissionDenied, "only masters can write in the GM channel")
+	}
+	if characterID == nil {
+		return nil, nil
+	}
+
+	var character models.Character
+	if err := s.Db.WithContext(ctx).Preload("TableUser").First(&character, "id = ?", *characterID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.Logger.ErrorF("Character %d not found", *characterID)
+			return nil, status.Errorf(codes.NotFound, "character not found")
+		}
+		s.Logger.ErrorF("Database error finding character %d: %v", *characterID, err)
+		return nil, status.Errorf(codes.Internal, "database error")
+	}
+	if character.TableUser.TableID != sender.TableID {
+		s.Logger.ErrorF("Character %d is not in table %d", character.ID, sender.TableID)
+		return nil, status.Errorf(codes.NotFound, "character not found")
+	}
+
+	ownCharacter := character.TableUserID == sender.ID
+	npc := sender.Role == consts.Master && character.TableUser.Role == consts.Master
+	if !ownCharacter && !npc {
+		s.Logger.WarningF("Authorization failed: TableUser %d cannot speak as character %d", sender.ID, character.ID)
+		return nil, status.Errorf(codes.PermissionDenied, "you can only speak as your own characters")
+	}
+	return &character, nil
+}
+
+// withCharacter preloads the character a message was spoken as, deleted characters keep their name in the history
+func withCharacter(db *gorm.DB) *gorm.DB {
+	return db.Preload("Character", func(db *gorm.DB) *gorm.DB { return db.Unscoped() })
+}
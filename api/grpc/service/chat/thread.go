@@ -0,0 +1,202 @@
+package chat
+
+import (
+	"context"
+	"errors"
+
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/chat"
+	"github.com/GarotoCowboy/vttProject/api/models"
+	"github.com/GarotoCowboy/vttProject/api/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+// threadMessageIDs selects the ids of a message and of every reply below it. UNION stops on reply cycles
+const threadMessageIDs = `WITH RECURSIVE thread(id) AS (
+		SELECT id FROM chat_messages WHERE id = ?
+		UNION
+		SELECT m.id FROM chat_messages m JOIN thread t ON m.reply_to_message_id = t.id::text
+	) SELECT id FROM thread`
+
+// checkReplyTarget checks that the replied message is in the table of the sender and the sender can see it,
+// a reply links both messages in a thread. sender must have its Table loaded
+func (s *ChatService) checkReplyTarget(ctx context.Context, sender models.TableUser, replyTo *string) error {
+	if replyTo == nil {
+		return nil
+	}
+
+	var count int64
+	if err := s.Db.WithContext(ctx).Model(&models.ChatMessage{}).
+		Scopes(utils.VisibleChatMessages(sender)).
+		Where("id = ? AND table_id = ?", *replyTo, sender.TableID).
+		Count(&count).Error; err != nil {
+		s.Logger.ErrorF("Database error finding replied message %s: %v", *replyTo, err)
+		return status.Errorf(codes.Internal, "database error")
+	}
+	if count == 0 {
+		s.Logger.WarningF("TableUser %d replied to message %s, not found in table %d", sender.ID, *replyTo, sender.TableID)
+		return status.Errorf(codes.NotFound, "replied message not found")
+	}
+	return nil
+}
+
+func (s *ChatService) ListMessageRevisions(ctx context.Context, req *chat.ListMessageRevisionsRequest) (*chat.ListMessageRevisionsResponse, error) {
+	s.Logger.InfoF("gRPC ChatService: ListMessageRevisions initiated for message %s", req.GetMessageUuid())
+
+	if err := ValidateListMessageRevisions(req); err != nil {
+		s.Logger.ErrorF("Validation error for ListMessageRevisions request: %v", err)
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	tableUser, err := s.chatMember(ctx, req.GetTableId())
+	if err != nil {
+		return nil, err
+	}
+
+	// The revisions of a message are as private as the message, deleted messages have none left to show
+	var message models.ChatMessage
+	if err := s.Db.WithContext(ctx).
+		Preload("Revisions", func(db *gorm.DB) *gorm.DB { return db.Order("created_at, id") }).
+		Scopes(utils.VisibleChatMessages(tableUser)).
+		Where("id = ? AND table_id = ?", req.GetMessageUuid(), req.GetTableId()).
+		First(&message).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.Logger.ErrorF("Message %s not found in table %d", req.GetMessageUuid(), req.GetTableId())
+			return nil, status.Errorf(codes.NotFound, "message not found")
+		}
+		s.Logger.ErrorF("Database error finding message %s: %v", req.GetMessageUuid(), err)
+		return nil, status.Errorf(codes.Internal, "database error")
+	}
+
+	resp := &chat.ListMessageRevisionsResponse{}
+	for _, revision := range message.Revisions {
+		resp.Revisions = append(resp.Revisions, &chat.MessageRevision{
+			MessageText: revision.Message,
+			WrittenAt:   timestamppb.New(revision.WrittenAt),
+			ReplacedAt:  timestamppb.New(revision.CreatedAt),
+		})
+	}
+
+	s.Logger.InfoF("Found %d revisions of message %s", len(resp.Revisions), req.GetMessageUuid())
+	return resp, nil
+}
+
+func (s *ChatService) GetThread(ctx context.Context, req *chat.GetThreadRequest) (*chat.GetThreadResponse, error) {
+	s.Logger.InfoF("gRPC ChatService: GetThread initiated for message %s", req.GetRootMessageId())
+
+	if err := ValidateGetThread(req); err != nil {
+		s.Logger.ErrorF("Validation error for GetThread request: %v", err)
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	tableUser, err := s.chatMember(ctx, req.GetTableId())
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize := req.GetPageSize()
+	if pageSize <= 0 || pageSize > 100 {
+		pageSize = 50
+	}
+
+	// Deleted messages stay in the thread as tombstones, so Unscoped
+	visible := s.Db.WithContext(ctx).Unscoped().
+		Preload("TableUser.User").
+		Preload("Recipients").
+		Scopes(withCharacter).
+		Where("table_id = ?", req.GetTableId()).
+		Scopes(utils.VisibleChatMessages(tableUser))
+
+	var root models.ChatMessage
+	if err := visible.Session(&gorm.Session{}).Where("id = ?", req.GetRootMessageId()).First(&root).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.Logger.ErrorF("Message %s not found in table %d", req.GetRootMessageId(), req.GetTableId())
+			return nil, status.Errorf(codes.NotFound, "message not found")
+		}
+		s.Logger.ErrorF("Database error finding message %s: %v", req.GetRootMessageId(), err)
+		return nil, status.Errorf(codes.Internal, "database error")
+	}
+
+	// One more than the page tells whether there is a next page
+	query := visible.Session(&gorm.Session{}).
+		Where("id IN ("+threadMessageIDs+")", root.ID).
+		Where("id <> ?", root.ID).
+		Order("created_at, id").
+		Limit(int(pageSize) + 1)
+
+	if req.GetLastMessageId() != "" {
+		var cursorMessage models.ChatMessage
+		if err := s.Db.WithContext(ctx).Unscoped().Select("id, created_at").First(&cursorMessage, "id = ?", req.GetLastMessageId()).Error; err == nil {
+			query = query.Where("(created_at, id) > (?, ?)", cursorMessage.CreatedAt, cursorMessage.ID)
+		} else {
+			s.Logger.WarningF("Could not find cursor message %s, ignoring cursor", req.GetLastMessageId())
+		}
+	}
+
+	var replies []models.ChatMessage
+	if err := query.Find(&replies).Error; err != nil {
+		s.Logger.ErrorF("Database error loading the thread of message %s: %v", req.GetRootMessageId(), err)
+		return nil, status.Errorf(codes.Internal, "failed to retrieve thread")
+	}
+
+	statusResolver, err := s.newMessageStatusResolver(ctx, tableUser.TableID)
+	if err != nil {
+		s.Logger.ErrorF("Database error loading read states for table %d: %v", req.GetTableId(), err)
+		return nil, status.Errorf(codes.Internal, "failed to retrieve thread")
+	}
+	threadMessage := func(msg models.ChatMessage) *chat.ChatMessageResponse {
+		respMsg := s.newChatMessageResponse(msg, msg.TableUser.User.Username)
+		respMsg.MessageStatus = statusResolver.status(msg)
+		if respMsg.IsDeleted {
+			tombstone(respMsg)
+		}
+		return respMsg
+	}
+
+	resp := &chat.GetThreadResponse{Root: threadMessage(root)}
+	if len(replies) > int(pageSize) {
+		replies = replies[:pageSize]
+		nextCursor := replies[len(replies)-1].ID.String()
+		resp.NextCursor = &nextCursor
+	}
+	for _, reply := range replies {
+		resp.Replies = append(resp.Replies, threadMessage(reply))
+	}
+
+	s.Logger.InfoF("Found %d replies in the thread of message %s", len(resp.Replies), req.GetRootMessageId())
+	return resp, nil
+}
+
+// tombstone leaves only the place of a deleted message in the thread: who sent it, when and what it replied to
+func tombstone(msg *chat.ChatMessageResponse) {
+	msg.MessageText = ""
+	msg.MediaUrl = nil
+	msg.Attachments = nil
+	msg.Roll = nil
+	msg.IsEmote = false
+	msg.UpdatedAt = nil
+	msg.RevisionCount = 0
+}
+
+// chatMember finds the table user of the caller in the table, with the Table loaded for the visibility scope
+func (s *ChatService) chatMember(ctx context.Context, tableID uint64) (models.TableUser, error) {
+	var tableUser models.TableUser
+
+	userID, err := utils.PickUserIdJWT(ctx)
+	if err != nil {
+		s.Logger.ErrorF("UserID not found in context")
+		return tableUser, status.Errorf(codes.Internal, "error processing user identity")
+	}
+
+	if err := s.Db.WithContext(ctx).Preload("Table").Where("user_id = ? AND table_id = ?", userID, tableID).First(&tableUser).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.Logger.ErrorF("Authorization error: User %d is not a member of table %d", userID, tableID)
+			return tableUser, status.Errorf(codes.PermissionDenied, "user is not a member of the specified table")
+		}
+		s.Logger.ErrorF("Database error checking membership of user %d in table %d: %v", userID, tableID, err)
+		return tableUser, status.Errorf(codes.Internal, "database error")
+	}
+	return tableUser, nil
+}
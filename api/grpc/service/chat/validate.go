@@ -3,8 +3,10 @@ package chat
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/GarotoCowboy/vttProject/api/grpc/pb/chat"
+	"github.com/google/uuid"
 )
 
 func ErrParamIsRequired(name, typ string) error {
@@ -17,28 +19,62 @@ func Validate(req *chat.SendMessageRequest) error {
 		return ErrParamIsRequired("table_id", "uint64")
 	}
 
-	switch req.GetMessageType() {
+	if _, ok := chat.ChatChannel_name[int32(req.GetChannel())]; !ok {
+		return fmt.Errorf("invalid channel provided")
+	}
+	if req.CharacterId != nil && req.GetChannel() != chat.ChatChannel_IN_CHARACTER {
+		return fmt.Errorf("character_id is only allowed in the IN_CHARACTER channel")
+	}
+	if err := validateReplyTo(req.ReplyToMessageId); err != nil {
+		return err
+	}
+
+	return validateContent(req.GetMessageType(), req.GetMessageText(), req.GetMediaUrl(), req.GetAttachments())
+}
+
+// ValidatePrivateMessage checks the content of a whisper like Validate, the recipients are checked by SendPrivateMessage
+func ValidatePrivateMessage(req *chat.SendPrivateMessageRequest) error {
+	if err := validateReplyTo(req.ReplyToMessageId); err != nil {
+		return err
+	}
+	return validateContent(req.GetMessageType(), req.GetMessage(), req.GetMediaUrl(), req.GetAttachments())
+}
+
+// validateReplyTo checks the format of the replied message, SendMessage and SendPrivateMessage check that it can be seen
+func validateReplyTo(replyTo *string) error {
+	if replyTo == nil {
+		return nil
+	}
+	if _, err := uuid.Parse(*replyTo); err != nil {
+		return fmt.Errorf("reply_to_message_id must be a valid uuid")
+	}
+	return nil
+}
+
+// validateContent checks that the text, media and attachments match the message type
+func validateContent(messageType chat.MessageType, text, mediaURL string, attachments []string) error {
+	switch messageType {
 	case chat.MessageType_TEXT:
-		if strings.TrimSpace(req.GetMessageText()) == "" {
+		if strings.TrimSpace(text) == "" {
 			return fmt.Errorf("message_text cannot be empty for message type TEXT")
 		}
-		if req.GetMediaUrl() != "" || len(req.GetAttachments()) > 0 {
+		if mediaURL != "" || len(attachments) > 0 {
 			return fmt.Errorf("media_url and attachments are not allowed for message type TEXT")
 		}
 	case chat.MessageType_IMAGE, chat.MessageType_VIDEO, chat.MessageType_AUDIO:
-		if strings.TrimSpace(req.GetMediaUrl()) == "" {
+		if strings.TrimSpace(mediaURL) == "" {
 			return fmt.Errorf("media_url cannot be empty for media message types")
 		}
-		if req.GetMessageText() != "" || len(req.GetAttachments()) > 0 {
+		if text != "" || len(attachments) > 0 {
 			return fmt.Errorf("message_text and attachments are not allowed for media message types")
 		}
 	case chat.MessageType_DOCUMENT:
 		// Para documentos, deve haver pelo menos um anexo.
-		if len(req.GetAttachments()) == 0 {
+		if len(attachments) == 0 {
 			return fmt.Errorf("at least one attachment is required for message type DOCUMENT")
 		}
 		// E não deve haver texto ou URL de mídia.
-		if req.GetMessageText() != "" || req.GetMediaUrl() != "" {
+		if text != "" || mediaURL != "" {
 			return fmt.Errorf("message_text and media_url are not allowed for message type DOCUMENT")
 		}
 
@@ -53,3 +89,98 @@ func Validate(req *chat.SendMessageRequest) error {
 
 	return nil
 }
+
+func ValidateMarkMessages(req *chat.MarkMessagesRequest) error {
+
+	if req.GetTableId() == 0 {
+		return ErrParamIsRequired("table_id", "uint64")
+	}
+	if strings.TrimSpace(req.GetUpToMessageId()) == "" {
+		return ErrParamIsRequired("up_to_message_id", "string")
+	}
+	if req.GetStatus() != chat.MessageStatus_DELIVERED && req.GetStatus() != chat.MessageStatus_READ {
+		return fmt.Errorf("status must be DELIVERED or READ")
+	}
+
+	return nil
+}
+
+func ValidateSearchMessages(req *chat.SearchMessagesRequest) error {
+
+	if req.GetTableId() == 0 {
+		return ErrParamIsRequired("table_id", "uint64")
+	}
+	if strings.TrimSpace(req.GetQuery()) == "" {
+		return ErrParamIsRequired("query", "string")
+	}
+	if len(req.GetQuery()) > maxSearchQueryLength {
+		return fmt.Errorf("query cannot be longer than %d characters", maxSearchQueryLength)
+	}
+	if req.GetOffset() < 0 {
+		return fmt.Errorf("offset cannot be negative")
+	}
+	if req.GetFrom() != nil && req.GetTo() != nil && !req.GetTo().AsTime().After(req.GetFrom().AsTime()) {
+		return fmt.Errorf("to must be after from")
+	}
+
+	return nil
+}
+
+func ValidateMuteTableUser(req *chat.MuteTableUserRequest) error {
+
+	if req.GetTableId() == 0 {
+		return ErrParamIsRequired("table_id", "uint64")
+	}
+	if req.GetTableUserId() == 0 {
+		return ErrParamIsRequired("table_user_id", "uint64")
+	}
+	if req.GetDurationSeconds() < 0 || time.Duration(req.GetDurationSeconds())*time.Second > maxMuteDuration {
+		return fmt.Errorf("duration_seconds must be between 0 and %d", int64(maxMuteDuration.Seconds()))
+	}
+	if len(req.GetReason()) > maxModerationReasonLength {
+		return fmt.Errorf("reason cannot be longer than %d characters", maxModerationReasonLength)
+	}
+
+	return nil
+}
+
+func ValidateSetSlowMode(req *chat.SetSlowModeRequest) error {
+
+	if req.GetTableId() == 0 {
+		return ErrParamIsRequired("table_id", "uint64")
+	}
+	if req.GetIntervalSeconds() < 0 || req.GetIntervalSeconds() > maxSlowModeSeconds {
+		return fmt.Errorf("interval_seconds must be between 0 and %d", maxSlowModeSeconds)
+	}
+
+	return nil
+}
+
+func ValidateListMessageRevisions(req *chat.ListMessageRevisionsRequest) error {
+
+	if req.GetTableId() == 0 {
+		return ErrParamIsRequired("table_id", "uint64")
+	}
+	if _, err := uuid.Parse(req.GetMessageUuid()); err != nil {
+		return fmt.Errorf("message_uuid must be a valid uuid")
+	}
+
+	return nil
+}
+
+func ValidateGetThread(req *chat.GetThreadRequest) error {
+
+	if req.GetTableId() == 0 {
+		return ErrParamIsRequired("table_id", "uint64")
+	}
+	if _, err := uuid.Parse(req.GetRootMessageId()); err != nil {
+		return fmt.Errorf("root_message_id must be a valid uuid")
+	}
+	if req.LastMessageId != nil {
+		if _, err := uuid.Parse(req.GetLastMessageId()); err != nil {
+			return fmt.Errorf("last_message_id must be a valid uuid")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package drag
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/GarotoCowboy/vttProject/api/utils"
+	"github.com/GarotoCowboy/vttProject/config"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// PreviewInterval is how often the latest position of a drag is sent to the scene
+const PreviewInterval = 50 * time.Millisecond
+
+// Per-user limits: drags opened per second (and burst), drags open at the same time,
+// and previews per second (and burst) summed over all the drags of the user
+const (
+	openRate       = 2
+	openBurst      = 10
+	maxActiveDrags = 2
+	previewRate    = 30
+	previewBurst   = 30
+)
+
+// Limiter keeps the drags of each user in check, a user cannot open more drag streams to
+// multiply the previews, nor open them in a loop to run the permission queries of each one
+type Limiter struct {
+	opens    *utils.RateLimiter
+	previews *utils.RateLimiter
+
+	mu     sync.Mutex
+	active map[uint]int
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{
+		opens:    utils.NewRateLimiter(openRate, openBurst),
+		previews: utils.NewRateLimiter(previewRate, previewBurst),
+		active:   make(map[uint]int),
+	}
+}
+
+// Start counts a new drag of the user, release must be called when the drag ends
+func (l *Limiter) Start(userID uint) (release func(), err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.active[userID] >= maxActiveDrags {
+		return nil, status.Errorf(codes.ResourceExhausted, "too many drags at the same time")
+	}
+	if !l.opens.Allow(userID) {
+		return nil, status.Errorf(codes.ResourceExhausted, "too many drags")
+	}
+	l.active[userID]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if l.active[userID]--; l.active[userID] <= 0 {
+				delete(l.active, userID)
+			}
+		})
+	}, nil
+}
+
+// Move is a position of a drag, the token and image requests have it
+type Move interface {
+	GetSceneId() uint64
+}
+
+// Receiver is the client side of a drag stream
+type Receiver[M Move] interface {
+	Recv() (M, error)
+}
+
+// Drag is one open drag stream, its first position was already received and checked
+type Drag[M Move] struct {
+	Stream Receiver[M]
+	Logger *config.Logger
+	UserID uint
+	First  M
+	// ObjectID returns the placed object a position moves, it cannot change during the drag
+	ObjectID func(M) uint64
+	// Preview publishes a position to the scene
+	Preview func(M)
+}
+
+// Run sends the latest position of the drag as a preview every PreviewInterval, until the client closes
+// its side. The last position is returned to be saved
+func Run[M Move](ctx context.Context, limiter *Limiter, d Drag[M]) (M, error) {
+	moves := make(chan M)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			req, err := d.Stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case moves <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(PreviewInterval)
+	defer ticker.Stop()
+
+	// Only the latest position matters, the ones received between two ticks are discarded
+	latest := d.First
+	pending := true
+
+	for {
+		select {
+		case <-ctx.Done():
+			return latest, ctx.Err()
+		case req := <-moves:
+			if req.GetSceneId() != d.First.GetSceneId() || d.ObjectID(req) != d.ObjectID(d.First) {
+				d.Logger.ErrorF("user %d changed the object or the scene in the middle of a drag", d.UserID)
+				return latest, status.Errorf(codes.InvalidArgument, "all positions of a drag must have the same scene and object")
+			}
+			latest = req
+			pending = true
+		case <-ticker.C:
+			// a position over the limit stays pending for the next tick
+			if !pending || !limiter.previews.Allow(d.UserID) {
+				continue
+			}
+			d.Preview(latest)
+			pending = false
+		case err := <-recvErr:
+			if err != io.EOF {
+				d.Logger.ErrorF("failed to receive position from stream: %v", err)
+				return latest, err
+			}
+			// The client dropped the object, the last position is the one saved
+			return latest, nil
+		}
+	}
+}
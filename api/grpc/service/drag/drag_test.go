@@ -0,0 +1,42 @@
+package drag
+
+import "testing"
+
+func TestLimiterCapsTheDragsOpenAtTheSameTime(t *testing.T) {
+	l := NewLimiter()
+
+	var releases []func()
+	for i := 0; i < maxActiveDrags; i++ {
+		release, err := l.Start(1)
+		if err != nil {
+			t.Fatalf("drag %d refused: %v", i, err)
+		}
+		releases = append(releases, release)
+	}
+
+	if _, err := l.Start(1); err == nil {
+		t.Fatalf("drag over the limit was accepted")
+	}
+	if release, err := l.Start(2); err != nil {
+		t.Errorf("another user was refused: %v", err)
+	} else {
+		release()
+	}
+
+	// releasing twice must not free a slot of another drag
+	releases[0]()
+	releases[0]()
+	release, err := l.Start(1)
+	if err != nil {
+		t.Fatalf("drag refused after a release: %v", err)
+	}
+	if _, err := l.Start(1); err == nil {
+		t.Fatalf("a double release freed two drags")
+	}
+	release()
+	releases[1]()
+
+	if len(l.active) != 0 {
+		t.Errorf("active drags left: %v", l.active)
+	}
+}
@@ -0,0 +1,20 @@
+package eventLog
+
+import (
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/eventLog"
+	"github.com/GarotoCowboy/vttProject/config"
+	"gorm.io/gorm"
+)
+
+type EventLogService struct {
+	eventLog.UnimplementedEventLogServiceServer
+	Logger *config.Logger
+	DB     *gorm.DB
+}
+
+func NewEventLogService(db *gorm.DB, logger *config.Logger) *EventLogService {
+	return &EventLogService{
+		DB:     db,
+		Logger: logger,
+	}
+}
@@ -0,0 +1,298 @@
+package eventLog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/eventLog"
+	syncBroker "github.com/GarotoCowboy/vttProject/api/grpc/pb/sync"
+	"github.com/GarotoCowboy/vttProject/api/models"
+	"github.com/GarotoCowboy/vttProject/api/models/consts"
+	"github.com/GarotoCowboy/vttProject/api/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+const (
+	// recordingVersion is the version of the SessionRecording written by ExportSession
+	recordingVersion = 1
+	// maxExportEvents bounds the recording built in memory
+	maxExportEvents = 20000
+	// exportChunkSize keeps each message of ExportSession well under the default 4MB limit of gRPC
+	exportChunkSize = 1 << 20
+	// maxReplaySpeed limits how fast a recording is streamed back
+	maxReplaySpeed = 100
+	// maxRecordingSize bounds the file uploaded to ReplaySession, it is kept in memory
+	maxRecordingSize = 64 << 20
+)
+
+func (s *EventLogService) ListTableEvents(ctx context.Context, req *eventLog.ListTableEventsRequest) (*eventLog.ListTableEventsResponse, error) {
+	s.Logger.InfoF("GRPC Requisition to ListTableEvents on table %d", req.TableId)
+
+	// 1. Validation
+	from, to, err := validateTimeRange(req.TableId, req.From, req.To)
+	if err != nil {
+		s.Logger.ErrorF("Invalid request: %v", err)
+		return nil, err
+	}
+
+	// 2. Pagination Setup
+	pageSize := int(req.PageSize)
+	if pageSize <= 0 {
+		pageSize = 100 // Default page size
+	}
+	if pageSize > 500 {
+		pageSize = 500 // Max page size
+	}
+
+	// 3. Query Build, only the events the user could see
+	query, err := s.visibleEvents(ctx, req.TableId, from, to)
+	if err != nil {
+		return nil, err
+	}
+	// Keyset pagination: if a page token (last ID) is provided, fetch items after it
+	if req.PageToken != "" {
+		lastID, err := strconv.ParseUint(req.PageToken, 10, 64)
+		if err != nil {
+			s.Logger.ErrorF("Invalid page token: %s", req.PageToken)
+			return nil, status.Errorf(codes.InvalidArgument, "invalid page token")
+		}
+		query = query.Where("id > ?", lastID)
+	}
+
+	var eventModels []models.TableEvent
+	// Fetch (pageSize + 1) items to check if a next page exists
+	if err := query.Limit(pageSize + 1).Find(&eventModels).Error; err != nil {
+		s.Logger.ErrorF("Failed to list table events from DB: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to list table events")
+	}
+
+	var nextPageToken string
+	if len(eventModels) > pageSize {
+		nextPageToken = fmt.Sprintf("%d", eventModels[pageSize-1].ID)
+		eventModels = eventModels[:pageSize]
+	}
+
+	events, err := tableEventsToProto(eventModels)
+	if err != nil {
+		s.Logger.ErrorF("Failed to decode table events: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to decode table events")
+	}
+
+	s.Logger.InfoF("GRPC Requisition to ListTableEvents finished successfully.")
+	return &eventLog.ListTableEventsResponse{
+		Events:        events,
+		NextPageToken: nextPageToken,
+	}, nil
+}
+
+func (s *EventLogService) ExportSession(req *eventLog.ExportSessionRequest, stream eventLog.EventLogService_ExportSessionServer) error {
+	s.Logger.InfoF("GRPC Requisition to ExportSession on table %d", req.TableId)
+
+	ctx := stream.Context()
+
+	from, to, err := validateTimeRange(req.TableId, req.From, req.To)
+	if err != nil {
+		s.Logger.ErrorF("Invalid request: %v", err)
+		return err
+	}
+
+	query, err := s.visibleEvents(ctx, req.TableId, from, to)
+	if err != nil {
+		return err
+	}
+
+	var eventModels []models.TableEvent
+	if err := query.Limit(maxExportEvents + 1).Find(&eventModels).Error; err != nil {
+		s.Logger.ErrorF("Failed to export table events from DB: %v", err)
+		return status.Errorf(codes.Internal, "failed to export table events")
+	}
+	if len(eventModels) > maxExportEvents {
+		s.Logger.WarningF("Session of table %d has more than %d events", req.TableId, maxExportEvents)
+		return status.Errorf(codes.InvalidArgument, "the session has more than %d events, export a shorter time range", maxExportEvents)
+	}
+
+	events, err := tableEventsToProto(eventModels)
+	if err != nil {
+		s.Logger.ErrorF("Failed to decode table events: %v", err)
+		return status.Errorf(codes.Internal, "failed to decode table events")
+	}
+
+	file, err := proto.Marshal(&eventLog.SessionRecording{
+		Version:    recordingVersion,
+		TableId:    req.TableId,
+		From:       timestamppb.New(from),
+		To:         timestamppb.New(to),
+		ExportedAt: timestamppb.Now(),
+		Events:     events,
+	})
+	if err != nil {
+		s.Logger.ErrorF("Failed to encode session recording: %v", err)
+		return status.Errorf(codes.Internal, "failed to encode session recording")
+	}
+
+	// the file is sent in chunks, the name and the event count go with the first one
+	chunk := &eventLog.ExportSessionResponse{
+		FileName:   fmt.Sprintf("table-%d-%s.vttrec", req.TableId, from.UTC().Format("20060102-150405")),
+		EventCount: uint32(len(events)),
+	}
+	for {
+		size := min(len(file), exportChunkSize)
+		chunk.File, file = file[:size], file[size:]
+		if err := stream.Send(chunk); err != nil {
+			s.Logger.ErrorF("error to send session recording: %v", err)
+			return err
+		}
+		if len(file) == 0 {
+			break
+		}
+		chunk = &eventLog.ExportSessionResponse{}
+	}
+
+	s.Logger.InfoF("GRPC Requisition to ExportSession finished with %d events.", len(events))
+	return nil
+}
+
+func (s *EventLogService) ReplaySession(stream eventLog.EventLogService_ReplaySessionServer) error {
+	s.Logger.InfoF("GRPC Requisition to ReplaySession started...")
+
+	// The options come with the first chunk, the file is complete when the client closes its side
+	req, err := stream.Recv()
+	if err == io.EOF {
+		s.Logger.ErrorF("replay stream closed without sending a recording")
+		return status.Errorf(codes.InvalidArgument, "no recording received")
+	}
+	if err != nil {
+		s.Logger.ErrorF("failed to receive recording chunk: %v", err)
+		return err
+	}
+
+	file := req.File
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.Logger.ErrorF("failed to receive recording chunk: %v", err)
+			return err
+		}
+		if len(file)+len(chunk.File) > maxRecordingSize {
+			s.Logger.ErrorF("recording file larger than %d bytes", maxRecordingSize)
+			return status.Errorf(codes.InvalidArgument, "recording file larger than %d bytes", maxRecordingSize)
+		}
+		file = append(file, chunk.File...)
+	}
+
+	var recording eventLog.SessionRecording
+	if err := proto.Unmarshal(file, &recording); err != nil {
+		s.Logger.ErrorF("Invalid recording file: %v", err)
+		return status.Errorf(codes.InvalidArgument, "invalid recording file")
+	}
+	if recording.Version != recordingVersion {
+		s.Logger.ErrorF("Unsupported recording version %d", recording.Version)
+		return status.Errorf(codes.InvalidArgument, "unsupported recording version %d", recording.Version)
+	}
+
+	speed := req.Speed
+	if speed == 0 {
+		speed = 1
+	}
+	if speed < 0 || speed > maxReplaySpeed {
+		return status.Errorf(codes.InvalidArgument, "speed must be between 0 and %d", maxReplaySpeed)
+	}
+	maxGap := time.Duration(req.MaxGapSeconds) * time.Second
+
+	ctx := stream.Context()
+
+	var previous time.Time
+	for i, event := range recording.Events {
+		createdAt := event.GetCreatedAt().AsTime()
+
+		// wait as much as between the original events
+		if i > 0 {
+			gap := createdAt.Sub(previous)
+			if maxGap > 0 && gap > maxGap {
+				gap = maxGap
+			}
+			if gap > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(time.Duration(float64(gap) / speed)):
+				}
+			}
+		}
+		previous = createdAt
+
+		if err := stream.Send(event); err != nil {
+			s.Logger.ErrorF("error to send replayed event: %v", err)
+			return err
+		}
+	}
+
+	s.Logger.InfoF("GRPC Requisition to ReplaySession finished with %d events.", len(recording.Events))
+	return nil
+}
+
+// visibleEvents builds the query of the events of the table in the time range that the user can read.
+// Masters read the events masters were an audience of, so whispers only when the table allowed it at the time,
+// players only the public events and the ones they were an audience of
+func (s *EventLogService) visibleEvents(ctx context.Context, tableID uint64, from, to time.Time) (*gorm.DB, error) {
+	userID, err := utils.PickUserIdJWT(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var tableUserModel models.TableUser
+	if err := s.DB.WithContext(ctx).Where("table_id = ? AND user_id = ?", tableID, userID).First(&tableUserModel).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.Logger.ErrorF("user %d is not a member of table %d", userID, tableID)
+			return nil, status.Errorf(codes.PermissionDenied, "user is not a member of the table")
+		}
+		s.Logger.ErrorF("Failed to check table member: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to check table member")
+	}
+
+	query := s.DB.WithContext(ctx).
+		Where("table_id = ? AND created_at >= ? AND created_at < ?", tableID, from, to).
+		Order("id ASC")
+
+	if tableUserModel.Role == consts.Master {
+		query = query.Where("public = ? OR masters = ? OR viewer_table_user_ids @> ?::jsonb",
+			true, true, strconv.FormatUint(uint64(tableUserModel.ID), 10))
+	} else {
+		query = query.Where("public = ? OR viewer_user_ids @> ?::jsonb OR viewer_table_user_ids @> ?::jsonb",
+			true, strconv.FormatUint(uint64(userID), 10), strconv.FormatUint(uint64(tableUserModel.ID), 10))
+	}
+
+	return query, nil
+}
+
+func tableEventsToProto(eventModels []models.TableEvent) ([]*eventLog.TableEvent, error) {
+	events := make([]*eventLog.TableEvent, 0, len(eventModels))
+	for _, model := range eventModels {
+		var msg syncBroker.SyncResponse
+		if err := proto.Unmarshal(model.Payload, &msg); err != nil {
+			return nil, fmt.Errorf("event %d: %v", model.ID, err)
+		}
+		events = append(events, &eventLog.TableEvent{
+			EventId:   model.ID,
+			TableId:   uint64(model.TableID),
+			SceneId:   uint64(model.SceneID),
+			Topic:     model.Topic,
+			Sequence:  model.Sequence,
+			Action:    model.Action,
+			CreatedAt: timestamppb.New(model.CreatedAt),
+			Event:     &msg,
+		})
+	}
+	return events, nil
+}
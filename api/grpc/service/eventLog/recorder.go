@@ -0,0 +1,186 @@
+package eventLog
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	syncBroker "github.com/GarotoCowboy/vttProject/api/grpc/pb/sync"
+	"github.com/GarotoCowboy/vttProject/api/grpc/service/sync/broker"
+	"github.com/GarotoCowboy/vttProject/api/models"
+	"github.com/GarotoCowboy/vttProject/api/models/consts/pubSubSyncConst"
+	"github.com/GarotoCowboy/vttProject/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+)
+
+const (
+	// recorderQueueSize events wait to be saved, when it is full new events are not saved
+	recorderQueueSize     = 4096
+	recorderBatchSize     = 200
+	recorderFlushInterval = 500 * time.Millisecond
+)
+
+// recorderDropped counts the events lost because the queue was full, the history of their table has a gap
+var recorderDropped = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "vtt",
+	Subsystem: "event_log",
+	Name:      "dropped_events_total",
+	Help:      "Events not saved in the event log because its queue was full.",
+})
+
+// Recorder is a broker observer that saves the published events in the history of their table.
+// Events are written in batches by a single goroutine, publishers never wait for the database
+type Recorder struct {
+	db     *gorm.DB
+	logger *config.Logger
+	events chan recordedEvent
+	// sceneTables caches the table of each scene, only used by the writer goroutine
+	sceneTables map[uint64]uint
+}
+
+type recordedEvent struct {
+	topicType pubSubSyncConst.PubSubSyncType
+	id        uint64
+	msg       *syncBroker.SyncResponse
+	// visibility is the audience the broker resolved when the event was published
+	visibility broker.Visibility
+	createdAt  time.Time
+}
+
+func NewRecorder(db *gorm.DB, logger *config.Logger) *Recorder {
+	r := &Recorder{
+		db:          db,
+		logger:      logger,
+		events:      make(chan recordedEvent, recorderQueueSize),
+		sceneTables: make(map[uint64]uint),
+	}
+	go r.run()
+	return r
+}
+
+func (r *Recorder) Observe(topicType pubSubSyncConst.PubSubSyncType, id uint64, msg *syncBroker.SyncResponse, visibility broker.Visibility) {
+	// user topics are not part of the history of a table
+	if topicType == pubSubSyncConst.UserSync {
+		return
+	}
+	select {
+	case r.events <- recordedEvent{topicType: topicType, id: id, msg: msg, visibility: visibility, createdAt: time.Now()}:
+	default:
+		recorderDropped.Inc()
+		r.logger.WarningF("event log queue is full, event %d of %s was not saved", msg.GetSequence(), msg.GetTopic())
+	}
+}
+
+func (r *Recorder) run() {
+	ticker := time.NewTicker(recorderFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.TableEvent, 0, recorderBatchSize)
+	for {
+		select {
+		case event := <-r.events:
+			row, err := r.toModel(event)
+			if err != nil {
+				r.logger.ErrorF("error preparing event %d of %s for the event log: %v", event.msg.GetSequence(), event.msg.GetTopic(), err)
+				continue
+			}
+			batch = append(batch, row)
+			if len(batch) < recorderBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+
+		if err := r.db.Create(&batch).Error; err != nil {
+			r.logger.ErrorF("error saving %d events to the event log: %v", len(batch), err)
+		}
+		batch = make([]models.TableEvent, 0, recorderBatchSize)
+	}
+}
+
+func (r *Recorder) toModel(event recordedEvent) (models.TableEvent, error) {
+	msg := event.msg
+	row := models.TableEvent{
+		Topic:     msg.GetTopic(),
+		Sequence:  msg.GetSequence(),
+		Action:    actionName(msg),
+		CreatedAt: event.createdAt,
+	}
+
+	switch event.topicType {
+	case pubSubSyncConst.TableSync:
+		row.TableID = uint(event.id)
+	case pubSubSyncConst.SceneSync:
+		tableID, err := r.sceneTable(event.id)
+		if err != nil {
+			return row, err
+		}
+		row.SceneID = uint(event.id)
+		row.TableID = tableID
+	default:
+		return row, fmt.Errorf("unknown topic type %d", event.topicType)
+	}
+
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return row, err
+	}
+	row.Payload = payload
+
+	// the audience is saved with the event, later permission changes do not reveal old events
+	visibility := event.visibility
+	row.Public = visibility.Public
+	row.Masters = visibility.Masters
+	if row.ViewerUserIDs, err = idsJSON(visibility.UserIDs); err != nil {
+		return row, err
+	}
+	if row.ViewerTableUserIDs, err = idsJSON(visibility.TableUserIDs); err != nil {
+		return row, err
+	}
+
+	return row, nil
+}
+
+// sceneTable returns the table of a scene, deleted scenes included
+func (r *Recorder) sceneTable(sceneID uint64) (uint, error) {
+	if tableID, ok := r.sceneTables[sceneID]; ok {
+		return tableID, nil
+	}
+
+	var tableID uint
+	if err := r.db.Unscoped().Model(&models.Scene{}).Select("table_id").Where("id = ?", sceneID).Scan(&tableID).Error; err != nil {
+		return 0, err
+	}
+	if tableID == 0 {
+		return 0, fmt.Errorf("scene %d not found", sceneID)
+	}
+
+	r.sceneTables[sceneID] = tableID
+	return tableID, nil
+}
+
+// actionName returns the name of the action set in the oneof of the event, e.g. "placed_token_moved"
+func actionName(msg *syncBroker.SyncResponse) string {
+	m := msg.ProtoReflect()
+	field := m.WhichOneof(m.Descriptor().Oneofs().ByName("action"))
+	if field == nil {
+		return ""
+	}
+	return string(field.Name())
+}
+
+func idsJSON(ids map[uint]struct{}) ([]byte, error) {
+	list := make([]uint, 0, len(ids))
+	for id := range ids {
+		list = append(list, id)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	return json.Marshal(list)
+}
@@ -0,0 +1,32 @@
+package eventLog
+
+import (
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// validateTimeRange checks the range of a request, a missing end is now
+func validateTimeRange(tableID uint64, from, to *timestamppb.Timestamp) (time.Time, time.Time, error) {
+
+	if tableID == 0 {
+		return time.Time{}, time.Time{}, status.Errorf(codes.InvalidArgument, "table_id cannot be null")
+	}
+
+	if from == nil {
+		return time.Time{}, time.Time{}, status.Errorf(codes.InvalidArgument, "from cannot be null")
+	}
+
+	end := time.Now()
+	if to != nil {
+		end = to.AsTime()
+	}
+
+	if !end.After(from.AsTime()) {
+		return time.Time{}, time.Time{}, status.Errorf(codes.InvalidArgument, "to must be after from")
+	}
+
+	return from.AsTime(), end, nil
+}
@@ -16,6 +16,7 @@ import (
 
 	"github.com/GarotoCowboy/vttProject/api/grpc/events"
 	"github.com/GarotoCowboy/vttProject/api/grpc/pb/imageLibrary"
+	"github.com/GarotoCowboy/vttProject/api/grpc/service/sync/broker"
 	"github.com/GarotoCowboy/vttProject/api/models"
 	"github.com/GarotoCowboy/vttProject/api/models/consts/pubSubSyncConst"
 	"github.com/GarotoCowboy/vttProject/api/utils"
@@ -48,6 +49,12 @@ func (s *ImageLibraryService) UploadImage(stream imageLibrary.ImageLibraryServic
 		return status.Errorf(codes.InvalidArgument, "invalid initial upload data: %v", err)
 	}
 
+	// The stream interceptor authenticated the user, but the table only arrives in the init message
+	if err := utils.CheckUserIsTableMember(stream.Context(), s.DB, uint(initMsg.TableId)); err != nil {
+		s.Logger.ErrorF("user cannot upload images to table %d: %v", initMsg.TableId, err)
+		return err
+	}
+
 	s.Logger.InfoF("Receiving image '%s' for table %d", initMsg.Name, initMsg.TableId)
 
 	// --- 2. Receive Image Chunks ---
@@ -327,8 +334,9 @@ func (s *ImageLibraryService) DeleteImage(ctx context.Context, req *imageLibrary
 	s.Logger.InfoF("Deleted image metadata from DB: ID %d", imageModel.ID)
 
 	// --- 4. Publish Event ---
+	// only who could see the image is told it was deleted
 	event := events.NewImageLibraryDeletedEvent(req.ImageId, req.TableId)
-	s.Broker.Publish(pubSubSyncConst.TableSync, req.TableId, event)
+	s.Broker.PublishTo(pubSubSyncConst.TableSync, req.TableId, event, broker.PermissionVisibility(imageModel.CanBeViewedBy, nil))
 	s.Logger.InfoF("Published ImageLibraryDeleted event to TableSync for table %d", req.TableId)
 
 	return &emptypb.Empty{}, nil
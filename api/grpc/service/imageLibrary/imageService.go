@@ -11,10 +11,10 @@ type ImageLibraryService struct {
 	image_library.UnimplementedImageLibraryServiceServer
 	DB     *gorm.DB
 	Logger *config.Logger
-	Broker *broker.Broker
+	Broker broker.Broker
 }
 
-func NewImageLibraryService(db *gorm.DB, Logger *config.Logger, broker *broker.Broker) *ImageLibraryService {
+func NewImageLibraryService(db *gorm.DB, Logger *config.Logger, broker broker.Broker) *ImageLibraryService {
 	return &ImageLibraryService{
 		DB:     db,
 		Logger: Logger,
@@ -0,0 +1,20 @@
+package notification
+
+import (
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/notification"
+	"github.com/GarotoCowboy/vttProject/config"
+	"gorm.io/gorm"
+)
+
+type NotificationService struct {
+	notification.UnimplementedNotificationServiceServer
+	Logger *config.Logger
+	DB     *gorm.DB
+}
+
+func NewNotificationService(db *gorm.DB, logger *config.Logger) *NotificationService {
+	return &NotificationService{
+		DB:     db,
+		Logger: logger,
+	}
+}
@@ -0,0 +1,139 @@
+package notification
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/notification"
+	"github.com/GarotoCowboy/vttProject/api/models"
+	"github.com/GarotoCowboy/vttProject/api/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+func (s *NotificationService) ListNotifications(ctx context.Context, req *notification.ListNotificationsRequest) (*notification.ListNotificationsResponse, error) {
+	userID, err := utils.PickUserIdJWT(ctx)
+	if err != nil {
+		s.Logger.ErrorF("UserID not found in context")
+		return nil, status.Errorf(codes.Internal, "error processing user identity")
+	}
+	s.Logger.InfoF("GRPC Requisition to ListNotifications of user %d", userID)
+
+	// 1. Pagination Setup
+	pageSize := int(req.PageSize)
+	if pageSize <= 0 {
+		pageSize = 50 // Default page size
+	}
+	if pageSize > 200 {
+		pageSize = 200 // Max page size
+	}
+
+	// 2. Query Build, newest first
+	query := s.inbox(ctx, userID).
+		Preload("Table").
+		Preload("FromTableUser.User").
+		Order("id DESC")
+	if req.UnreadOnly {
+		query = query.Where("read_at IS NULL")
+	}
+	// Keyset pagination: if a page token (last ID) is provided, fetch items before it
+	if req.PageToken != "" {
+		lastID, err := strconv.ParseUint(req.PageToken, 10, 64)
+		if err != nil {
+			s.Logger.ErrorF("Invalid page token: %s", req.PageToken)
+			return nil, status.Errorf(codes.InvalidArgument, "invalid page token")
+		}
+		query = query.Where("id < ?", lastID)
+	}
+
+	var notificationModels []models.Notification
+	// Fetch (pageSize + 1) items to check if a next page exists
+	if err := query.Limit(pageSize + 1).Find(&notificationModels).Error; err != nil {
+		s.Logger.ErrorF("Failed to list notifications from DB: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to list notifications")
+	}
+
+	var nextPageToken string
+	if len(notificationModels) > pageSize {
+		nextPageToken = strconv.FormatUint(uint64(notificationModels[pageSize-1].ID), 10)
+		notificationModels = notificationModels[:pageSize]
+	}
+
+	var unreadCount int64
+	if err := s.inbox(ctx, userID).Where("read_at IS NULL").Count(&unreadCount).Error; err != nil {
+		s.Logger.ErrorF("Failed to count unread notifications: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to list notifications")
+	}
+
+	resp := &notification.ListNotificationsResponse{
+		NextPageToken: nextPageToken,
+		UnreadCount:   unreadCount,
+	}
+	for _, n := range notificationModels {
+		resp.Notifications = append(resp.Notifications, NotificationToProto(n))
+	}
+
+	s.Logger.InfoF("GRPC Requisition to ListNotifications finished successfully.")
+	return resp, nil
+}
+
+func (s *NotificationService) AcknowledgeNotifications(ctx context.Context, req *notification.AcknowledgeNotificationsRequest) (*emptypb.Empty, error) {
+	userID, err := utils.PickUserIdJWT(ctx)
+	if err != nil {
+		s.Logger.ErrorF("UserID not found in context")
+		return nil, status.Errorf(codes.Internal, "error processing user identity")
+	}
+	s.Logger.InfoF("GRPC Requisition to AcknowledgeNotifications of user %d", userID)
+
+	if err := Validate(req); err != nil {
+		s.Logger.ErrorF("Invalid request: %v", err)
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	// Only the notifications of the caller, acknowledging twice keeps the first read time
+	query := s.DB.WithContext(ctx).Model(&models.Notification{}).Where("user_id = ? AND read_at IS NULL", userID)
+	if !req.All {
+		query = query.Where("id IN ?", req.NotificationIds)
+	}
+	result := query.Update("read_at", time.Now())
+	if result.Error != nil {
+		s.Logger.ErrorF("Failed to acknowledge notifications of user %d: %v", userID, result.Error)
+		return nil, status.Errorf(codes.Internal, "failed to acknowledge notifications")
+	}
+
+	s.Logger.InfoF("%d notifications of user %d acknowledged", result.RowsAffected, userID)
+	return &emptypb.Empty{}, nil
+}
+
+// inbox is the query of the notifications of a user. Notifications of deleted messages are hidden
+func (s *NotificationService) inbox(ctx context.Context, userID uint) *gorm.DB {
+	return s.DB.WithContext(ctx).Model(&models.Notification{}).
+		Where("user_id = ?", userID).
+		Where("chat_message_id IS NULL OR EXISTS (SELECT 1 FROM chat_messages m WHERE m.id = notifications.chat_message_id AND m.deleted_at IS NULL)")
+}
+
+// NotificationToProto converts a saved notification, its Table and FromTableUser.User must be loaded
+func NotificationToProto(n models.Notification) *notification.Notification {
+	resp := &notification.Notification{
+		NotificationId:  uint64(n.ID),
+		Type:            notification.NotificationType(n.Type),
+		TableId:         uint64(n.TableID),
+		TableName:       n.Table.Name,
+		FromTableUserId: uint64(n.FromTableUserID),
+		FromUsername:    n.FromTableUser.User.Username,
+		Preview:         n.Preview,
+		CreatedAt:       timestamppb.New(n.CreatedAt),
+	}
+	if n.ChatMessageID != nil {
+		messageUUID := n.ChatMessageID.String()
+		resp.MessageUuid = &messageUUID
+	}
+	if n.ReadAt != nil {
+		resp.ReadAt = timestamppb.New(*n.ReadAt)
+	}
+	return resp
+}
@@ -0,0 +1,25 @@
+package notification
+
+import (
+	"fmt"
+
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/notification"
+)
+
+// maxAcknowledgedIDs limits the ids of a single AcknowledgeNotifications, all acknowledges any number
+const maxAcknowledgedIDs = 500
+
+func Validate(req *notification.AcknowledgeNotificationsRequest) error {
+
+	if req.GetAll() {
+		return nil
+	}
+	if len(req.GetNotificationIds()) == 0 {
+		return fmt.Errorf("notification_ids cannot be empty when all is false")
+	}
+	if len(req.GetNotificationIds()) > maxAcknowledgedIDs {
+		return fmt.Errorf("cannot acknowledge more than %d notifications at once, use all", maxAcknowledgedIDs)
+	}
+
+	return nil
+}
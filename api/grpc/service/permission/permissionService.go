@@ -11,10 +11,10 @@ type PermissionService struct {
 	permission.UnimplementedPermissionServiceServer
 	DB     *gorm.DB
 	Logger *config.Logger
-	Broker *broker.Broker
+	Broker broker.Broker
 }
 
-func NewPermissionService(db *gorm.DB, logger *config.Logger, broker *broker.Broker) *PermissionService {
+func NewPermissionService(db *gorm.DB, logger *config.Logger, broker broker.Broker) *PermissionService {
 	return &PermissionService{
 		DB:     db,
 		Logger: logger,
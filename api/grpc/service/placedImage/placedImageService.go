@@ -2,7 +2,9 @@ package placedImage
 
 import (
 	"github.com/GarotoCowboy/vttProject/api/grpc/pb/placedImage"
+	"github.com/GarotoCowboy/vttProject/api/grpc/service/drag"
 	"github.com/GarotoCowboy/vttProject/api/grpc/service/sync/broker"
+	"github.com/GarotoCowboy/vttProject/api/utils"
 	"github.com/GarotoCowboy/vttProject/config"
 	"gorm.io/gorm"
 )
@@ -11,13 +13,25 @@ type PlacedImageService struct {
 	placedImage.UnimplementedPlacedImageServiceServer
 	Logger *config.Logger
 	DB     *gorm.DB
-	Broker *broker.Broker
+	Broker broker.Broker
+	// MoveLimiter limits the saved moves per user, DragLimiter the drag streams and their previews
+	MoveLimiter *utils.RateLimiter
+	DragLimiter *drag.Limiter
 }
 
-func NewPlacedImageService(db *gorm.DB, logger *config.Logger, broker *broker.Broker) *PlacedImageService {
+// Per-user limit of saved moves per second (and burst)
+const (
+	moveRate  = 10
+	moveBurst = 20
+)
+
+func NewPlacedImageService(db *gorm.DB, logger *config.Logger, broker broker.Broker) *PlacedImageService {
 	return &PlacedImageService{
 		DB:     db,
 		Logger: logger,
 		Broker: broker,
+
+		MoveLimiter: utils.NewRateLimiter(moveRate, moveBurst),
+		DragLimiter: drag.NewLimiter(),
 	}
 }
@@ -3,9 +3,12 @@ package placedImage
 import (
 	"context"
 	"errors"
+	"io"
 
 	"github.com/GarotoCowboy/vttProject/api/grpc/events"
 	"github.com/GarotoCowboy/vttProject/api/grpc/pb/placedImage"
+	"github.com/GarotoCowboy/vttProject/api/grpc/service/drag"
+	"github.com/GarotoCowboy/vttProject/api/grpc/service/sync/broker"
 	"github.com/GarotoCowboy/vttProject/api/models"
 	"github.com/GarotoCowboy/vttProject/api/models/consts/pubSubSyncConst"
 	"github.com/GarotoCowboy/vttProject/api/utils"
@@ -27,6 +30,7 @@ func (s *PlacedImageService) CreatePlacedImage(ctx context.Context, req *placedI
 
 	var sceneModel models.Scene
 	var imageModel models.Image
+	var placedImageModel models.PlacedImage
 
 	s.Logger.InfoF("Searching if scene exists...") // Log to search for the scene
 
@@ -63,7 +67,7 @@ func (s *PlacedImageService) CreatePlacedImage(ctx context.Context, req *placedI
 
 		// Create and save placedImage model
 		s.Logger.InfoF("Creating placedImage model and saving...") // Log before saving placed image
-		placedImageModel := models.PlacedImage{
+		placedImageModel = models.PlacedImage{
 			SceneID: sceneModel.ID,
 			ImageID: imageModel.ID,
 			PosY:    req.PosY,
@@ -85,10 +89,11 @@ func (s *PlacedImageService) CreatePlacedImage(ctx context.Context, req *placedI
 
 	// Prepare the response
 	response := &placedImage.PlacedImage{
-		SceneId: uint64(sceneModel.ID),
-		ImageId: uint64(imageModel.ID),
-		PosY:    req.PosY,
-		PosX:    req.PosX,
+		SceneId:       uint64(sceneModel.ID),
+		ImageId:       uint64(imageModel.ID),
+		PlacedImageId: uint64(placedImageModel.ID),
+		PosY:          req.PosY,
+		PosX:          req.PosX,
 	}
 
 	s.Logger.InfoF("GRPC Requisition to Create placedImage finished...") // Log when the request finishes
@@ -197,6 +202,7 @@ func (s *PlacedImageService) DeletePlacedImage(ctx context.Context, req *placedI
 
 	var placedImageModel models.PlacedImage
 	var sceneModel models.Scene
+	var deletedVisibility broker.Visibility
 
 	// Begin the database transaction
 	err := s.DB.Transaction(func(tx *gorm.DB) error {
@@ -241,6 +247,13 @@ func (s *PlacedImageService) DeletePlacedImage(ctx context.Context, req *placedI
 			return status.Errorf(codes.Internal, "internal error")
 		}
 
+		// the audience of the deletion is the one of the image, resolved while it still exists
+		var err error
+		if deletedVisibility, err = broker.PlacedImageVisibility(tx, placedImageModel); err != nil {
+			s.Logger.ErrorF("Error resolving the audience of placed image %d: %v", placedImageModel.ID, err)
+			return status.Errorf(codes.Internal, "internal error")
+		}
+
 		// Attempt to delete the placed image
 		if err := tx.Delete(&placedImageModel).Error; err != nil {
 			s.Logger.ErrorF("Error deleting placed image with PlacedImageId: %d, error: %v", req.PlacedImageId, err) // Log if deletion fails
@@ -257,7 +270,7 @@ func (s *PlacedImageService) DeletePlacedImage(ctx context.Context, req *placedI
 
 	// Publish the event after the image is deleted
 	event := events.NewPlacedImageDeletedEvent(sceneModel.ID, placedImageModel.ID)
-	s.Broker.Publish(pubSubSyncConst.SceneSync, req.SceneId, event)
+	s.Broker.PublishTo(pubSubSyncConst.SceneSync, req.SceneId, event, deletedVisibility)
 
 	s.Logger.InfoF("GRPC Requisition to Delete Scene finished...") // Log when the request finishes
 	return &emptypb.Empty{}, nil
@@ -309,6 +322,10 @@ func (s *PlacedImageService) MoveImage(ctx context.Context, req *placedImage.Mov
 	s.Logger.InfoF("GRPC Requisition to moveImage started...") // Log when the request starts
 
 	// Attempt to pick the user ID from JWT
+	userID, err := utils.PickUserIdJWT(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	// Validate if the request is valid
 	if err := MoveTokenValidate(req); err != nil {
@@ -316,51 +333,89 @@ func (s *PlacedImageService) MoveImage(ctx context.Context, req *placedImage.Mov
 		return &placedImage.MoveImageResponse{}, err
 	}
 
+	// Every move is written and published, so each user has a limited number of them per second
+	if !s.MoveLimiter.Allow(userID) {
+		s.Logger.WarningF("user %d exceeded the move rate limit", userID) // Log if the user is moving too fast
+		return nil, status.Errorf(codes.ResourceExhausted, "too many moves, use StreamMoveImage to drag images")
+	}
+
+	if err := s.saveMove(ctx, req); err != nil {
+		return nil, err
+	}
+
+	// Prepare the response
+	responseProto := &placedImage.MoveImageResponse{
+		Success: true,
+	}
+
+	return responseProto, nil
+}
+
+// checkMovePermission verifies that the image is placed on the requested scene and that the user
+// is a master of the table or has permission to edit the image.
+func (s *PlacedImageService) checkMovePermission(ctx context.Context, tx *gorm.DB, req *placedImage.MoveImageRequest) (*models.PlacedImage, error) {
 	var sceneModel models.Scene
 	var placedImageModel models.PlacedImage
 
-	// Start the database transaction
-	err := s.DB.Transaction(func(tx *gorm.DB) error {
-		s.Logger.InfoF("Searching if scene exists...") // Log to search for the scene
+	s.Logger.InfoF("Searching if scene exists...") // Log to search for the scene
 
-		// Check if the scene exists
-		if err := tx.First(&sceneModel, req.SceneId).Error; err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				s.Logger.ErrorF("Scene not found with SceneId: %d", req.SceneId) // Log if scene is not found
-				return status.Errorf(codes.NotFound, "scene not found")
-			}
-			s.Logger.ErrorF("Error fetching scene with SceneId: %d, error: %v", req.SceneId, err) // Log other errors during scene fetch
-			return status.Errorf(codes.Internal, "failed to check scene")
+	// Check if the scene exists
+	if err := tx.First(&sceneModel, req.SceneId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.Logger.ErrorF("Scene not found with SceneId: %d", req.SceneId) // Log if scene is not found
+			return nil, status.Errorf(codes.NotFound, "scene not found")
 		}
+		s.Logger.ErrorF("Error fetching scene with SceneId: %d, error: %v", req.SceneId, err) // Log other errors during scene fetch
+		return nil, status.Errorf(codes.Internal, "failed to check scene")
+	}
 
-		s.Logger.InfoF("Searching if placed image exists...") // Log to search for the placed image
+	s.Logger.InfoF("Searching if placed image exists...") // Log to search for the placed image
 
-		// Check if the placed image exists
-		if err := tx.First(&placedImageModel, req.PlacedImageId).Error; err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				s.Logger.ErrorF("Placed image not found with PlacedImageId: %d", req.PlacedImageId) // Log if placed image is not found
-				return status.Errorf(codes.NotFound, "image not found")
-			}
-			s.Logger.ErrorF("Error fetching placed image with PlacedImageId: %d, error: %v", req.PlacedImageId, err) // Log other errors during placed image fetch
-			return status.Errorf(codes.Internal, "failed to check token")
+	// Check if the placed image exists
+	if err := tx.First(&placedImageModel, req.PlacedImageId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.Logger.ErrorF("Placed image not found with PlacedImageId: %d", req.PlacedImageId) // Log if placed image is not found
+			return nil, status.Errorf(codes.NotFound, "image not found")
 		}
+		s.Logger.ErrorF("Error fetching placed image with PlacedImageId: %d, error: %v", req.PlacedImageId, err) // Log other errors during placed image fetch
+		return nil, status.Errorf(codes.Internal, "failed to check token")
+	}
 
-		// Verify if the user is a master or has permission to edit the object
-		s.Logger.InfoF("Verifying if user is a master for SceneId: %d", sceneModel.TableID)
-		errMaster := utils.CheckUserIsMaster(ctx, tx, sceneModel.TableID)
-		if errMaster == nil {
-			st, ok := status.FromError(errMaster)
-			if !ok || st.Code() != codes.PermissionDenied {
-				s.Logger.ErrorF("User does not have master permissions for SceneId: %d", sceneModel.TableID) // Log if user doesn't have master permissions
-				return errMaster
-			}
-		} else {
-			s.Logger.InfoF("Verifying if user can edit PlacedImageId: %d", req.PlacedImageId)
-			errEdit := utils.CheckUserCanEditTokenObject(ctx, tx, uint(req.PlacedImageId))
-			if errEdit != nil {
-				s.Logger.ErrorF("User does not have permission to edit PlacedImageId: %d", req.PlacedImageId) // Log if user doesn't have permission to edit
-				return errEdit
-			}
+	// The event goes to the scene in the request, so the image must be on it
+	if placedImageModel.SceneID != sceneModel.ID {
+		s.Logger.ErrorF("PlacedImageId %d is not on SceneId %d", req.PlacedImageId, req.SceneId) // Log if the image is on another scene
+		return nil, status.Errorf(codes.NotFound, "image not found")
+	}
+
+	// Verify if the user is a master or has permission to edit the object
+	s.Logger.InfoF("Verifying if user is a master for SceneId: %d", sceneModel.TableID)
+	errMaster := utils.CheckUserIsMaster(ctx, tx, sceneModel.TableID)
+	if errMaster != nil {
+		st, ok := status.FromError(errMaster)
+		if !ok || st.Code() != codes.PermissionDenied {
+			s.Logger.ErrorF("Error checking master permissions for SceneId: %d: %v", sceneModel.TableID, errMaster) // Log if the master check failed
+			return nil, errMaster
+		}
+
+		s.Logger.InfoF("Verifying if user can edit PlacedImageId: %d", req.PlacedImageId)
+		errEdit := utils.CheckUserCanEditImageObject(ctx, tx, uint(req.PlacedImageId))
+		if errEdit != nil {
+			s.Logger.ErrorF("User does not have permission to edit PlacedImageId: %d", req.PlacedImageId) // Log if user doesn't have permission to edit
+			return nil, errEdit
+		}
+	}
+
+	return &placedImageModel, nil
+}
+
+// saveMove writes the new position of the image and publishes the PlacedImageMoved event.
+func (s *PlacedImageService) saveMove(ctx context.Context, req *placedImage.MoveImageRequest) error {
+
+	// Start the database transaction
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		placedImageModel, err := s.checkMovePermission(ctx, tx, req)
+		if err != nil {
+			return err
 		}
 
 		// Update the placed image position
@@ -370,7 +425,7 @@ func (s *PlacedImageService) MoveImage(ctx context.Context, req *placedImage.Mov
 		}
 
 		// Perform the update operation
-		if err := tx.Model(&placedImageModel).Updates(updateData).Error; err != nil {
+		if err := tx.Model(placedImageModel).Updates(updateData).Error; err != nil {
 			s.Logger.ErrorF("Error updating placed image position: %v", err) // Log if update fails
 			return status.Errorf(codes.Internal, "failed to move image")
 		}
@@ -381,18 +436,91 @@ func (s *PlacedImageService) MoveImage(ctx context.Context, req *placedImage.Mov
 	})
 	if err != nil {
 		s.Logger.ErrorF("Error during transaction: %v", err) // Log if transaction fails
-		return nil, err
+		return err
 	}
 
 	// Publish the event after moving the image
 	s.Logger.InfoF("Publishing placedImage move event for SceneId: %d", req.SceneId)
 	event := events.NewPlacedImageMovedEvent(req.SceneId, req.PlacedImageId, int32(req.PosX), int32(req.PosY))
 	s.Broker.Publish(pubSubSyncConst.SceneSync, req.SceneId, event)
+	return nil
+}
 
-	// Prepare the response
-	responseProto := &placedImage.MoveImageResponse{
-		Success: true,
+func (s *PlacedImageService) StreamMoveImage(stream placedImage.PlacedImageService_StreamMoveImageServer) error {
+	s.Logger.InfoF("GRPC Requisition to streamMoveImage started...") // Log when the request starts
+
+	// The first position defines the image and the scene of the whole drag
+	first, err := stream.Recv()
+	if err == io.EOF {
+		s.Logger.ErrorF("move stream closed without sending a position")
+		return status.Errorf(codes.InvalidArgument, "no position received")
+	}
+	if err != nil {
+		s.Logger.ErrorF("failed to receive first position from stream: %v", err)
+		return err
 	}
 
-	return responseProto, nil
+	// read after Recv, the stream interceptor adds the user_id when the first message has the auth token
+	ctx := stream.Context()
+	userID, err := utils.PickUserIdJWT(ctx)
+	if err != nil {
+		return err
+	}
+	if first.SceneId == 0 || first.PlacedImageId == 0 {
+		return status.Errorf(codes.InvalidArgument, "scene_id and placed_image_id are required")
+	}
+
+	// Each user has a limited number of drags, opened and at the same time, before any query runs
+	release, err := s.DragLimiter.Start(userID)
+	if err != nil {
+		s.Logger.WarningF("user %d exceeded the drag limits: %v", userID, err)
+		return err
+	}
+	defer release()
+
+	// Permissions and the audience of the previews are resolved once, the final move checks them again
+	placedImageModel, err := s.checkMovePermission(ctx, s.DB.WithContext(ctx), first)
+	if err != nil {
+		return err
+	}
+	previewVisibility, err := broker.PlacedImageVisibility(s.DB.WithContext(ctx), *placedImageModel)
+	if err == nil {
+		// ephemeral events are not scoped by the broker, the previews of a hidden scene only go to masters
+		previewVisibility, err = broker.SceneVisibility(s.DB.WithContext(ctx), first.SceneId, previewVisibility)
+	}
+	if err != nil {
+		s.Logger.ErrorF("failed to resolve the audience of the previews of PlacedImage %d: %v", placedImageModel.ID, err)
+		return status.Errorf(codes.Internal, "failed to check image")
+	}
+
+	latest, err := drag.Run(ctx, s.DragLimiter, drag.Drag[*placedImage.MoveImageRequest]{
+		Stream: stream,
+		Logger: s.Logger,
+		UserID: userID,
+		First:  first,
+		ObjectID: func(req *placedImage.MoveImageRequest) uint64 {
+			return req.PlacedImageId
+		},
+		Preview: func(req *placedImage.MoveImageRequest) {
+			event := events.NewPlacedImageMovePreviewEvent(req.SceneId, req.PlacedImageId, int32(req.PosX), int32(req.PosY), uint64(userID))
+			s.Broker.PublishEphemeral(pubSubSyncConst.SceneSync, req.SceneId, event, previewVisibility)
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// The client dropped the image, the last position is the one saved
+	if !s.MoveLimiter.Allow(userID) {
+		s.Logger.WarningF("user %d exceeded the move rate limit", userID)
+		return status.Errorf(codes.ResourceExhausted, "too many moves")
+	}
+	if err := s.saveMove(ctx, latest); err != nil {
+		return err
+	}
+
+	s.Logger.InfoF("GRPC Requisition to streamMoveImage finished successfully.")
+	return stream.SendAndClose(&placedImage.MoveImageResponse{
+		Success: true,
+	})
 }
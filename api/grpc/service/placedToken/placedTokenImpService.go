@@ -3,9 +3,12 @@ package placedToken
 import (
 	"context"
 	"errors"
+	"io"
 
 	"github.com/GarotoCowboy/vttProject/api/grpc/events"
 	"github.com/GarotoCowboy/vttProject/api/grpc/pb/placedToken"
+	"github.com/GarotoCowboy/vttProject/api/grpc/service/drag"
+	"github.com/GarotoCowboy/vttProject/api/grpc/service/sync/broker"
 	"github.com/GarotoCowboy/vttProject/api/models"
 	"github.com/GarotoCowboy/vttProject/api/models/consts/pubSubSyncConst"
 	"github.com/GarotoCowboy/vttProject/api/utils"
@@ -91,10 +94,11 @@ func (s *PlacedTokenService) CreatePlacedToken(ctx context.Context, req *placedT
 
 	// Prepare the gRPC response protobuf message
 	response := &placedToken.PlacedToken{
-		SceneId: uint64(sceneModel.ID),
-		TokenId: uint64(tokenModel.ID),
-		PosY:    req.PosY,
-		PosX:    req.PosX,
+		SceneId:       uint64(sceneModel.ID),
+		TokenId:       uint64(tokenModel.ID),
+		PlacedTokenId: uint64(placedTokenModel.ID),
+		PosY:          req.PosY,
+		PosX:          req.PosX,
 	}
 
 	s.Logger.InfoF("GRPC Requisition to Create Scene finished...") // Log when the request finishes
@@ -222,6 +226,8 @@ func (s *PlacedTokenService) DeletePlacedToken(ctx context.Context, req *placedT
 	// Declare models
 	var placedTokenModel models.PlacedToken
 	var sceneModel models.Scene
+	// the audience of the deletion is the one of the token, resolved while it still exists
+	var deletedVisibility broker.Visibility
 
 	// Start database transaction
 	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
@@ -267,6 +273,11 @@ func (s *PlacedTokenService) DeletePlacedToken(ctx context.Context, req *placedT
 			}
 		}
 		// User is master or has specific rights, proceed with delete
+		var err error
+		if deletedVisibility, err = broker.PlacedTokenVisibility(tx, placedTokenModel); err != nil {
+			s.Logger.ErrorF("Error resolving the audience of placed token %d: %v", placedTokenModel.ID, err)
+			return status.Errorf(codes.Internal, "internal error")
+		}
 
 		s.Logger.InfoF("Deleting PlacedTokenId: %d", placedTokenModel.ID)
 		// Delete the placed token
@@ -287,7 +298,7 @@ func (s *PlacedTokenService) DeletePlacedToken(ctx context.Context, req *placedT
 
 	// Publish delete event
 	event := events.NewPlacedTokenDeletedEvent(sceneModel.ID, placedTokenModel.ID)
-	s.Broker.Publish(pubSubSyncConst.SceneSync, req.SceneId, event)
+	s.Broker.PublishTo(pubSubSyncConst.SceneSync, req.SceneId, event, deletedVisibility)
 
 	s.Logger.InfoF("GRPC Requisition to Delete Scene finished successfully.")
 	return &placedToken.DeletePlacedTokenResponse{
@@ -344,53 +355,96 @@ func (s *PlacedTokenService) ListAllTokensOnScene(ctx context.Context, req *plac
 func (s *PlacedTokenService) MoveToken(ctx context.Context, req *placedToken.MoveTokenRequest) (*placedToken.MoveTokenResponse, error) {
 	s.Logger.InfoF("GRPC Requisition to moveToken started...")
 
+	userID, err := utils.PickUserIdJWT(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every move is written and published, so each user has a limited number of them per second
+	if !s.MoveLimiter.Allow(userID) {
+		s.Logger.WarningF("user %d exceeded the move rate limit", userID)
+		return nil, status.Errorf(codes.ResourceExhausted, "too many moves, use StreamMoveToken to drag tokens")
+	}
+
+	if err := s.saveMove(ctx, req); err != nil {
+		return nil, err
+	}
+
+	// Prepare successful response
+	responseProto := &placedToken.MoveTokenResponse{
+		Success: true,
+	}
+
+	s.Logger.InfoF("GRPC Requisition to moveToken finished successfully.")
+	return responseProto, nil
+}
+
+// checkMovePermission verifies that the token is placed on the requested scene and that the user
+// is a master of the table or has permission to edit the token.
+func (s *PlacedTokenService) checkMovePermission(ctx context.Context, tx *gorm.DB, req *placedToken.MoveTokenRequest) (*models.PlacedToken, error) {
 	var sceneModel models.Scene
 	var placedTokenModel models.PlacedToken
 
-	// Start database transaction
-	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		s.Logger.InfoF("Searching if scene exists with SceneId: %d", req.SceneId)
-		// Check if scene exists
-		if err := tx.First(&sceneModel, req.SceneId).Error; err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				s.Logger.ErrorF("Scene not found: %d", req.SceneId)
-				return status.Errorf(codes.NotFound, "scene not found")
-			}
-			s.Logger.ErrorF("Failed to check scene: %v", err)
-			return status.Errorf(codes.Internal, "failed to check scene")
+	s.Logger.InfoF("Searching if scene exists with SceneId: %d", req.SceneId)
+	// Check if scene exists
+	if err := tx.First(&sceneModel, req.SceneId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.Logger.ErrorF("Scene not found: %d", req.SceneId)
+			return nil, status.Errorf(codes.NotFound, "scene not found")
 		}
+		s.Logger.ErrorF("Failed to check scene: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to check scene")
+	}
 
-		s.Logger.InfoF("searching if placed Token exists with PlacedTokenId: %d", req.PlacedTokenId)
-		// Check if placed token exists
-		if err := tx.First(&placedTokenModel, req.PlacedTokenId).Error; err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				s.Logger.ErrorF("PlacedToken not found: %d", req.PlacedTokenId)
-				return status.Errorf(codes.NotFound, "token not found")
-			}
-			s.Logger.ErrorF("Failed to check token: %v", err)
-			return status.Errorf(codes.Internal, "failed to check token")
+	s.Logger.InfoF("searching if placed Token exists with PlacedTokenId: %d", req.PlacedTokenId)
+	// Check if placed token exists
+	if err := tx.First(&placedTokenModel, req.PlacedTokenId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.Logger.ErrorF("PlacedToken not found: %d", req.PlacedTokenId)
+			return nil, status.Errorf(codes.NotFound, "token not found")
 		}
+		s.Logger.ErrorF("Failed to check token: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to check token")
+	}
 
-		// Verify if user is a master OR has permission to edit the object
-		s.Logger.InfoF("Verifying if user is a master for SceneId: %d (TableID: %d)", req.SceneId, sceneModel.TableID)
-		errMaster := utils.CheckUserIsMaster(ctx, tx, sceneModel.TableID)
+	// The event goes to the scene in the request, so the token must be on it
+	if placedTokenModel.SceneID != sceneModel.ID {
+		s.Logger.ErrorF("PlacedTokenId %d is not on SceneId %d", req.PlacedTokenId, req.SceneId)
+		return nil, status.Errorf(codes.NotFound, "token not found")
+	}
 
-		if errMaster != nil { // If master check failed
-			st, ok := status.FromError(errMaster)
-			if !ok || st.Code() != codes.PermissionDenied {
-				s.Logger.ErrorF("Error checking master permissions for SceneId %d: %v", req.SceneId, errMaster)
-				return errMaster // Return internal error
-			}
+	// Verify if user is a master OR has permission to edit the object
+	s.Logger.InfoF("Verifying if user is a master for SceneId: %d (TableID: %d)", req.SceneId, sceneModel.TableID)
+	errMaster := utils.CheckUserIsMaster(ctx, tx, sceneModel.TableID)
 
-			// User is not master, check specific edit rights
-			s.Logger.InfoF("User is not master, verifying if user can edit PlacedTokenId: %d", req.PlacedTokenId)
-			errEdit := utils.CheckUserCanEditTokenObject(ctx, tx, uint(req.PlacedTokenId))
-			if errEdit != nil {
-				s.Logger.ErrorF("User does not have permission to edit/move PlacedTokenId: %d", req.PlacedTokenId)
-				return errEdit // Return permission error
-			}
+	if errMaster != nil { // If master check failed
+		st, ok := status.FromError(errMaster)
+		if !ok || st.Code() != codes.PermissionDenied {
+			s.Logger.ErrorF("Error checking master permissions for SceneId %d: %v", req.SceneId, errMaster)
+			return nil, errMaster // Return internal error
+		}
+
+		// User is not master, check specific edit rights
+		s.Logger.InfoF("User is not master, verifying if user can edit PlacedTokenId: %d", req.PlacedTokenId)
+		errEdit := utils.CheckUserCanEditTokenObject(ctx, tx, uint(req.PlacedTokenId))
+		if errEdit != nil {
+			s.Logger.ErrorF("User does not have permission to edit/move PlacedTokenId: %d", req.PlacedTokenId)
+			return nil, errEdit // Return permission error
+		}
+	}
+	// User is master or has specific rights
+	return &placedTokenModel, nil
+}
+
+// saveMove writes the new position of the token and publishes the PlacedTokenMoved event.
+func (s *PlacedTokenService) saveMove(ctx context.Context, req *placedToken.MoveTokenRequest) error {
+
+	// Start database transaction
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		placedTokenModel, err := s.checkMovePermission(ctx, tx, req)
+		if err != nil {
+			return err
 		}
-		// User is master or has specific rights, proceed with move
 
 		// Prepare update data with new positions
 		updateData := models.PlacedToken{
@@ -400,7 +454,7 @@ func (s *PlacedTokenService) MoveToken(ctx context.Context, req *placedToken.Mov
 
 		s.Logger.InfoF("updating token position for PlacedTokenId: %d...", req.PlacedTokenId)
 		// Update the token's position
-		if err := tx.Model(&placedTokenModel).Updates(updateData).Error; err != nil {
+		if err := tx.Model(placedTokenModel).Updates(updateData).Error; err != nil {
 			s.Logger.ErrorF("Failed to move token in database: %v", err)
 			return status.Errorf(codes.Internal, "failed to move token")
 		}
@@ -412,7 +466,7 @@ func (s *PlacedTokenService) MoveToken(ctx context.Context, req *placedToken.Mov
 	// Check transaction error
 	if err != nil {
 		s.Logger.ErrorF("Transaction failed: %v", err)
-		return nil, err
+		return err
 	}
 
 	s.Logger.InfoF("publishing placedToken moved event for scene %d", req.SceneId)
@@ -420,12 +474,84 @@ func (s *PlacedTokenService) MoveToken(ctx context.Context, req *placedToken.Mov
 	// Publish move event
 	event := events.NewPlacedTokenMovedEvent(req.SceneId, req.PlacedTokenId, int32(req.PosX), int32(req.PosY))
 	s.Broker.Publish(pubSubSyncConst.SceneSync, req.SceneId, event)
+	return nil
+}
 
-	// Prepare successful response
-	responseProto := &placedToken.MoveTokenResponse{
-		Success: true,
+func (s *PlacedTokenService) StreamMoveToken(stream placedToken.PlacedTokenService_StreamMoveTokenServer) error {
+	s.Logger.InfoF("GRPC Requisition to streamMoveToken started...")
+
+	// The first position defines the token and the scene of the whole drag
+	first, err := stream.Recv()
+	if err == io.EOF {
+		s.Logger.ErrorF("move stream closed without sending a position")
+		return status.Errorf(codes.InvalidArgument, "no position received")
+	}
+	if err != nil {
+		s.Logger.ErrorF("failed to receive first position from stream: %v", err)
+		return err
 	}
 
-	s.Logger.InfoF("GRPC Requisition to moveToken finished successfully.")
-	return responseProto, nil
+	// read after Recv, the stream interceptor adds the user_id when the first message has the auth token
+	ctx := stream.Context()
+	userID, err := utils.PickUserIdJWT(ctx)
+	if err != nil {
+		return err
+	}
+	if first.SceneId == 0 || first.PlacedTokenId == 0 {
+		return status.Errorf(codes.InvalidArgument, "scene_id and placed_token_id are required")
+	}
+
+	// Each user has a limited number of drags, opened and at the same time, before any query runs
+	release, err := s.DragLimiter.Start(userID)
+	if err != nil {
+		s.Logger.WarningF("user %d exceeded the drag limits: %v", userID, err)
+		return err
+	}
+	defer release()
+
+	// Permissions and the audience of the previews are resolved once, the final move checks them again
+	placedTokenModel, err := s.checkMovePermission(ctx, s.DB.WithContext(ctx), first)
+	if err != nil {
+		return err
+	}
+	previewVisibility, err := broker.PlacedTokenVisibility(s.DB.WithContext(ctx), *placedTokenModel)
+	if err == nil {
+		// ephemeral events are not scoped by the broker, the previews of a hidden scene only go to masters
+		previewVisibility, err = broker.SceneVisibility(s.DB.WithContext(ctx), first.SceneId, previewVisibility)
+	}
+	if err != nil {
+		s.Logger.ErrorF("failed to resolve the audience of the previews of PlacedToken %d: %v", placedTokenModel.ID, err)
+		return status.Errorf(codes.Internal, "failed to check token")
+	}
+
+	latest, err := drag.Run(ctx, s.DragLimiter, drag.Drag[*placedToken.MoveTokenRequest]{
+		Stream: stream,
+		Logger: s.Logger,
+		UserID: userID,
+		First:  first,
+		ObjectID: func(req *placedToken.MoveTokenRequest) uint64 {
+			return req.PlacedTokenId
+		},
+		Preview: func(req *placedToken.MoveTokenRequest) {
+			event := events.NewPlacedTokenMovePreviewEvent(req.SceneId, req.PlacedTokenId, int32(req.PosX), int32(req.PosY), uint64(userID))
+			s.Broker.PublishEphemeral(pubSubSyncConst.SceneSync, req.SceneId, event, previewVisibility)
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// The client dropped the token, the last position is the one saved
+	if !s.MoveLimiter.Allow(userID) {
+		s.Logger.WarningF("user %d exceeded the move rate limit", userID)
+		return status.Errorf(codes.ResourceExhausted, "too many moves")
+	}
+	if err := s.saveMove(ctx, latest); err != nil {
+		return err
+	}
+
+	s.Logger.InfoF("GRPC Requisition to streamMoveToken finished successfully.")
+	return stream.SendAndClose(&placedToken.MoveTokenResponse{
+		Success: true,
+	})
 }
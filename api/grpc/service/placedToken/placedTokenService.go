@@ -2,7 +2,9 @@ package placedToken
 
 import (
 	"github.com/GarotoCowboy/vttProject/api/grpc/pb/placedToken"
+	"github.com/GarotoCowboy/vttProject/api/grpc/service/drag"
 	"github.com/GarotoCowboy/vttProject/api/grpc/service/sync/broker"
+	"github.com/GarotoCowboy/vttProject/api/utils"
 
 	"github.com/GarotoCowboy/vttProject/config"
 	"gorm.io/gorm"
@@ -12,13 +14,25 @@ type PlacedTokenService struct {
 	placedToken.UnimplementedPlacedTokenServiceServer
 	Logger *config.Logger
 	DB     *gorm.DB
-	Broker *broker.Broker
+	Broker broker.Broker
+	// MoveLimiter limits the saved moves per user, DragLimiter the drag streams and their previews
+	MoveLimiter *utils.RateLimiter
+	DragLimiter *drag.Limiter
 }
 
-func NewPlacedTokenService(db *gorm.DB, logger *config.Logger, broker *broker.Broker) *PlacedTokenService {
+// Per-user limit of saved moves per second (and burst)
+const (
+	moveRate  = 10
+	moveBurst = 20
+)
+
+func NewPlacedTokenService(db *gorm.DB, logger *config.Logger, broker broker.Broker) *PlacedTokenService {
 	return &PlacedTokenService{
 		DB:     db,
 		Logger: logger,
 		Broker: broker,
+
+		MoveLimiter: utils.NewRateLimiter(moveRate, moveBurst),
+		DragLimiter: drag.NewLimiter(),
 	}
 }
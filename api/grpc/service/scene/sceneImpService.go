@@ -8,6 +8,7 @@ import (
 
 	"github.com/GarotoCowboy/vttProject/api/grpc/events"
 	"github.com/GarotoCowboy/vttProject/api/grpc/pb/scene"
+	"github.com/GarotoCowboy/vttProject/api/grpc/service/sync/broker"
 	"github.com/GarotoCowboy/vttProject/api/models"
 	"github.com/GarotoCowboy/vttProject/api/models/consts"
 	"github.com/GarotoCowboy/vttProject/api/models/consts/pubSubSyncConst"
@@ -296,3 +297,59 @@ func (s SceneService) ListAllScenesForTable(ctx context.Context, req *scene.List
 	s.Logger.InfoF("GRPC Requisition to ListAllScenes finished successfully.")
 	return response, nil
 }
+
+func (s SceneService) SendPing(ctx context.Context, req *scene.SendPingRequest) (*scene.SendPingResponse, error) {
+	s.Logger.InfoF("GRPC Requisition to SendPing on scene %d", req.SceneId)
+
+	// 1. Validation
+	if err := ValidatePing(req); err != nil {
+		s.Logger.ErrorF("Invalid ping request: %v", err)
+		return nil, err
+	}
+
+	userID, err := utils.PickUserIdJWT(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pings are cheap but go to everyone on the scene, so each user has a limited number of them
+	if !s.PingLimiter.Allow(userID) {
+		s.Logger.WarningF("user %d exceeded the ping rate limit", userID)
+		return nil, status.Errorf(codes.ResourceExhausted, "too many pings")
+	}
+
+	// 2. MEMBER-LEVEL AUTHORIZATION
+	// The interceptor verified the user is a member of the table, the scene must belong to it
+	var sceneModel models.Scene
+	if err := s.DB.WithContext(ctx).Select("id", "is_visible").Where("id = ? AND table_id = ?", req.SceneId, req.TableId).
+		First(&sceneModel).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.Logger.WarningF("No scene found with id %d and table_id %d", req.SceneId, req.TableId)
+			return nil, status.Errorf(codes.NotFound, "scene not found or does not belong to the specified table")
+		}
+		s.Logger.ErrorF("Error checking scene %d: %v", req.SceneId, err)
+		return nil, status.Errorf(codes.Internal, "failed to check scene")
+	}
+
+	// 3. MASTER-LEVEL AUTHORIZATION, only masters move the view of the players
+	if req.FocusView {
+		if err := utils.CheckUserIsMaster(ctx, s.DB, uint(req.TableId)); err != nil {
+			s.Logger.ErrorF("User %d cannot focus the view of table %d: %v", userID, req.TableId, err)
+			return nil, err
+		}
+	}
+
+	// The ping is ephemeral, it is not stored and clients resuming the stream do not receive it
+	event := events.NewScenePingedEvent(req.TableId, req.SceneId, uint64(userID), req.Kind, req.Points, req.FocusView)
+	visibility := broker.PublicVisibility()
+	if !sceneModel.IsVisible {
+		visibility = broker.MasterOnlyVisibility()
+	}
+	s.Broker.PublishEphemeral(pubSubSyncConst.SceneSync, req.SceneId, event, visibility)
+
+	s.Logger.InfoF("GRPC Requisition to SendPing finished...")
+
+	return &scene.SendPingResponse{
+		Success: true,
+	}, nil
+}
@@ -3,6 +3,7 @@ package scene
 import (
 	"github.com/GarotoCowboy/vttProject/api/grpc/pb/scene"
 	"github.com/GarotoCowboy/vttProject/api/grpc/service/sync/broker"
+	"github.com/GarotoCowboy/vttProject/api/utils"
 	"github.com/GarotoCowboy/vttProject/config"
 	"gorm.io/gorm"
 )
@@ -11,13 +12,23 @@ type SceneService struct {
 	scene.UnimplementedSceneServiceServer
 	Logger *config.Logger
 	DB     *gorm.DB
-	Broker *broker.Broker
+	Broker broker.Broker
+	// PingLimiter limits the pings per user
+	PingLimiter *utils.RateLimiter
 }
 
-func NewSceneService(logger *config.Logger, db *gorm.DB, broker *broker.Broker) *SceneService {
+// Per-user limit of pings per second (and burst)
+const (
+	pingRate  = 5
+	pingBurst = 10
+)
+
+func NewSceneService(logger *config.Logger, db *gorm.DB, broker broker.Broker) *SceneService {
 	return &SceneService{
 		Logger: logger,
 		DB:     db,
 		Broker: broker,
+
+		PingLimiter: utils.NewRateLimiter(pingRate, pingBurst),
 	}
 }
@@ -105,3 +105,32 @@ func ValidateAndBuildUpdateMap(req *scene.EditSceneRequest) (map[string]interfac
 	}
 	return updatesMap, nil
 }
+
+// maxPointerPoints limits the size of a pointer trail sent in a single ping
+const maxPointerPoints = 64
+
+func ValidatePing(req *scene.SendPingRequest) error {
+
+	if req.TableId == 0 {
+		return status.Errorf(codes.InvalidArgument, "%v", ErrParamIsRequired("table_id", "uint64"))
+	}
+
+	if req.SceneId == 0 {
+		return status.Errorf(codes.InvalidArgument, "%v", ErrParamIsRequired("scene_id", "uint64"))
+	}
+
+	switch req.Kind {
+	case scene.PingKind_PING:
+		if len(req.Points) != 1 {
+			return status.Errorf(codes.InvalidArgument, "a ping must have exactly one point")
+		}
+	case scene.PingKind_POINTER:
+		if len(req.Points) == 0 || len(req.Points) > maxPointerPoints {
+			return status.Errorf(codes.InvalidArgument, "a pointer trail must have between 1 and %d points", maxPointerPoints)
+		}
+	default:
+		return status.Errorf(codes.InvalidArgument, "invalid ping kind")
+	}
+
+	return nil
+}
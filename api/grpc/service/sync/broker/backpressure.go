@@ -0,0 +1,98 @@
+package broker
+
+import (
+	"os"
+	"time"
+
+	syncBroker "github.com/GarotoCowboy/vttProject/api/grpc/pb/sync"
+)
+
+// BackpressureMode is what the broker does when a subscriber channel is full
+type BackpressureMode uint8
+
+const (
+	// DropOldest discards the oldest queued event to make room for the new one
+	DropOldest BackpressureMode = iota
+	// BlockWithTimeout waits up to the timeout for the subscriber, then drops the new event
+	BlockWithTimeout
+	// Disconnect closes the subscriber, the client reconnects and resumes from its last sequence
+	Disconnect
+)
+
+const defaultBlockTimeout = 100 * time.Millisecond
+
+type BackpressurePolicy struct {
+	Mode BackpressureMode
+	// Timeout is only used by BlockWithTimeout. Publishing holds the topic lock, so
+	// a slow subscriber delays the other subscribers of the topic up to this timeout
+	Timeout time.Duration
+}
+
+// BackpressurePolicyFromEnv reads SYNC_BACKPRESSURE_POLICY (drop_oldest, block or disconnect)
+// and SYNC_BACKPRESSURE_TIMEOUT (e.g. "200ms"), unknown values fall back to drop_oldest
+func BackpressurePolicyFromEnv() BackpressurePolicy {
+	policy := BackpressurePolicy{
+		Mode:    DropOldest,
+		Timeout: defaultBlockTimeout,
+	}
+
+	switch os.Getenv("SYNC_BACKPRESSURE_POLICY") {
+	case "block":
+		policy.Mode = BlockWithTimeout
+	case "disconnect":
+		policy.Mode = Disconnect
+	}
+
+	if timeout, err := time.ParseDuration(os.Getenv("SYNC_BACKPRESSURE_TIMEOUT")); err == nil && timeout > 0 {
+		policy.Timeout = timeout
+	}
+
+	return policy
+}
+
+// deliver sends the event to the subscriber applying the policy, it is called with the topic lock held
+func (b *MemoryBroker) deliver(sub *Subscriber, topic string, msg *syncBroker.SyncResponse) {
+	if sub.disconnected() {
+		return
+	}
+
+	select {
+	case sub.Ch <- msg:
+		return
+	default:
+	}
+
+	switch b.policy.Mode {
+	case BlockWithTimeout:
+		timer := time.NewTimer(b.policy.Timeout)
+		defer timer.Stop()
+		select {
+		case sub.Ch <- msg:
+		case <-sub.done:
+		case <-timer.C:
+			sub.drop(topic)
+			eventsDropped.WithLabelValues(topicLabel(topic), "timeout").Inc()
+		}
+
+	case Disconnect:
+		sub.drop(topic)
+		sub.disconnect()
+		eventsDropped.WithLabelValues(topicLabel(topic), "disconnect").Inc()
+		subscribersDisconnected.WithLabelValues(topicLabel(topic)).Inc()
+
+	default:
+		// the oldest event can belong to the other topic of the subscriber
+		select {
+		case oldest := <-sub.Ch:
+			sub.drop(oldest.GetTopic())
+			eventsDropped.WithLabelValues(topicLabel(oldest.GetTopic()), "drop_oldest").Inc()
+		default:
+		}
+		select {
+		case sub.Ch <- msg:
+		default:
+			sub.drop(topic)
+			eventsDropped.WithLabelValues(topicLabel(topic), "drop_oldest").Inc()
+		}
+	}
+}
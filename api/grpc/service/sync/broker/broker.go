@@ -1,18 +1,50 @@
 package broker
 
 import (
-	"sync"
+	"fmt"
+	"os"
 
 	syncBroker "github.com/GarotoCowboy/vttProject/api/grpc/pb/sync"
+	"github.com/GarotoCowboy/vttProject/api/models/consts/pubSubSyncConst"
+	"github.com/GarotoCowboy/vttProject/config"
+	"gorm.io/gorm"
 )
 
-type Broker struct {
-	mu          sync.RWMutex
-	subscribers map[string]map[chan *syncBroker.SyncResponse]struct{}
+// Broker delivers sync events to the streams subscribed to a table or scene topic
+type Broker interface {
+	SubscribeToTopic(topicType pubSubSyncConst.PubSubSyncType, id uint64, sub *Subscriber)
+	// SubscribeToTopicFrom subscribes and returns the events published after lastSeen, 0 starts without replay
+	SubscribeToTopicFrom(topicType pubSubSyncConst.PubSubSyncType, id uint64, sub *Subscriber, lastSeen uint64) Replay
+	UnsubscribeToTopic(topicType pubSubSyncConst.PubSubSyncType, id uint64, sub *Subscriber)
+	Publish(topicType pubSubSyncConst.PubSubSyncType, id uint64, msg *syncBroker.SyncResponse)
+	// PublishTo publishes with the audience resolved by the caller, e.g. for a deletion, whose
+	// object no longer exists when the filter would load it
+	PublishTo(topicType pubSubSyncConst.PubSubSyncType, id uint64, msg *syncBroker.SyncResponse, visibility Visibility)
+	// PublishEphemeral delivers to the current subscribers only: no sequence, no replay, and
+	// dropped without a resync when a subscriber is full. Used for high-frequency previews, so the
+	// audience is resolved once by the caller (e.g. when a drag starts) instead of once per event,
+	// it is not scoped by the filter, so callers on a scene topic must use SceneVisibility themselves
+	PublishEphemeral(topicType pubSubSyncConst.PubSubSyncType, id uint64, msg *syncBroker.SyncResponse, visibility Visibility)
+	// Sequence returns the last sequence published to the topic
+	Sequence(topicType pubSubSyncConst.PubSubSyncType, id uint64) uint64
+	// Close stops the background work of the broker, e.g. the eviction of idle topics
+	Close()
 }
 
-func NewBroker() *Broker {
-	return &Broker{
-		subscribers: make(map[string]map[chan *syncBroker.SyncResponse]struct{}),
+// NewBrokerFromEnv creates the backend selected by SYNC_BROKER: "memory" (default) for a
+// single instance, or "postgres" to share events between instances with LISTEN/NOTIFY
+func NewBrokerFromEnv(db *gorm.DB, logger *config.Logger) (Broker, error) {
+	filter := NewPermissionFilter(db, logger)
+	policy := BackpressurePolicyFromEnv()
+
+	switch backend := os.Getenv("SYNC_BROKER"); backend {
+	case "", "memory":
+		logger.InfoF("Using in-memory sync broker")
+		return NewMemoryBroker(filter, policy), nil
+	case "postgres":
+		logger.InfoF("Using postgres sync broker")
+		return NewPostgresBroker(db, config.GetPostgresDSN(), logger, filter, policy)
+	default:
+		return nil, fmt.Errorf("unknown SYNC_BROKER %q", backend)
 	}
 }
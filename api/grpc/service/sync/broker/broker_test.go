@@ -0,0 +1,402 @@
+package broker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/placedToken"
+	syncBroker "github.com/GarotoCowboy/vttProject/api/grpc/pb/sync"
+	"github.com/GarotoCowboy/vttProject/api/models/consts"
+	"github.com/GarotoCowboy/vttProject/api/models/consts/pubSubSyncConst"
+	"google.golang.org/protobuf/proto"
+)
+
+const testTableID = 1
+
+func publishN(b *MemoryBroker, n int) {
+	for i := 0; i < n; i++ {
+		b.Publish(pubSubSyncConst.TableSync, testTableID, &syncBroker.SyncResponse{TableId: testTableID})
+	}
+}
+
+// fakeSubscriber reads the channel like a Sync stream, slow ones never read
+type fakeSubscriber struct {
+	sub      *Subscriber
+	received []uint64
+}
+
+func (f *fakeSubscriber) read(t *testing.T, want int, wg *sync.WaitGroup) {
+	defer wg.Done()
+	timeout := time.After(5 * time.Second)
+	for len(f.received) < want {
+		select {
+		case msg := <-f.sub.Ch:
+			f.received = append(f.received, msg.GetSequence())
+		case <-f.sub.Done():
+			return
+		case <-timeout:
+			t.Errorf("subscriber received %d of %d events", len(f.received), want)
+			return
+		}
+	}
+}
+
+func TestPublishManyConcurrentSubscribersReceiveEverythingInOrder(t *testing.T) {
+	b := NewMemoryBroker(nil, BackpressurePolicy{Mode: BlockWithTimeout, Timeout: time.Second})
+	defer b.Close()
+
+	const subscribers = 200
+	const events = 1000
+
+	fakes := make([]*fakeSubscriber, subscribers)
+	var wg sync.WaitGroup
+	for i := range fakes {
+		fakes[i] = &fakeSubscriber{sub: NewSubscriber(uint(i+1), uint(i+1), consts.Player, 16)}
+		b.SubscribeToTopic(pubSubSyncConst.TableSync, testTableID, fakes[i].sub)
+		wg.Add(1)
+		go fakes[i].read(t, events, &wg)
+	}
+
+	// several publishers on the same topic
+	var publishers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		publishers.Add(1)
+		go func() {
+			defer publishers.Done()
+			publishN(b, events/4)
+		}()
+	}
+	publishers.Wait()
+	wg.Wait()
+
+	for i, f := range fakes {
+		if f.sub.Dropped() != 0 {
+			t.Errorf("subscriber %d dropped %d events", i, f.sub.Dropped())
+		}
+		if len(f.received) != events {
+			t.Fatalf("subscriber %d received %d events, want %d", i, len(f.received), events)
+		}
+		for j, sequence := range f.received {
+			if sequence != uint64(j+1) {
+				t.Fatalf("subscriber %d received sequence %d at position %d", i, sequence, j)
+			}
+		}
+	}
+}
+
+func TestDropOldestKeepsNewestEvents(t *testing.T) {
+	b := NewMemoryBroker(nil, BackpressurePolicy{Mode: DropOldest})
+	defer b.Close()
+	sub := NewSubscriber(1, 1, consts.Player, 10)
+	b.SubscribeToTopic(pubSubSyncConst.TableSync, testTableID, sub)
+
+	publishN(b, 50)
+
+	if sub.Dropped() != 40 {
+		t.Errorf("dropped %d events, want 40", sub.Dropped())
+	}
+	for want := uint64(41); want <= 50; want++ {
+		if msg := <-sub.Ch; msg.GetSequence() != want {
+			t.Fatalf("received sequence %d, want %d", msg.GetSequence(), want)
+		}
+	}
+
+	select {
+	case <-sub.Lost():
+	default:
+		t.Fatal("lost signal was not sent")
+	}
+	if topics := sub.TakeLostTopics(); len(topics) != 1 || topics[0] != "table:1" {
+		t.Errorf("lost topics %v, want [table:1]", topics)
+	}
+	if topics := sub.TakeLostTopics(); len(topics) != 0 {
+		t.Errorf("lost topics were not cleared: %v", topics)
+	}
+}
+
+func TestBlockWithTimeoutDropsWhenSubscriberStalls(t *testing.T) {
+	b := NewMemoryBroker(nil, BackpressurePolicy{Mode: BlockWithTimeout, Timeout: 10 * time.Millisecond})
+	defer b.Close()
+	slow := NewSubscriber(1, 1, consts.Player, 1)
+	b.SubscribeToTopic(pubSubSyncConst.TableSync, testTableID, slow)
+
+	start := time.Now()
+	publishN(b, 3)
+
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("publish did not wait for the slow subscriber, took %v", elapsed)
+	}
+	if slow.Dropped() != 2 {
+		t.Errorf("dropped %d events, want 2", slow.Dropped())
+	}
+	if msg := <-slow.Ch; msg.GetSequence() != 1 {
+		t.Errorf("received sequence %d, want 1", msg.GetSequence())
+	}
+	select {
+	case <-slow.Lost():
+	default:
+		t.Fatal("lost signal was not sent")
+	}
+}
+
+func TestDisconnectOnlyKicksSlowSubscribers(t *testing.T) {
+	b := NewMemoryBroker(nil, BackpressurePolicy{Mode: Disconnect})
+	defer b.Close()
+
+	const events = 100
+
+	var wg sync.WaitGroup
+	fast := make([]*fakeSubscriber, 50)
+	for i := range fast {
+		fast[i] = &fakeSubscriber{sub: NewSubscriber(uint(i+1), uint(i+1), consts.Player, events)}
+		b.SubscribeToTopic(pubSubSyncConst.TableSync, testTableID, fast[i].sub)
+		wg.Add(1)
+		go fast[i].read(t, events, &wg)
+	}
+	slow := make([]*Subscriber, 50)
+	for i := range slow {
+		slow[i] = NewSubscriber(uint(100+i), uint(100+i), consts.Player, 5)
+		b.SubscribeToTopic(pubSubSyncConst.TableSync, testTableID, slow[i])
+	}
+
+	publishN(b, events)
+	wg.Wait()
+
+	for i, f := range fast {
+		if len(f.received) != events || f.sub.Dropped() != 0 {
+			t.Errorf("fast subscriber %d received %d events and dropped %d", i, len(f.received), f.sub.Dropped())
+		}
+	}
+	for i, sub := range slow {
+		select {
+		case <-sub.Done():
+		default:
+			t.Fatalf("slow subscriber %d was not disconnected", i)
+		}
+		// only the event that found the channel full counts, later ones are not sent to a closed subscriber
+		if sub.Dropped() != 1 {
+			t.Errorf("slow subscriber %d dropped %d events, want 1", i, sub.Dropped())
+		}
+	}
+}
+
+func TestResumeAfterDropReplaysMissedEvents(t *testing.T) {
+	b := NewMemoryBroker(nil, BackpressurePolicy{Mode: Disconnect})
+	defer b.Close()
+	sub := NewSubscriber(1, 1, consts.Player, 2)
+	b.SubscribeToTopic(pubSubSyncConst.TableSync, testTableID, sub)
+
+	publishN(b, 5)
+	<-sub.Done()
+	b.UnsubscribeToTopic(pubSubSyncConst.TableSync, testTableID, sub)
+
+	// the client saw the two queued events and reconnects from there
+	resumed := NewSubscriber(1, 1, consts.Player, 10)
+	replay := b.SubscribeToTopicFrom(pubSubSyncConst.TableSync, testTableID, resumed, 2)
+
+	if replay.Resync {
+		t.Fatal("resync required while the events are still buffered")
+	}
+	if len(replay.Missed) != 3 || replay.Missed[0].GetSequence() != 3 || replay.Sequence != 5 {
+		t.Errorf("unexpected replay: %d missed, sequence %d", len(replay.Missed), replay.Sequence)
+	}
+}
+
+func TestEvictIdleFreesTopicsAndKeepsTheirSequence(t *testing.T) {
+	b := NewMemoryBroker(nil, BackpressurePolicy{Mode: DropOldest})
+	defer b.Close()
+	sub := NewSubscriber(1, 1, consts.Player, 10)
+	b.SubscribeToTopic(pubSubSyncConst.TableSync, testTableID, sub)
+	publishN(b, 3)
+
+	// topics with subscribers are never evicted
+	b.evictIdle(time.Now().Add(2 * topicIdleTTL))
+	if len(b.topics) != 1 {
+		t.Fatalf("topic with a subscriber was evicted")
+	}
+
+	b.UnsubscribeToTopic(pubSubSyncConst.TableSync, testTableID, sub)
+	b.evictIdle(time.Now())
+	if len(b.topics) != 1 {
+		t.Fatalf("topic was evicted before its idle TTL")
+	}
+
+	b.evictIdle(time.Now().Add(topicIdleTTL))
+	if len(b.topics) != 0 {
+		t.Fatalf("idle topic was not evicted")
+	}
+	if got := b.Sequence(pubSubSyncConst.TableSync, testTableID); got != 3 {
+		t.Errorf("sequence of the evicted topic = %d, want 3", got)
+	}
+	if got := b.Sequence(pubSubSyncConst.SceneSync, 99); got != 0 || len(b.topics) != 0 {
+		t.Errorf("unknown topic has sequence %d and %d topics exist, want 0 and 0", got, len(b.topics))
+	}
+
+	// the numbering continues, and a client resuming from before the eviction must resync
+	publishN(b, 1)
+	resumed := NewSubscriber(1, 1, consts.Player, 10)
+	replay := b.SubscribeToTopicFrom(pubSubSyncConst.TableSync, testTableID, resumed, 2)
+	if replay.Sequence != 4 || !replay.Resync {
+		t.Errorf("unexpected replay after eviction: sequence %d, resync %v", replay.Sequence, replay.Resync)
+	}
+
+	// the sequences of evicted topics are only kept for evictedTTL
+	b.UnsubscribeToTopic(pubSubSyncConst.TableSync, testTableID, resumed)
+	b.evictIdle(time.Now().Add(2 * topicIdleTTL))
+	if len(b.evicted) != 1 {
+		t.Fatalf("%d evicted topics, want 1", len(b.evicted))
+	}
+	b.evictIdle(time.Now().Add(2*topicIdleTTL + evictedTTL))
+	if len(b.evicted) != 0 {
+		t.Errorf("the sequence of the evicted topic was kept after its TTL")
+	}
+}
+
+func TestEphemeralNotificationKeepsTheAudience(t *testing.T) {
+	visibility := PermissionVisibility(consts.PermissionOwnerAndMaster, []uint{3, 7})
+	audience, err := json.Marshal(newWireVisibility(visibility))
+	if err != nil {
+		t.Fatalf("encoding audience: %v", err)
+	}
+	data, err := proto.Marshal(&syncBroker.SyncResponse{TableId: testTableID, Topic: "scene:1"})
+	if err != nil {
+		t.Fatalf("encoding event: %v", err)
+	}
+
+	prefix, decoded, body, err := splitNotification(notification(ephemeralPrefix, audience, base64.StdEncoding.EncodeToString(data)))
+	if err != nil || prefix != ephemeralPrefix {
+		t.Fatalf("splitNotification returned prefix %q and error %v", prefix, err)
+	}
+	msg, err := (&PostgresBroker{}).decode(context.Background(), prefix, body)
+	if err != nil {
+		t.Fatalf("decode returned error: %v", err)
+	}
+	if msg.GetTopic() != "scene:1" || msg.GetTableId() != testTableID {
+		t.Errorf("unexpected event %v", msg)
+	}
+
+	owner := NewSubscriber(7, 70, consts.Player, 1)
+	other := NewSubscriber(8, 80, consts.Player, 1)
+	master := NewSubscriber(9, 90, consts.Master, 1)
+	if decoded.Public || !decoded.Allows(owner) || decoded.Allows(other) || !decoded.Allows(master) {
+		t.Errorf("decoded audience %+v does not match %+v", decoded, visibility)
+	}
+}
+
+func TestSequencedEventsWithoutSubscribersOnlyMoveTheSequence(t *testing.T) {
+	b := NewMemoryBroker(nil, BackpressurePolicy{Mode: DropOldest})
+	defer b.Close()
+	name := getTopic(pubSubSyncConst.TableSync, testTableID)
+	event := func(sequence uint64) *syncBroker.SyncResponse {
+		return &syncBroker.SyncResponse{TableId: testTableID, Topic: name, Sequence: sequence}
+	}
+
+	// topics the instance does not follow are not created
+	b.publishSequenced(event(1), PublicVisibility())
+	if len(b.topics) != 0 {
+		t.Fatalf("event of an unknown topic created it")
+	}
+
+	b.advanceSequence(name, 10)
+	b.publishSequenced(event(11), PublicVisibility())
+	if got := b.Sequence(pubSubSyncConst.TableSync, testTableID); got != 11 {
+		t.Errorf("sequence = %d, want 11", got)
+	}
+
+	// nothing was buffered, so resuming from before the first subscriber requires a resync
+	sub := NewSubscriber(1, 1, consts.Player, 10)
+	if replay := b.SubscribeToTopicFrom(pubSubSyncConst.TableSync, testTableID, sub, 9); !replay.Resync || replay.Sequence != 11 {
+		t.Errorf("unexpected replay: sequence %d, resync %v", replay.Sequence, replay.Resync)
+	}
+
+	b.publishSequenced(event(12), PublicVisibility())
+	select {
+	case msg := <-sub.Ch:
+		if msg.GetSequence() != 12 {
+			t.Errorf("received sequence %d, want 12", msg.GetSequence())
+		}
+	default:
+		t.Errorf("subscriber did not receive the event")
+	}
+}
+
+type masterOnlyFilter struct{ resolved int }
+
+func (f *masterOnlyFilter) Resolve(*syncBroker.SyncResponse) Visibility {
+	f.resolved++
+	return MasterOnlyVisibility()
+}
+
+func (f *masterOnlyFilter) Scope(_ pubSubSyncConst.PubSubSyncType, _ uint64, visibility Visibility) Visibility {
+	return visibility
+}
+
+type visibilityObserver struct{ seen []Visibility }
+
+func (o *visibilityObserver) Observe(_ pubSubSyncConst.PubSubSyncType, _ uint64, _ *syncBroker.SyncResponse, visibility Visibility) {
+	o.seen = append(o.seen, visibility)
+}
+
+func TestObserversReceiveTheResolvedAudience(t *testing.T) {
+	filter := &masterOnlyFilter{}
+	observer := &visibilityObserver{}
+	b := WithObservers(NewMemoryBroker(filter, BackpressurePolicy{Mode: DropOldest}), observer)
+	defer b.Close()
+
+	b.Publish(pubSubSyncConst.TableSync, testTableID, &syncBroker.SyncResponse{TableId: testTableID})
+
+	if filter.resolved != 1 {
+		t.Errorf("audience resolved %d times, want 1", filter.resolved)
+	}
+	if len(observer.seen) != 1 || observer.seen[0].Public || !observer.seen[0].Masters {
+		t.Errorf("observer received %+v, want the master only audience", observer.seen)
+	}
+}
+
+func TestDeletionsWithoutAudienceOnlyReachMasters(t *testing.T) {
+	deleted := &syncBroker.SyncResponse{
+		Action: &syncBroker.SyncResponse_PlacedTokenDeleted{PlacedTokenDeleted: &placedToken.PlacedTokenDeleted{PlacedTokenId: 1}},
+	}
+
+	visibility := (&PermissionFilter{}).Resolve(deleted)
+	if visibility.Public || !visibility.Masters {
+		t.Errorf("deletion resolved to %+v, want the master only audience", visibility)
+	}
+}
+
+// hiddenSceneFilter hides the scene testTableID, like the PermissionFilter does for a scene with IsVisible false
+type hiddenSceneFilter struct{}
+
+func (hiddenSceneFilter) Resolve(*syncBroker.SyncResponse) Visibility {
+	return PublicVisibility()
+}
+
+func (hiddenSceneFilter) Scope(topicType pubSubSyncConst.PubSubSyncType, id uint64, visibility Visibility) Visibility {
+	if topicType == pubSubSyncConst.SceneSync && id == testTableID {
+		return MasterOnlyVisibility()
+	}
+	return visibility
+}
+
+func TestEventsOfAHiddenSceneOnlyReachMasters(t *testing.T) {
+	b := NewMemoryBroker(hiddenSceneFilter{}, BackpressurePolicy{Mode: DropOldest})
+	defer b.Close()
+	player := NewSubscriber(1, 1, consts.Player, 10)
+	master := NewSubscriber(2, 2, consts.Master, 10)
+	b.SubscribeToTopic(pubSubSyncConst.SceneSync, testTableID, player)
+	b.SubscribeToTopic(pubSubSyncConst.SceneSync, testTableID, master)
+
+	b.Publish(pubSubSyncConst.SceneSync, testTableID, &syncBroker.SyncResponse{TableId: testTableID})
+	b.PublishTo(pubSubSyncConst.SceneSync, testTableID, &syncBroker.SyncResponse{TableId: testTableID}, PublicVisibility())
+
+	if len(player.Ch) != 0 {
+		t.Errorf("player received %d events of the hidden scene", len(player.Ch))
+	}
+	if len(master.Ch) != 2 {
+		t.Errorf("master received %d events, want 2", len(master.Ch))
+	}
+}
@@ -12,6 +12,8 @@ func getTopic(topicType pubSubSyncConst.PubSubSyncType, id uint64) string {
 		return fmt.Sprintf("table:%d", id)
 	case pubSubSyncConst.SceneSync:
 		return fmt.Sprintf("scene:%d", id)
+	case pubSubSyncConst.UserSync:
+		return fmt.Sprintf("user:%d", id)
 	default:
 		return "unknown:0"
 	}
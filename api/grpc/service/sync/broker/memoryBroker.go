@@ -0,0 +1,127 @@
+package broker
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// replayBufferSize is how many events each topic keeps to replay after a reconnect
+	replayBufferSize = 512
+	// topicIdleTTL is how long a topic without subscribers nor events keeps its replay buffer
+	topicIdleTTL       = 10 * time.Minute
+	topicSweepInterval = time.Minute
+	// evictedTTL is how long the sequence of an evicted topic is kept. A client resuming after it is
+	// told to resync only when its sequence is ahead of the topic, which numbers its events from 1 again
+	evictedTTL = 24 * time.Hour
+)
+
+// MemoryBroker keeps subscribers, sequences and replay buffers in process, it is the default backend
+type MemoryBroker struct {
+	mu     sync.RWMutex
+	topics map[string]*topicState
+	// evicted keeps the last sequence of the topics freed by evictIdle for evictedTTL, so a topic used again
+	// continues its numbering and a client resuming from an old sequence is never replayed the wrong events
+	evicted map[string]evictedTopic
+
+	// done stops the sweep, it is closed by Close
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// filter decides which subscribers receive each event, nil delivers everything
+	filter Filter
+	// policy decides what happens when a subscriber is too slow
+	policy BackpressurePolicy
+}
+
+type evictedTopic struct {
+	sequence  uint64
+	evictedAt time.Time
+}
+
+func NewMemoryBroker(filter Filter, policy BackpressurePolicy) *MemoryBroker {
+	b := &MemoryBroker{
+		topics:  make(map[string]*topicState),
+		evicted: make(map[string]evictedTopic),
+		filter:  filter,
+		policy:  policy,
+		done:    make(chan struct{}),
+	}
+	go b.sweep()
+	return b
+}
+
+// Close stops the eviction of idle topics, the broker still delivers events
+func (b *MemoryBroker) Close() {
+	b.closeOnce.Do(func() {
+		close(b.done)
+	})
+}
+
+// topic returns the state of a topic, creating it on the first use
+func (b *MemoryBroker) topic(name string) *topicState {
+	b.mu.RLock()
+	state, ok := b.topics[name]
+	b.mu.RUnlock()
+	if ok {
+		return state
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if state, ok = b.topics[name]; !ok {
+		state = newTopicState()
+		state.sequence = b.evicted[name].sequence
+		delete(b.evicted, name)
+		b.topics[name] = state
+	}
+	return state
+}
+
+// lockTopic returns the state of a topic with its lock held, never one that evictIdle just removed
+func (b *MemoryBroker) lockTopic(name string) *topicState {
+	for {
+		state := b.topic(name)
+		state.mu.Lock()
+		if !state.evicted {
+			return state
+		}
+		state.mu.Unlock()
+	}
+}
+
+func (b *MemoryBroker) sweep() {
+	ticker := time.NewTicker(topicSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case now := <-ticker.C:
+			b.evictIdle(now)
+		}
+	}
+}
+
+// evictIdle frees the topics that had no subscribers nor events for topicIdleTTL, e.g. closed tables and
+// the user topics of users that left. Only their sequence is kept, until evictedTTL
+func (b *MemoryBroker) evictIdle(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for name, state := range b.topics {
+		state.mu.Lock()
+		if len(state.subscribers) == 0 && now.Sub(state.lastActive) >= topicIdleTTL {
+			state.evicted = true
+			b.evicted[name] = evictedTopic{sequence: state.sequence, evictedAt: now}
+			delete(b.topics, name)
+		}
+		state.mu.Unlock()
+	}
+
+	for name, evicted := range b.evicted {
+		if now.Sub(evicted.evictedAt) >= evictedTTL {
+			delete(b.evicted, name)
+		}
+	}
+}
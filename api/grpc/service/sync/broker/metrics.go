@@ -0,0 +1,53 @@
+package broker
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Broker metrics are labeled by topic type ("table" or "scene"), never by topic, so the
+// number of series does not grow with the number of tables
+var (
+	eventsDelivered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vtt",
+		Subsystem: "broker",
+		Name:      "events_total",
+		Help:      "Events delivered to the subscribers of this instance, by topic type and kind (sequenced or ephemeral).",
+	}, []string{"topic_type", "kind"})
+
+	eventsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vtt",
+		Subsystem: "broker",
+		Name:      "dropped_events_total",
+		Help:      "Events a slow subscriber did not receive, by topic type and reason (drop_oldest, timeout, disconnect, ephemeral).",
+	}, []string{"topic_type", "reason"})
+
+	subscribersGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "vtt",
+		Subsystem: "broker",
+		Name:      "subscribers",
+		Help:      "Subscriptions open on this instance, by topic type.",
+	}, []string{"topic_type"})
+
+	activeTopicsGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "vtt",
+		Subsystem: "broker",
+		Name:      "active_topics",
+		Help:      "Topics with at least one subscriber on this instance (live tables and scenes), by topic type.",
+	}, []string{"topic_type"})
+
+	subscribersDisconnected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vtt",
+		Subsystem: "broker",
+		Name:      "disconnected_subscribers_total",
+		Help:      "Subscribers disconnected by the backpressure policy, by topic type.",
+	}, []string{"topic_type"})
+)
+
+// topicLabel returns the label of a topic name, e.g. "table" for "table:1"
+func topicLabel(name string) string {
+	prefix, _, _ := strings.Cut(name, ":")
+	return prefix
+}
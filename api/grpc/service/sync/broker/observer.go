@@ -0,0 +1,67 @@
+package broker
+
+import (
+	syncBroker "github.com/GarotoCowboy/vttProject/api/grpc/pb/sync"
+	"github.com/GarotoCowboy/vttProject/api/models/consts/pubSubSyncConst"
+)
+
+// Observer receives every event published by this instance after it got its sequence, with the
+// audience the broker resolved for it. Ephemeral events are not observed. Observe is called on the
+// publisher goroutine, so it must not block, and the message is shared with the subscribers, so it
+// must not change it
+type Observer interface {
+	Observe(topicType pubSubSyncConst.PubSubSyncType, id uint64, msg *syncBroker.SyncResponse, visibility Visibility)
+}
+
+// visiblePublisher is a Publish and a PublishTo that return the audience the event was delivered to,
+// the brokers of this package have them
+type visiblePublisher interface {
+	publishVisible(topicType pubSubSyncConst.PubSubSyncType, id uint64, msg *syncBroker.SyncResponse) Visibility
+	publishToVisible(topicType pubSubSyncConst.PubSubSyncType, id uint64, msg *syncBroker.SyncResponse, visibility Visibility) Visibility
+}
+
+type observedBroker struct {
+	Broker
+	observers []Observer
+}
+
+// WithObservers returns a broker that calls the observers after each Publish of b
+func WithObservers(b Broker, observers ...Observer) Broker {
+	return &observedBroker{
+		Broker:    b,
+		observers: observers,
+	}
+}
+
+func (b *observedBroker) Publish(topicType pubSubSyncConst.PubSubSyncType, id uint64, msg *syncBroker.SyncResponse) {
+	// a broker that does not tell the audience gives the observers an event nobody can see
+	var visibility Visibility
+	if publisher, ok := b.Broker.(visiblePublisher); ok {
+		visibility = publisher.publishVisible(topicType, id, msg)
+	} else {
+		b.Broker.Publish(topicType, id, msg)
+	}
+
+	b.observe(topicType, id, msg, visibility)
+}
+
+func (b *observedBroker) PublishTo(topicType pubSubSyncConst.PubSubSyncType, id uint64, msg *syncBroker.SyncResponse, visibility Visibility) {
+	// the broker may narrow the audience, e.g. on a hidden scene
+	if publisher, ok := b.Broker.(visiblePublisher); ok {
+		visibility = publisher.publishToVisible(topicType, id, msg, visibility)
+	} else {
+		b.Broker.PublishTo(topicType, id, msg, visibility)
+	}
+
+	b.observe(topicType, id, msg, visibility)
+}
+
+func (b *observedBroker) observe(topicType pubSubSyncConst.PubSubSyncType, id uint64, msg *syncBroker.SyncResponse, visibility Visibility) {
+	// no sequence means the event was not published
+	if msg.GetSequence() == 0 {
+		return
+	}
+	for _, observer := range b.observers {
+		observer.Observe(topicType, id, msg, visibility)
+	}
+}
@@ -0,0 +1,251 @@
+package broker
+
+import (
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/placedImage"
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/placedToken"
+	syncBroker "github.com/GarotoCowboy/vttProject/api/grpc/pb/sync"
+	"github.com/GarotoCowboy/vttProject/api/models"
+	"github.com/GarotoCowboy/vttProject/api/models/consts"
+	"github.com/GarotoCowboy/vttProject/api/models/consts/pubSubSyncConst"
+	"github.com/GarotoCowboy/vttProject/config"
+	"gorm.io/gorm"
+)
+
+// PermissionFilter resolves the audience of an event from the permissions saved in the database.
+// When the object cannot be loaded the event is only delivered to masters, hidden state never leaks on errors
+type PermissionFilter struct {
+	db     *gorm.DB
+	logger *config.Logger
+}
+
+func NewPermissionFilter(db *gorm.DB, logger *config.Logger) *PermissionFilter {
+	return &PermissionFilter{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (f *PermissionFilter) Resolve(msg *syncBroker.SyncResponse) Visibility {
+	switch action := msg.GetAction().(type) {
+
+	// Placed tokens
+	case *syncBroker.SyncResponse_PlacedTokenCreated:
+		pt := action.PlacedTokenCreated.GetPlacedToken()
+		if pt.GetLayer() == placedToken.LayerType_MASTER_LAYER {
+			return MasterOnlyVisibility()
+		}
+		return f.placedTokenVisibility(pt.GetPlacedTokenId())
+	case *syncBroker.SyncResponse_PlacedTokenUpdated:
+		pt := action.PlacedTokenUpdated.GetPlacedToken()
+		if pt.GetLayer() == placedToken.LayerType_MASTER_LAYER {
+			return MasterOnlyVisibility()
+		}
+		return f.placedTokenVisibility(pt.GetPlacedTokenId())
+	case *syncBroker.SyncResponse_PlacedTokenMoved:
+		return f.placedTokenVisibility(action.PlacedTokenMoved.GetPlacedTokenId())
+
+	// Deletions are published with PublishTo and the audience of the object, it can no longer be loaded here
+	case *syncBroker.SyncResponse_PlacedTokenDeleted, *syncBroker.SyncResponse_PlacedImageDeleted, *syncBroker.SyncResponse_ImageDeleted:
+		return MasterOnlyVisibility()
+
+	// Placed images
+	case *syncBroker.SyncResponse_PlacedImageCreated:
+		pi := action.PlacedImageCreated.GetPlacedImage()
+		if pi.GetLayer() == placedImage.LayerType_MASTER_LAYER {
+			return MasterOnlyVisibility()
+		}
+		return f.placedImageVisibility(pi.GetPlacedImageId())
+	case *syncBroker.SyncResponse_PlacedImageUpdated:
+		pi := action.PlacedImageUpdated.GetPlacedImage()
+		if pi.GetLayer() == placedImage.LayerType_MASTER_LAYER {
+			return MasterOnlyVisibility()
+		}
+		return f.placedImageVisibility(pi.GetPlacedImageId())
+	case *syncBroker.SyncResponse_PlacedImageMoved:
+		return f.placedImageVisibility(action.PlacedImageMoved.GetPlacedImageId())
+
+	// Library objects
+	case *syncBroker.SyncResponse_ImageUploaded:
+		return f.imageVisibility(action.ImageUploaded.GetImage().GetImageId())
+	case *syncBroker.SyncResponse_ImageUpdated:
+		return f.imageVisibility(action.ImageUpdated.GetImage().GetImageId())
+	case *syncBroker.SyncResponse_TokenCreated:
+		return f.tokenVisibility(action.TokenCreated.GetToken().GetTokenId())
+	case *syncBroker.SyncResponse_TokenUpdated:
+		return f.tokenVisibility(action.TokenUpdated.GetToken().GetTokenId())
+
+	// Scenes hidden from players
+	case *syncBroker.SyncResponse_SceneCreated:
+		return f.sceneVisibility(action.SceneCreated.GetScene().GetSceneId())
+	case *syncBroker.SyncResponse_SceneUpdated:
+		return f.sceneVisibility(action.SceneUpdated.GetScene().GetSceneId())
+
+	// Private chat messages only go to the sender and the recipients, and to masters when the table allows it
+	case *syncBroker.SyncResponse_MessageSended:
+		if m := action.MessageSended.GetMessage(); len(m.GetRecipientIds()) > 0 {
+			return f.privateMessageVisibility(m.GetTableId(), m.GetSenderId(), m.GetRecipientIds())
+		}
+	case *syncBroker.SyncResponse_MessageUpdated:
+		if m := action.MessageUpdated.GetMessage(); len(m.GetRecipientIds()) > 0 {
+			return f.privateMessageVisibility(m.GetTableId(), m.GetSenderId(), m.GetRecipientIds())
+		}
+	case *syncBroker.SyncResponse_MessageDeleted:
+		return f.deletedMessageVisibility(action.MessageDeleted.GetMessageUuid())
+	}
+
+	return PublicVisibility()
+}
+
+func (f *PermissionFilter) placedTokenVisibility(placedTokenID uint64) Visibility {
+	var placedTokenModel models.PlacedToken
+	if err := f.db.Select("id", "layer_type", "can_be_viewed_by").Where("id = ?", placedTokenID).First(&placedTokenModel).Error; err != nil {
+		f.logger.ErrorF("error loading placedToken %d to filter event: %v", placedTokenID, err)
+		return MasterOnlyVisibility()
+	}
+
+	visibility, err := PlacedTokenVisibility(f.db, placedTokenModel)
+	if err != nil {
+		f.logger.ErrorF("error loading owners of placedToken %d to filter event: %v", placedTokenID, err)
+		return MasterOnlyVisibility()
+	}
+	return visibility
+}
+
+func (f *PermissionFilter) placedImageVisibility(placedImageID uint64) Visibility {
+	var placedImageModel models.PlacedImage
+	if err := f.db.Select("id", "layer_type", "can_be_viewed_by").Where("id = ?", placedImageID).First(&placedImageModel).Error; err != nil {
+		f.logger.ErrorF("error loading placedImage %d to filter event: %v", placedImageID, err)
+		return MasterOnlyVisibility()
+	}
+
+	visibility, err := PlacedImageVisibility(f.db, placedImageModel)
+	if err != nil {
+		f.logger.ErrorF("error loading owners of placedImage %d to filter event: %v", placedImageID, err)
+		return MasterOnlyVisibility()
+	}
+	return visibility
+}
+
+// PlacedTokenVisibility is the audience of the events of a loaded placed token, its owners are only
+// queried when they can see it
+func PlacedTokenVisibility(db *gorm.DB, placedTokenModel models.PlacedToken) (Visibility, error) {
+	if placedTokenModel.LayerType == consts.MasterLayer {
+		return MasterOnlyVisibility(), nil
+	}
+
+	var owners []uint
+	if placedTokenModel.CanBeViewedBy == consts.PermissionOwnerAndMaster {
+		if err := db.Model(&models.GameObjectOwner{}).Where("placed_token_id = ?", placedTokenModel.ID).Pluck("user_id", &owners).Error; err != nil {
+			return MasterOnlyVisibility(), err
+		}
+	}
+
+	return PermissionVisibility(placedTokenModel.CanBeViewedBy, owners), nil
+}
+
+// PlacedImageVisibility is the audience of the events of a loaded placed image, its owners are only
+// queried when they can see it
+func PlacedImageVisibility(db *gorm.DB, placedImageModel models.PlacedImage) (Visibility, error) {
+	if placedImageModel.LayerType == consts.MasterLayer {
+		return MasterOnlyVisibility(), nil
+	}
+
+	var owners []uint
+	if placedImageModel.CanBeViewedBy == consts.PermissionOwnerAndMaster {
+		if err := db.Model(&models.GameObjectOwner{}).Where("placed_image_id = ?", placedImageModel.ID).Pluck("user_id", &owners).Error; err != nil {
+			return MasterOnlyVisibility(), err
+		}
+	}
+
+	return PermissionVisibility(placedImageModel.CanBeViewedBy, owners), nil
+}
+
+func (f *PermissionFilter) imageVisibility(imageID uint64) Visibility {
+	var imageModel models.Image
+	if err := f.db.Select("id", "can_be_viewed_by").Where("id = ?", imageID).First(&imageModel).Error; err != nil {
+		f.logger.ErrorF("error loading image %d to filter event: %v", imageID, err)
+		return MasterOnlyVisibility()
+	}
+	return PermissionVisibility(imageModel.CanBeViewedBy, nil)
+}
+
+func (f *PermissionFilter) tokenVisibility(tokenID uint64) Visibility {
+	var tokenModel models.Token
+	if err := f.db.Select("id", "can_be_viewed_by").Where("id = ?", tokenID).First(&tokenModel).Error; err != nil {
+		f.logger.ErrorF("error loading token %d to filter event: %v", tokenID, err)
+		return MasterOnlyVisibility()
+	}
+	return PermissionVisibility(tokenModel.CanBeViewedBy, nil)
+}
+
+func (f *PermissionFilter) sceneVisibility(sceneID uint64) Visibility {
+	visibility, err := SceneVisibility(f.db, sceneID, PublicVisibility())
+	if err != nil {
+		f.logger.ErrorF("error loading scene %d to filter event: %v", sceneID, err)
+	}
+	return visibility
+}
+
+// Scope keeps every event of the topic of a hidden scene between masters, the objects of a hidden
+// scene have their own permissions but players must not see anything happening there
+func (f *PermissionFilter) Scope(topicType pubSubSyncConst.PubSubSyncType, id uint64, visibility Visibility) Visibility {
+	if topicType != pubSubSyncConst.SceneSync || visibility.masterOnly() {
+		return visibility
+	}
+
+	scoped, err := SceneVisibility(f.db, id, visibility)
+	if err != nil {
+		f.logger.ErrorF("error loading scene %d to filter event: %v", id, err)
+	}
+	return scoped
+}
+
+// SceneVisibility narrows the audience of an event of a scene to masters while the scene is hidden from players
+func SceneVisibility(db *gorm.DB, sceneID uint64, visibility Visibility) (Visibility, error) {
+	var sceneModel models.Scene
+	if err := db.Select("id", "is_visible").Where("id = ?", sceneID).First(&sceneModel).Error; err != nil {
+		return MasterOnlyVisibility(), err
+	}
+	if !sceneModel.IsVisible {
+		return MasterOnlyVisibility(), nil
+	}
+	return visibility, nil
+}
+
+// privateMessageVisibility sender and recipients are tableUser ids
+func (f *PermissionFilter) privateMessageVisibility(tableID, senderID uint64, recipientIDs []uint64) Visibility {
+	visibility := Visibility{
+		TableUserIDs: map[uint]struct{}{uint(senderID): {}},
+	}
+	for _, recipientID := range recipientIDs {
+		visibility.TableUserIDs[uint(recipientID)] = struct{}{}
+	}
+
+	var tableModel models.Table
+	if err := f.db.Select("id", "masters_read_whispers").Where("id = ?", tableID).First(&tableModel).Error; err != nil {
+		// the participants still receive it, only masters are left out
+		f.logger.ErrorF("error loading table %d to filter private message: %v", tableID, err)
+		return visibility
+	}
+	visibility.Masters = tableModel.MastersReadWhispers
+	return visibility
+}
+
+// deletedMessageVisibility keeps the deletion of a private message between its participants
+func (f *PermissionFilter) deletedMessageVisibility(messageUUID string) Visibility {
+	var messageModel models.ChatMessage
+	if err := f.db.Unscoped().Select("id", "table_id", "table_user_id").Where("id = ?", messageUUID).First(&messageModel).Error; err != nil {
+		f.logger.ErrorF("error loading chat message %s to filter event: %v", messageUUID, err)
+		return MasterOnlyVisibility()
+	}
+
+	var recipientIDs []uint64
+	if err := f.db.Model(&models.ChatMessageRecipient{}).Where("chat_message_id = ?", messageUUID).Pluck("table_user_id", &recipientIDs).Error; err != nil {
+		f.logger.ErrorF("error loading recipients of chat message %s to filter event: %v", messageUUID, err)
+		return MasterOnlyVisibility()
+	}
+	if len(recipientIDs) == 0 {
+		return PublicVisibility()
+	}
+	return f.privateMessageVisibility(uint64(messageModel.TableID), uint64(messageModel.TableUserID), recipientIDs)
+}
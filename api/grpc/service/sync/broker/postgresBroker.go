@@ -0,0 +1,402 @@
+package broker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	syncBroker "github.com/GarotoCowboy/vttProject/api/grpc/pb/sync"
+	"github.com/GarotoCowboy/vttProject/api/models"
+	"github.com/GarotoCowboy/vttProject/api/models/consts/pubSubSyncConst"
+	"github.com/GarotoCowboy/vttProject/config"
+	"github.com/jackc/pgx/v5"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+)
+
+const (
+	notifyChannel = "vtt_sync"
+	// NOTIFY payloads are limited to 8000 bytes, bigger events go to sync_event_payloads
+	maxNotifyPayload = 7900
+	// notifications are "<prefix><audience JSON>\n<body>", the body is the base64 event or, for refs,
+	// the ID of the stored payload. The audience is resolved by the publishing instance only
+	sequencedPrefix  = "seq:"
+	ephemeralPrefix  = "eph:"
+	payloadRefPrefix = "ref:"
+	// stored payloads only need to live until every instance received the notification
+	payloadRetention = 5 * time.Minute
+	maxListenBackoff = 30 * time.Second
+)
+
+// PostgresBroker shares events between server instances with LISTEN/NOTIFY.
+// Sequences are assigned by the database, so every instance numbers a topic the same way and
+// a client can resume on any of them. Each instance keeps a MemoryBroker for its own streams,
+// events published here are only delivered when the notification comes back
+type PostgresBroker struct {
+	local  *MemoryBroker
+	db     *gorm.DB
+	dsn    string
+	logger *config.Logger
+
+	// ctx stops the listener and the cleanup, it is canceled by Close
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewPostgresBroker(db *gorm.DB, dsn string, logger *config.Logger, filter Filter, policy BackpressurePolicy) (*PostgresBroker, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &PostgresBroker{
+		local:  NewMemoryBroker(filter, policy),
+		db:     db,
+		dsn:    dsn,
+		logger: logger,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	conn, err := b.listen(ctx)
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+
+	go b.run(conn)
+	go b.cleanPayloads()
+
+	return b, nil
+}
+
+// Close stops listening to the other instances and the background work of the broker
+func (b *PostgresBroker) Close() {
+	b.cancel()
+	b.local.Close()
+}
+
+func (b *PostgresBroker) SubscribeToTopic(topicType pubSubSyncConst.PubSubSyncType, id uint64, sub *Subscriber) {
+	b.local.SubscribeToTopic(topicType, id, sub)
+}
+
+// SubscribeToTopicFrom brings the local topic up to the sequence of the database on its first
+// subscriber, the local topic only follows the notifications while it has subscribers
+func (b *PostgresBroker) SubscribeToTopicFrom(topicType pubSubSyncConst.PubSubSyncType, id uint64, sub *Subscriber, lastSeen uint64) Replay {
+	if b.local.SubscriberCount(topicType, id) == 0 {
+		name := getTopic(topicType, id)
+		// the topic exists before the sequence is read, so notifications received meanwhile move it too
+		b.local.topic(name)
+		sequence, err := b.storedSequence(name)
+		if err != nil {
+			b.logger.ErrorF("error loading sequence of %s: %v", name, err)
+		} else {
+			b.local.advanceSequence(name, sequence)
+		}
+	}
+	return b.local.SubscribeToTopicFrom(topicType, id, sub, lastSeen)
+}
+
+func (b *PostgresBroker) UnsubscribeToTopic(topicType pubSubSyncConst.PubSubSyncType, id uint64, sub *Subscriber) {
+	b.local.UnsubscribeToTopic(topicType, id, sub)
+}
+
+// Sequence is the local one while the topic has subscribers, the database one otherwise
+func (b *PostgresBroker) Sequence(topicType pubSubSyncConst.PubSubSyncType, id uint64) uint64 {
+	if b.local.SubscriberCount(topicType, id) > 0 {
+		return b.local.Sequence(topicType, id)
+	}
+
+	name := getTopic(topicType, id)
+	sequence, err := b.storedSequence(name)
+	if err != nil {
+		b.logger.ErrorF("error loading sequence of %s: %v", name, err)
+		return b.local.Sequence(topicType, id)
+	}
+	return sequence
+}
+
+// storedSequence is the last sequence assigned to the topic by any instance, 0 for topics never used
+func (b *PostgresBroker) storedSequence(name string) (uint64, error) {
+	var sequence uint64
+	err := b.db.Raw("SELECT sequence FROM sync_topic_sequences WHERE topic = ?", name).Scan(&sequence).Error
+	return sequence, err
+}
+
+func (b *PostgresBroker) PublishEphemeral(topicType pubSubSyncConst.PubSubSyncType, id uint64, msg *syncBroker.SyncResponse, visibility Visibility) {
+	name := getTopic(topicType, id)
+
+	// no sequence is assigned, a notification with sequence 0 is ephemeral for the listeners
+	msg.Sequence = 0
+	msg.Topic = name
+
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		b.logger.ErrorF("error encoding ephemeral event to %s: %v", name, err)
+		return
+	}
+	audience, err := json.Marshal(newWireVisibility(visibility))
+	if err != nil {
+		b.logger.ErrorF("error encoding audience of ephemeral event to %s: %v", name, err)
+		return
+	}
+
+	payload := notification(ephemeralPrefix, audience, base64.StdEncoding.EncodeToString(data))
+	if len(payload) > maxNotifyPayload {
+		b.logger.WarningF("ephemeral event to %s is too large for a notification, dropping it", name)
+		return
+	}
+
+	if err := b.db.Exec("SELECT pg_notify(?, ?)", notifyChannel, payload).Error; err != nil {
+		b.logger.ErrorF("error publishing ephemeral event to %s: %v", name, err)
+	}
+}
+
+func (b *PostgresBroker) Publish(topicType pubSubSyncConst.PubSubSyncType, id uint64, msg *syncBroker.SyncResponse) {
+	b.publishVisible(topicType, id, msg)
+}
+
+func (b *PostgresBroker) publishVisible(topicType pubSubSyncConst.PubSubSyncType, id uint64, msg *syncBroker.SyncResponse) Visibility {
+	visibility := b.local.resolve(topicType, id, msg)
+	b.publish(topicType, id, msg, visibility)
+	return visibility
+}
+
+func (b *PostgresBroker) PublishTo(topicType pubSubSyncConst.PubSubSyncType, id uint64, msg *syncBroker.SyncResponse, visibility Visibility) {
+	b.publishToVisible(topicType, id, msg, visibility)
+}
+
+func (b *PostgresBroker) publishToVisible(topicType pubSubSyncConst.PubSubSyncType, id uint64, msg *syncBroker.SyncResponse, visibility Visibility) Visibility {
+	visibility = b.local.scope(topicType, id, visibility)
+	b.publish(topicType, id, msg, visibility)
+	return visibility
+}
+
+func (b *PostgresBroker) publish(topicType pubSubSyncConst.PubSubSyncType, id uint64, msg *syncBroker.SyncResponse, visibility Visibility) {
+	name := getTopic(topicType, id)
+
+	// the audience travels with the event, so the instances receiving it do not resolve it again
+	audience, err := json.Marshal(newWireVisibility(visibility))
+	if err != nil {
+		msg.Sequence = 0
+		b.logger.ErrorF("error encoding audience of event to %s: %v", name, err)
+		return
+	}
+
+	err = b.db.Transaction(func(tx *gorm.DB) error {
+		// The row lock orders the transactions of a topic, and notifications are delivered
+		// in commit order, so every instance receives the events in sequence order
+		var sequence uint64
+		if err := tx.Raw(`INSERT INTO sync_topic_sequences (topic, sequence) VALUES (?, 1)
+			ON CONFLICT (topic) DO UPDATE SET sequence = sync_topic_sequences.sequence + 1
+			RETURNING sequence`, name).Scan(&sequence).Error; err != nil {
+			return err
+		}
+
+		msg.Sequence = sequence
+		msg.Topic = name
+
+		data, err := proto.Marshal(msg)
+		if err != nil {
+			return err
+		}
+
+		payload := notification(sequencedPrefix, audience, base64.StdEncoding.EncodeToString(data))
+		if len(payload) > maxNotifyPayload {
+			stored := models.SyncEventPayload{Payload: data}
+			if err := tx.Create(&stored).Error; err != nil {
+				return err
+			}
+			payload = notification(payloadRefPrefix, audience, strconv.FormatUint(stored.ID, 10))
+		}
+
+		return tx.Exec("SELECT pg_notify(?, ?)", notifyChannel, payload).Error
+	})
+	if err != nil {
+		// the sequence was rolled back with the transaction
+		msg.Sequence = 0
+		b.logger.ErrorF("error publishing event to %s: %v", name, err)
+	}
+}
+
+// listen opens the dedicated connection used for LISTEN, it cannot come from the gorm pool
+func (b *PostgresBroker) listen(ctx context.Context) (*pgx.Conn, error) {
+	conn, err := pgx.Connect(ctx, b.dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting sync listener: %v", err)
+	}
+
+	if _, err := conn.Exec(ctx, "LISTEN "+notifyChannel); err != nil {
+		conn.Close(ctx)
+		return nil, fmt.Errorf("error listening to %s: %v", notifyChannel, err)
+	}
+
+	return conn, nil
+}
+
+func (b *PostgresBroker) run(conn *pgx.Conn) {
+	ctx := b.ctx
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				conn.Close(context.Background())
+				return
+			}
+			b.logger.ErrorF("sync listener connection lost: %v", err)
+			conn.Close(ctx)
+			if conn = b.reconnect(ctx); conn == nil {
+				return
+			}
+
+			// events published while the listener was down are gone, every stream must resync
+			b.local.markAllLost()
+			continue
+		}
+
+		prefix, visibility, body, err := splitNotification(notification.Payload)
+		if err != nil {
+			b.logger.ErrorF("error decoding sync notification: %v", err)
+			continue
+		}
+		msg, err := b.decode(ctx, prefix, body)
+		if err != nil {
+			b.logger.ErrorF("error decoding sync notification: %v", err)
+			continue
+		}
+
+		if prefix == ephemeralPrefix {
+			b.local.publishEphemeral(msg, visibility)
+			continue
+		}
+		b.local.publishSequenced(msg, visibility)
+	}
+}
+
+// reconnect returns nil when the broker was closed
+func (b *PostgresBroker) reconnect(ctx context.Context) *pgx.Conn {
+	backoff := time.Second
+
+	for {
+		conn, err := b.listen(ctx)
+		if err == nil {
+			b.logger.InfoF("sync listener reconnected")
+			return conn
+		}
+
+		b.logger.ErrorF("%v, retrying in %v", err, backoff)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		if backoff < maxListenBackoff {
+			backoff *= 2
+		}
+	}
+}
+
+func (b *PostgresBroker) decode(ctx context.Context, prefix, body string) (*syncBroker.SyncResponse, error) {
+	var data []byte
+
+	if prefix == payloadRefPrefix {
+		id, err := strconv.ParseUint(body, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid payload reference %q", body)
+		}
+
+		var stored models.SyncEventPayload
+		if err := b.db.WithContext(ctx).Where("id = ?", id).First(&stored).Error; err != nil {
+			return nil, fmt.Errorf("error loading payload %d: %v", id, err)
+		}
+		data = stored.Payload
+	} else {
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return nil, err
+		}
+		data = decoded
+	}
+
+	msg := &syncBroker.SyncResponse{}
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func notification(prefix string, audience []byte, body string) string {
+	return prefix + string(audience) + "\n" + body
+}
+
+// splitNotification returns the prefix, the audience and the body of a notification
+func splitNotification(payload string) (prefix string, visibility Visibility, body string, err error) {
+	for _, known := range []string{sequencedPrefix, ephemeralPrefix, payloadRefPrefix} {
+		if rest, ok := strings.CutPrefix(payload, known); ok {
+			audience, encoded, ok := strings.Cut(rest, "\n")
+			if !ok {
+				return "", Visibility{}, "", fmt.Errorf("notification without audience")
+			}
+			var wire wireVisibility
+			if err := json.Unmarshal([]byte(audience), &wire); err != nil {
+				return "", Visibility{}, "", err
+			}
+			return known, wire.visibility(), encoded, nil
+		}
+	}
+	return "", Visibility{}, "", fmt.Errorf("unknown notification %.8q", payload)
+}
+
+// wireVisibility is a Visibility sent in a notification
+type wireVisibility struct {
+	Public       bool   `json:"p,omitempty"`
+	Masters      bool   `json:"m,omitempty"`
+	UserIDs      []uint `json:"u,omitempty"`
+	TableUserIDs []uint `json:"t,omitempty"`
+}
+
+func newWireVisibility(v Visibility) wireVisibility {
+	wire := wireVisibility{Public: v.Public, Masters: v.Masters}
+	for id := range v.UserIDs {
+		wire.UserIDs = append(wire.UserIDs, id)
+	}
+	for id := range v.TableUserIDs {
+		wire.TableUserIDs = append(wire.TableUserIDs, id)
+	}
+	return wire
+}
+
+func (w wireVisibility) visibility() Visibility {
+	v := Visibility{Public: w.Public, Masters: w.Masters}
+	if len(w.UserIDs) > 0 {
+		v.UserIDs = make(map[uint]struct{}, len(w.UserIDs))
+		for _, id := range w.UserIDs {
+			v.UserIDs[id] = struct{}{}
+		}
+	}
+	if len(w.TableUserIDs) > 0 {
+		v.TableUserIDs = make(map[uint]struct{}, len(w.TableUserIDs))
+		for _, id := range w.TableUserIDs {
+			v.TableUserIDs[id] = struct{}{}
+		}
+	}
+	return v
+}
+
+// cleanPayloads removes the stored payloads every instance already had time to read
+func (b *PostgresBroker) cleanPayloads() {
+	ticker := time.NewTicker(payloadRetention)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := b.db.Where("created_at < ?", time.Now().Add(-payloadRetention)).Delete(&models.SyncEventPayload{}).Error; err != nil {
+			b.logger.ErrorF("error cleaning sync payloads: %v", err)
+		}
+	}
+}
@@ -1,48 +1,266 @@
 package broker
 
 import (
+	"time"
+
 	syncBroker "github.com/GarotoCowboy/vttProject/api/grpc/pb/sync"
+	"github.com/GarotoCowboy/vttProject/api/models/consts"
 	"github.com/GarotoCowboy/vttProject/api/models/consts/pubSubSyncConst"
 )
 
-func (b *Broker) SubscribeToTopic(topicType pubSubSyncConst.PubSubSyncType, id uint64, ch chan *syncBroker.SyncResponse) {
+// Replay is the result of subscribing from a sequence the client already saw
+type Replay struct {
+	Topic string
+	// Missed events the subscriber can see, in publish order
+	Missed []*syncBroker.SyncResponse
+	// Resync is true when the missed events are no longer buffered and the client must reload everything
+	Resync bool
+	// Sequence is the last sequence published to the topic when the subscription started
+	Sequence uint64
+}
 
-	b.mu.Lock()
-	defer b.mu.Unlock()
+func (b *MemoryBroker) SubscribeToTopic(topicType pubSubSyncConst.PubSubSyncType, id uint64, sub *Subscriber) {
+	b.SubscribeToTopicFrom(topicType, id, sub, 0)
+}
 
-	topic := getTopic(topicType, id)
+// SubscribeToTopicFrom subscribes and returns the events published after lastSeen, 0 starts without replay.
+// Both happen under the topic lock, so live events delivered to the channel always come after the replay
+func (b *MemoryBroker) SubscribeToTopicFrom(topicType pubSubSyncConst.PubSubSyncType, id uint64, sub *Subscriber, lastSeen uint64) Replay {
+	name := getTopic(topicType, id)
+	state := b.lockTopic(name)
+	defer state.mu.Unlock()
 
-	if _, ok := b.subscribers[topic]; !ok {
-		b.subscribers[topic] = make(map[chan *syncBroker.SyncResponse]struct{})
+	state.lastActive = time.Now()
+	if _, ok := state.subscribers[sub]; !ok {
+		if len(state.subscribers) == 0 {
+			activeTopicsGauge.WithLabelValues(topicLabel(name)).Inc()
+		}
+		subscribersGauge.WithLabelValues(topicLabel(name)).Inc()
 	}
+	state.subscribers[sub] = struct{}{}
 
-	b.subscribers[topic][ch] = struct{}{}
+	replay := Replay{
+		Topic:    name,
+		Sequence: state.sequence,
+	}
+	if lastSeen == 0 {
+		return replay
+	}
+
+	missed, ok := state.since(lastSeen, sub)
+	replay.Missed = missed
+	replay.Resync = !ok
+	return replay
 }
 
-func (b *Broker) UnsubscribeToTopic(topicType pubSubSyncConst.PubSubSyncType, id uint64, ch chan *syncBroker.SyncResponse) {
+func (b *MemoryBroker) UnsubscribeToTopic(topicType pubSubSyncConst.PubSubSyncType, id uint64, sub *Subscriber) {
+	name := getTopic(topicType, id)
 
-	b.mu.Lock()
-	defer b.mu.Unlock()
+	b.mu.RLock()
+	state, ok := b.topics[name]
+	b.mu.RUnlock()
+	if !ok {
+		return
+	}
 
-	topic := getTopic(topicType, id)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if _, ok := state.subscribers[sub]; !ok {
+		return
+	}
+	delete(state.subscribers, sub)
+	state.lastActive = time.Now()
 
-	if subs, ok := b.subscribers[topic]; ok {
-		delete(subs, ch)
+	subscribersGauge.WithLabelValues(topicLabel(name)).Dec()
+	if len(state.subscribers) == 0 {
+		activeTopicsGauge.WithLabelValues(topicLabel(name)).Dec()
 	}
+}
 
+func (b *MemoryBroker) Publish(topicType pubSubSyncConst.PubSubSyncType, id uint64, msg *syncBroker.SyncResponse) {
+	b.publishVisible(topicType, id, msg)
 }
 
-func (b *Broker) Publish(topicType pubSubSyncConst.PubSubSyncType, id uint64, msg *syncBroker.SyncResponse) {
+func (b *MemoryBroker) publishVisible(topicType pubSubSyncConst.PubSubSyncType, id uint64, msg *syncBroker.SyncResponse) Visibility {
+	visibility := b.resolve(topicType, id, msg)
+	b.publish(getTopic(topicType, id), msg, false, visibility)
+	return visibility
+}
+
+func (b *MemoryBroker) PublishTo(topicType pubSubSyncConst.PubSubSyncType, id uint64, msg *syncBroker.SyncResponse, visibility Visibility) {
+	b.publishToVisible(topicType, id, msg, visibility)
+}
+
+func (b *MemoryBroker) publishToVisible(topicType pubSubSyncConst.PubSubSyncType, id uint64, msg *syncBroker.SyncResponse, visibility Visibility) Visibility {
+	visibility = b.scope(topicType, id, visibility)
+	b.publish(getTopic(topicType, id), msg, false, visibility)
+	return visibility
+}
+
+// resolve returns the audience of an event, it is resolved once per event, before taking any lock
+func (b *MemoryBroker) resolve(topicType pubSubSyncConst.PubSubSyncType, id uint64, msg *syncBroker.SyncResponse) Visibility {
+	if b.filter == nil {
+		return PublicVisibility()
+	}
+	return b.filter.Scope(topicType, id, b.filter.Resolve(msg))
+}
+
+// scope narrows an audience resolved by the caller to who can see the topic
+func (b *MemoryBroker) scope(topicType pubSubSyncConst.PubSubSyncType, id uint64, visibility Visibility) Visibility {
+	if b.filter == nil {
+		return visibility
+	}
+	return b.filter.Scope(topicType, id, visibility)
+}
+
+func (b *MemoryBroker) PublishEphemeral(topicType pubSubSyncConst.PubSubSyncType, id uint64, msg *syncBroker.SyncResponse, visibility Visibility) {
+	msg.Topic = getTopic(topicType, id)
+	b.publishEphemeral(msg, visibility)
+}
+
+func (b *MemoryBroker) publishEphemeral(msg *syncBroker.SyncResponse, visibility Visibility) {
 	b.mu.RLock()
-	defer b.mu.RUnlock()
-	topic := getTopic(topicType, id)
-	if subs, ok := b.subscribers[topic]; ok {
-		for ch := range subs {
-			select {
-			case ch <- msg:
-			default:
+	state, ok := b.topics[msg.GetTopic()]
+	b.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	eventsDelivered.WithLabelValues(topicLabel(msg.GetTopic()), "ephemeral").Inc()
+	for sub := range state.subscribers {
+		if !visibility.Allows(sub) || sub.disconnected() {
+			continue
+		}
+		select {
+		case sub.Ch <- msg:
+		default:
+			eventsDropped.WithLabelValues(topicLabel(msg.GetTopic()), "ephemeral").Inc()
+		}
+	}
+}
+
+// publishSequenced delivers an event that already has its topic and sequence, e.g. one
+// received from another instance. Old and duplicated sequences are ignored, and a gap
+// after the first event means notifications were lost, so the subscribers must resync.
+// Events of topics without subscribers are not buffered, they only move the sequence
+func (b *MemoryBroker) publishSequenced(msg *syncBroker.SyncResponse, visibility Visibility) {
+	b.mu.RLock()
+	state, ok := b.topics[msg.GetTopic()]
+	b.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	state.mu.Lock()
+	if len(state.subscribers) == 0 {
+		state.skipTo(msg.GetSequence())
+		state.mu.Unlock()
+		return
+	}
+	state.mu.Unlock()
+
+	b.publish(msg.GetTopic(), msg, true, visibility)
+}
 
+// advanceSequence moves the topic to a sequence published elsewhere, creating the topic. The events
+// in between are not buffered, so resuming from before them requires a resync
+func (b *MemoryBroker) advanceSequence(name string, sequence uint64) {
+	state := b.lockTopic(name)
+	defer state.mu.Unlock()
+	state.skipTo(sequence)
+}
+
+func (b *MemoryBroker) publish(name string, msg *syncBroker.SyncResponse, sequenced bool, visibility Visibility) {
+	state := b.lockTopic(name)
+	defer state.mu.Unlock()
+
+	state.lastActive = time.Now()
+	if sequenced {
+		if msg.GetSequence() <= state.sequence {
+			return
+		}
+		if state.sequence != 0 && msg.GetSequence() != state.sequence+1 {
+			for sub := range state.subscribers {
+				sub.drop(name)
 			}
 		}
+		state.sequence = msg.GetSequence()
+	} else {
+		state.sequence++
+		msg.Sequence = state.sequence
+		msg.Topic = name
+	}
+	state.append(bufferedEvent{msg: msg, visibility: visibility})
+	eventsDelivered.WithLabelValues(topicLabel(name), "sequenced").Inc()
+
+	updateRoles(state.subscribers, msg)
+	for sub := range state.subscribers {
+		if !visibility.Allows(sub) {
+			continue
+		}
+		b.deliver(sub, name, msg)
+	}
+}
+
+// markAllLost tells every subscriber that events may have been lost, e.g. after the
+// connection used to receive events from other instances was interrupted
+func (b *MemoryBroker) markAllLost() {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for name, state := range b.topics {
+		state.mu.Lock()
+		for sub := range state.subscribers {
+			sub.drop(name)
+		}
+		state.mu.Unlock()
+	}
+}
+
+// SubscriberCount returns how many subscribers the topic has
+func (b *MemoryBroker) SubscriberCount(topicType pubSubSyncConst.PubSubSyncType, id uint64) int {
+	b.mu.RLock()
+	state, ok := b.topics[getTopic(topicType, id)]
+	b.mu.RUnlock()
+	if !ok {
+		return 0
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return len(state.subscribers)
+}
+
+// Sequence returns the last sequence published to the topic, 0 for topics never used
+func (b *MemoryBroker) Sequence(topicType pubSubSyncConst.PubSubSyncType, id uint64) uint64 {
+	name := getTopic(topicType, id)
+
+	b.mu.RLock()
+	state, ok := b.topics[name]
+	evicted := b.evicted[name]
+	b.mu.RUnlock()
+	if !ok {
+		return evicted.sequence
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return state.sequence
+}
+
+// updateRoles keeps the role of open streams in sync when a user is promoted or demoted
+func updateRoles(subs map[*Subscriber]struct{}, msg *syncBroker.SyncResponse) {
+	action, ok := msg.GetAction().(*syncBroker.SyncResponse_UserPromotedDemoted)
+	if !ok {
+		return
+	}
+	tableUser := action.UserPromotedDemoted.GetTableUser()
+	for sub := range subs {
+		if uint64(sub.UserID) == tableUser.GetUserId() {
+			sub.SetRole(consts.Role(tableUser.GetRole()))
+		}
 	}
 }
@@ -0,0 +1,113 @@
+package broker
+
+import (
+	"sync"
+	"sync/atomic"
+
+	syncBroker "github.com/GarotoCowboy/vttProject/api/grpc/pb/sync"
+	"github.com/GarotoCowboy/vttProject/api/models/consts"
+)
+
+// Subscriber is a single sync stream, it carries who is listening so the broker
+// can decide which events this stream is allowed to receive
+type Subscriber struct {
+	UserID      uint
+	TableUserID uint
+	Ch          chan *syncBroker.SyncResponse
+
+	// role can change while the stream is open (promote/demote), so it is read atomically
+	role atomic.Uint32
+
+	// events dropped because the subscriber was too slow
+	dropped atomic.Uint64
+
+	// topics that lost events since the last TakeLostTopics, lostSignal is notified once per batch
+	lostMu     sync.Mutex
+	lostTopics map[string]struct{}
+	lostSignal chan struct{}
+
+	// closed by the broker when the Disconnect policy kicks the subscriber
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewSubscriber(userID, tableUserID uint, role consts.Role, size int) *Subscriber {
+	sub := &Subscriber{
+		UserID:      userID,
+		TableUserID: tableUserID,
+		Ch:          make(chan *syncBroker.SyncResponse, size),
+		lostTopics:  make(map[string]struct{}),
+		lostSignal:  make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+	sub.role.Store(uint32(role))
+	return sub
+}
+
+func (s *Subscriber) Role() consts.Role {
+	return consts.Role(s.role.Load())
+}
+
+func (s *Subscriber) SetRole(role consts.Role) {
+	s.role.Store(uint32(role))
+}
+
+func (s *Subscriber) IsMaster() bool {
+	return s.Role() == consts.Master
+}
+
+// Dropped is how many events this subscriber lost
+func (s *Subscriber) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Lost is notified when events are dropped, the stream must then resync the topics from TakeLostTopics
+func (s *Subscriber) Lost() <-chan struct{} {
+	return s.lostSignal
+}
+
+// TakeLostTopics returns the topics that lost events and clears them
+func (s *Subscriber) TakeLostTopics() []string {
+	s.lostMu.Lock()
+	defer s.lostMu.Unlock()
+
+	topics := make([]string, 0, len(s.lostTopics))
+	for topic := range s.lostTopics {
+		topics = append(topics, topic)
+	}
+	s.lostTopics = make(map[string]struct{})
+	return topics
+}
+
+// Done is closed when the broker disconnects the subscriber
+func (s *Subscriber) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Subscriber) drop(topic string) {
+	s.dropped.Add(1)
+
+	s.lostMu.Lock()
+	s.lostTopics[topic] = struct{}{}
+	s.lostMu.Unlock()
+
+	select {
+	case s.lostSignal <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Subscriber) disconnect() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+func (s *Subscriber) disconnected() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
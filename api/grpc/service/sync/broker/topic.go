@@ -0,0 +1,89 @@
+package broker
+
+import (
+	"sync"
+	"time"
+
+	syncBroker "github.com/GarotoCowboy/vttProject/api/grpc/pb/sync"
+)
+
+// bufferedEvent keeps the visibility resolved on publish, so replays are filtered the same way
+type bufferedEvent struct {
+	msg        *syncBroker.SyncResponse
+	visibility Visibility
+}
+
+// topicState holds the sequence, the replay buffer and the subscribers of one topic.
+// Everything is guarded by the same mutex so a resume never misses or duplicates an event
+type topicState struct {
+	mu          sync.Mutex
+	sequence    uint64
+	buffer      []bufferedEvent
+	next        int
+	subscribers map[*Subscriber]struct{}
+	// lastActive is the time of the last event or subscription change, idle topics are evicted
+	lastActive time.Time
+	// evicted is set when the topic was removed from the broker, a new state replaces it
+	evicted bool
+}
+
+func newTopicState() *topicState {
+	return &topicState{
+		buffer:      make([]bufferedEvent, 0, replayBufferSize),
+		subscribers: make(map[*Subscriber]struct{}),
+		lastActive:  time.Now(),
+	}
+}
+
+// append must be called with the lock held
+func (t *topicState) append(event bufferedEvent) {
+	if len(t.buffer) < replayBufferSize {
+		t.buffer = append(t.buffer, event)
+		return
+	}
+	t.buffer[t.next] = event
+	t.next = (t.next + 1) % replayBufferSize
+}
+
+// skipTo moves the sequence to an event that was not buffered, the buffer is emptied so no
+// replay skips over it. Must be called with the lock held
+func (t *topicState) skipTo(sequence uint64) {
+	if sequence <= t.sequence {
+		return
+	}
+	t.sequence = sequence
+	t.buffer = t.buffer[:0]
+	t.next = 0
+}
+
+// oldest must be called with the lock held, it returns 0 when the buffer is empty
+func (t *topicState) oldest() uint64 {
+	if len(t.buffer) == 0 {
+		return 0
+	}
+	return t.buffer[t.next].msg.GetSequence()
+}
+
+// since returns the buffered events after lastSeen that the subscriber can see, in order.
+// ok is false when the events after lastSeen are no longer buffered. Must be called with the lock held
+func (t *topicState) since(lastSeen uint64, sub *Subscriber) (missed []*syncBroker.SyncResponse, ok bool) {
+	if lastSeen == t.sequence {
+		return nil, true
+	}
+	// a sequence ahead of the topic means the server restarted and the numbering started again
+	if lastSeen > t.sequence {
+		return nil, false
+	}
+	if oldest := t.oldest(); oldest == 0 || lastSeen+1 < oldest {
+		return nil, false
+	}
+
+	for i := 0; i < len(t.buffer); i++ {
+		event := t.buffer[(t.next+i)%len(t.buffer)]
+		if event.msg.GetSequence() <= lastSeen || !event.visibility.Allows(sub) {
+			continue
+		}
+		missed = append(missed, event.msg)
+	}
+	return missed, true
+}
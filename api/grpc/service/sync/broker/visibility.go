@@ -0,0 +1,75 @@
+package broker
+
+import (
+	syncBroker "github.com/GarotoCowboy/vttProject/api/grpc/pb/sync"
+	"github.com/GarotoCowboy/vttProject/api/models/consts"
+	"github.com/GarotoCowboy/vttProject/api/models/consts/pubSubSyncConst"
+)
+
+// Filter resolves who can see an event, it is called once per published event
+// and the result is checked against every subscriber of the topic
+type Filter interface {
+	Resolve(msg *syncBroker.SyncResponse) Visibility
+	// Scope narrows the audience of any event of the topic, also of the ones published with
+	// an audience resolved by the caller, e.g. to masters on the topic of a hidden scene
+	Scope(topicType pubSubSyncConst.PubSubSyncType, id uint64, visibility Visibility) Visibility
+}
+
+// Visibility describes the audience of a single event
+type Visibility struct {
+	// Public events are delivered to everyone on the topic
+	Public bool
+	// Masters of the table can see the event
+	Masters bool
+	// UserIDs are users that can see the event besides masters (object owners)
+	UserIDs map[uint]struct{}
+	// TableUserIDs are table users that can see the event (private chat participants)
+	TableUserIDs map[uint]struct{}
+}
+
+func PublicVisibility() Visibility {
+	return Visibility{Public: true}
+}
+
+func MasterOnlyVisibility() Visibility {
+	return Visibility{Masters: true}
+}
+
+// PermissionVisibility maps a CanBeViewedBy level to an audience, owners are only
+// used when the level is PermissionOwnerAndMaster
+func PermissionVisibility(level consts.PermissionLevel, owners []uint) Visibility {
+	switch {
+	case level >= consts.PermissionAllPlayers:
+		return PublicVisibility()
+	case level == consts.PermissionOwnerAndMaster:
+		visibility := MasterOnlyVisibility()
+		visibility.UserIDs = make(map[uint]struct{}, len(owners))
+		for _, owner := range owners {
+			visibility.UserIDs[owner] = struct{}{}
+		}
+		return visibility
+	default:
+		return MasterOnlyVisibility()
+	}
+}
+
+// masterOnly is true when no one besides masters can see the event
+func (v Visibility) masterOnly() bool {
+	return !v.Public && len(v.UserIDs) == 0 && len(v.TableUserIDs) == 0
+}
+
+func (v Visibility) Allows(sub *Subscriber) bool {
+	if v.Public {
+		return true
+	}
+	if v.Masters && sub.IsMaster() {
+		return true
+	}
+	if _, ok := v.UserIDs[sub.UserID]; ok {
+		return true
+	}
+	if _, ok := v.TableUserIDs[sub.TableUserID]; ok {
+		return true
+	}
+	return false
+}
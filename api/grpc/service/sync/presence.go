@@ -0,0 +1,191 @@
+package sync
+
+import (
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	pbSync "github.com/GarotoCowboy/vttProject/api/grpc/pb/sync"
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/tableUser"
+	"github.com/GarotoCowboy/vttProject/config"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+// Presence tracks the open Sync streams of each table member. A user can have several
+// streams open, they join with the first one and leave when the last one is closed
+type Presence struct {
+	mu     sync.Mutex
+	tables map[uint64]map[uint]*presenceEntry
+
+	// shared has the streams of every instance, nil when this is the only one. Its calls are made with
+	// mu held, so the streams of this instance reach the database in the order they opened and closed
+	shared *sharedPresence
+}
+
+type presenceEntry struct {
+	member   *pbSync.OnlineMember
+	sessions map[*syncSession]struct{}
+}
+
+func NewPresence() *Presence {
+	return &Presence{
+		tables: make(map[uint64]map[uint]*presenceEntry),
+	}
+}
+
+// NewSharedPresence is the presence of several instances sharing the database, e.g. with the postgres broker
+func NewSharedPresence(db *gorm.DB, logger *config.Logger) *Presence {
+	p := NewPresence()
+	p.shared = newSharedPresence(db, logger)
+	return p
+}
+
+// NewPresenceFromEnv shares the presence between instances when SYNC_BROKER shares the events
+func NewPresenceFromEnv(db *gorm.DB, logger *config.Logger) *Presence {
+	if os.Getenv("SYNC_BROKER") == "postgres" {
+		return NewSharedPresence(db, logger)
+	}
+	return NewPresence()
+}
+
+// join registers the stream, it returns the member and true when it is the first stream of the user
+func (p *Presence) join(session *syncSession, username string) (*pbSync.OnlineMember, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	users, ok := p.tables[session.tableID]
+	if !ok {
+		users = make(map[uint]*presenceEntry)
+		p.tables[session.tableID] = users
+	}
+
+	entry, ok := users[session.sub.UserID]
+	first := !ok
+	if first {
+		entry = &presenceEntry{
+			member: &pbSync.OnlineMember{
+				UserId:      uint64(session.sub.UserID),
+				TableUserId: uint64(session.sub.TableUserID),
+				Username:    username,
+				SceneId:     session.sceneID,
+				ConnectedAt: timestamppb.New(time.Now()),
+			},
+			sessions: make(map[*syncSession]struct{}),
+		}
+		users[session.sub.UserID] = entry
+	}
+	entry.sessions[session] = struct{}{}
+
+	member := entry.snapshot()
+	if p.shared != nil {
+		// the user may already be online on another instance, on errors this instance decides alone
+		shared, err := p.shared.join(session.tableID, member)
+		if err != nil {
+			p.shared.logger.ErrorF("error saving presence of user %d in table %d: %v", session.sub.UserID, session.tableID, err)
+		} else {
+			first = first && shared
+		}
+	}
+	return member, first
+}
+
+// leave removes the stream, it returns true when it was the last stream of the user
+func (p *Presence) leave(session *syncSession) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	users := p.tables[session.tableID]
+	entry, ok := users[session.sub.UserID]
+	if !ok {
+		return false
+	}
+
+	delete(entry.sessions, session)
+	last := len(entry.sessions) == 0
+	if last {
+		delete(users, session.sub.UserID)
+		if len(users) == 0 {
+			delete(p.tables, session.tableID)
+		}
+	}
+
+	if p.shared != nil {
+		shared, err := p.shared.leave(session.tableID, session.sub.UserID, len(entry.sessions))
+		if err != nil {
+			p.shared.logger.ErrorF("error saving presence of user %d in table %d: %v", session.sub.UserID, session.tableID, err)
+		} else {
+			last = last && shared
+		}
+	}
+	return last
+}
+
+// changeScene moves the member to the scene of the stream, it returns the previous scene
+// and true when the scene of the member changed
+func (p *Presence) changeScene(session *syncSession, sceneID uint64) (uint64, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry, ok := p.tables[session.tableID][session.sub.UserID]
+	if !ok {
+		return 0, false
+	}
+
+	previous := entry.member.SceneId
+	entry.member.SceneId = sceneID
+	changed := previous != sceneID
+
+	if p.shared != nil {
+		// the scene the member was on may have been chosen on another instance
+		sharedPrevious, sharedChanged, err := p.shared.changeScene(session.tableID, session.sub.UserID, sceneID)
+		if err != nil {
+			p.shared.logger.ErrorF("error saving scene of user %d in table %d: %v", session.sub.UserID, session.tableID, err)
+		} else {
+			previous, changed = sharedPrevious, sharedChanged
+		}
+	}
+	if !changed {
+		return 0, false
+	}
+	return previous, true
+}
+
+// List returns copies of the online members of the table, ordered by connection time
+func (p *Presence) List(tableID uint64) []*pbSync.OnlineMember {
+	if p.shared != nil {
+		members, err := p.shared.list(tableID)
+		if err == nil {
+			return members
+		}
+		// the members of this instance are still better than none
+		p.shared.logger.ErrorF("error listing presence of table %d: %v", tableID, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	members := make([]*pbSync.OnlineMember, 0, len(p.tables[tableID]))
+	for _, entry := range p.tables[tableID] {
+		members = append(members, entry.snapshot())
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].ConnectedAt.AsTime().Before(members[j].ConnectedAt.AsTime())
+	})
+	return members
+}
+
+// snapshot copies the member with the current role and number of streams, must be called with the lock held
+func (e *presenceEntry) snapshot() *pbSync.OnlineMember {
+	member := proto.Clone(e.member).(*pbSync.OnlineMember)
+	member.Connections = uint32(len(e.sessions))
+	// the role can change while the streams are open, the subscribers follow promotions
+	for session := range e.sessions {
+		member.Role = tableUser.Role(session.sub.Role())
+		break
+	}
+	return member
+}
@@ -0,0 +1,26 @@
+package sync
+
+import (
+	"context"
+
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/sync"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *SyncServer) ListOnlineMembers(ctx context.Context, req *sync.ListOnlineMembersRequest) (*sync.ListOnlineMembersResponse, error) {
+	s.Logger.InfoF("GRPC Requisition to ListOnlineMembers on table %d", req.GetTableId())
+
+	// Membership of the table is checked by the GrpcTableMemberInterceptor
+	if req.GetTableId() == 0 {
+		s.Logger.ErrorF("Invalid request: Table id must be greater than zero")
+		return nil, status.Errorf(codes.InvalidArgument, "table id must be greater than zero")
+	}
+
+	members := s.Presence.List(req.GetTableId())
+
+	s.Logger.InfoF("Found %d online members on table %d", len(members), req.GetTableId())
+	return &sync.ListOnlineMembersResponse{
+		Members: members,
+	}, nil
+}
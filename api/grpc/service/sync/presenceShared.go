@@ -0,0 +1,216 @@
+package sync
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	pbSync "github.com/GarotoCowboy/vttProject/api/grpc/pb/sync"
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/tableUser"
+	"github.com/GarotoCowboy/vttProject/api/models"
+	"github.com/GarotoCowboy/vttProject/api/utils"
+	"github.com/GarotoCowboy/vttProject/config"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// presenceRefreshInterval is how often an instance tells it is still alive
+	presenceRefreshInterval = 15 * time.Second
+	// presenceTTL is how long the rows of an instance that stopped refreshing are still online,
+	// a crashed instance does not publish the UserLeft of its members
+	presenceTTL = time.Minute
+	// presenceRetention is when those rows are removed
+	presenceRetention = 10 * presenceTTL
+)
+
+// sharedPresence keeps the presence of every server instance in sync_presences, so the members
+// online on other instances are listed and a user joins and leaves once across all of them
+type sharedPresence struct {
+	db         *gorm.DB
+	logger     *config.Logger
+	instanceID string
+}
+
+func newSharedPresence(db *gorm.DB, logger *config.Logger) *sharedPresence {
+	hostname, _ := os.Hostname()
+	p := &sharedPresence{
+		db:         db,
+		logger:     logger,
+		instanceID: fmt.Sprintf("%s-%s", hostname, utils.StringWithCharset(8)),
+	}
+	go p.refresh()
+	return p
+}
+
+// lock orders the changes to the presence of a user between instances, it is released with the transaction
+func lockPresence(tx *gorm.DB, tableID uint64, userID uint) error {
+	return tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", fmt.Sprintf("presence:%d:%d", tableID, userID)).Error
+}
+
+// othersOnline counts the instances besides this one where the user has streams open
+func (p *sharedPresence) othersOnline(tx *gorm.DB, tableID uint64, userID uint) (int64, error) {
+	var count int64
+	err := tx.Model(&models.SyncPresence{}).
+		Where("table_id = ? AND user_id = ? AND instance_id <> ? AND last_seen >= ?", tableID, userID, p.instanceID, time.Now().Add(-presenceTTL)).
+		Count(&count).Error
+	return count, err
+}
+
+// join saves the streams of the member on this instance, it returns true when the user was online on no other instance
+func (p *sharedPresence) join(tableID uint64, member *pbSync.OnlineMember) (bool, error) {
+	var first bool
+	err := p.db.Transaction(func(tx *gorm.DB) error {
+		userID := uint(member.UserId)
+		if err := lockPresence(tx, tableID, userID); err != nil {
+			return err
+		}
+		others, err := p.othersOnline(tx, tableID, userID)
+		if err != nil {
+			return err
+		}
+		first = others == 0
+
+		now := time.Now()
+		row := models.SyncPresence{
+			InstanceID:  p.instanceID,
+			TableID:     uint(tableID),
+			UserID:      userID,
+			TableUserID: uint(member.TableUserId),
+			Username:    member.Username,
+			SceneID:     member.SceneId,
+			Connections: member.Connections,
+			ConnectedAt: member.ConnectedAt.AsTime(),
+			LastSeen:    now,
+		}
+		if member.Connections > 1 {
+			return tx.Model(&row).Updates(map[string]interface{}{"connections": member.Connections, "last_seen": now}).Error
+		}
+		row.SceneChangedAt = now
+		return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&row).Error
+	})
+	return first, err
+}
+
+// leave saves the streams left on this instance, it returns true when the user is no longer online on any instance
+func (p *sharedPresence) leave(tableID uint64, userID uint, connections int) (bool, error) {
+	var last bool
+	err := p.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockPresence(tx, tableID, userID); err != nil {
+			return err
+		}
+
+		row := models.SyncPresence{InstanceID: p.instanceID, TableID: uint(tableID), UserID: userID}
+		if connections > 0 {
+			return tx.Model(&row).Update("connections", connections).Error
+		}
+		if err := tx.Delete(&row).Error; err != nil {
+			return err
+		}
+
+		others, err := p.othersOnline(tx, tableID, userID)
+		if err != nil {
+			return err
+		}
+		last = others == 0
+		return nil
+	})
+	return last, err
+}
+
+// changeScene moves the member to a scene, it returns the scene the member was on in any instance
+// and true when it changed
+func (p *sharedPresence) changeScene(tableID uint64, userID uint, sceneID uint64) (uint64, bool, error) {
+	var previous uint64
+	err := p.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockPresence(tx, tableID, userID); err != nil {
+			return err
+		}
+
+		var current models.SyncPresence
+		result := tx.Where("table_id = ? AND user_id = ? AND last_seen >= ?", tableID, userID, time.Now().Add(-presenceTTL)).
+			Order("scene_changed_at DESC").Limit(1).Find(&current)
+		if result.Error != nil {
+			return result.Error
+		}
+		previous = current.SceneID
+
+		row := models.SyncPresence{InstanceID: p.instanceID, TableID: uint(tableID), UserID: userID}
+		return tx.Model(&row).Updates(map[string]interface{}{"scene_id": sceneID, "scene_changed_at": time.Now()}).Error
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return previous, previous != sceneID, nil
+}
+
+// list merges the rows of every instance, the role is the current one of the tableUser
+func (p *sharedPresence) list(tableID uint64) ([]*pbSync.OnlineMember, error) {
+	var rows []models.SyncPresence
+	if err := p.db.Where("table_id = ? AND last_seen >= ?", tableID, time.Now().Add(-presenceTTL)).
+		Order("scene_changed_at ASC").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	tableUserIDs := make([]uint, 0, len(rows))
+	for _, row := range rows {
+		tableUserIDs = append(tableUserIDs, row.TableUserID)
+	}
+	var tableUsers []models.TableUser
+	if len(tableUserIDs) > 0 {
+		if err := p.db.Select("id", "role").Where("id IN ?", tableUserIDs).Find(&tableUsers).Error; err != nil {
+			return nil, err
+		}
+	}
+	roles := make(map[uint]tableUser.Role, len(tableUsers))
+	for _, tu := range tableUsers {
+		roles[tu.ID] = tableUser.Role(tu.Role)
+	}
+
+	members := make(map[uint]*pbSync.OnlineMember)
+	for _, row := range rows {
+		member, ok := members[row.UserID]
+		if !ok {
+			member = &pbSync.OnlineMember{
+				UserId:      uint64(row.UserID),
+				TableUserId: uint64(row.TableUserID),
+				Username:    row.Username,
+				Role:        roles[row.TableUserID],
+				ConnectedAt: timestamppb.New(row.ConnectedAt),
+			}
+			members[row.UserID] = member
+		}
+		// rows are ordered by the scene change, the last one is the current scene
+		member.SceneId = row.SceneID
+		member.Connections += row.Connections
+		if row.ConnectedAt.Before(member.ConnectedAt.AsTime()) {
+			member.ConnectedAt = timestamppb.New(row.ConnectedAt)
+		}
+	}
+
+	list := make([]*pbSync.OnlineMember, 0, len(members))
+	for _, member := range members {
+		list = append(list, member)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ConnectedAt.AsTime().Before(list[j].ConnectedAt.AsTime())
+	})
+	return list, nil
+}
+
+// refresh keeps the rows of this instance alive and removes the ones of instances gone for long
+func (p *sharedPresence) refresh() {
+	ticker := time.NewTicker(presenceRefreshInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if err := p.db.Model(&models.SyncPresence{}).Where("instance_id = ?", p.instanceID).Update("last_seen", now).Error; err != nil {
+			p.logger.ErrorF("error refreshing presence of instance %s: %v", p.instanceID, err)
+		}
+		if err := p.db.Where("last_seen < ?", now.Add(-presenceRetention)).Delete(&models.SyncPresence{}).Error; err != nil {
+			p.logger.ErrorF("error cleaning presence of stopped instances: %v", err)
+		}
+	}
+}
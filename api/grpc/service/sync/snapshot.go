@@ -0,0 +1,231 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/bar"
+	imageLibrary "github.com/GarotoCowboy/vttProject/api/grpc/pb/imageLibrary"
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/placedImage"
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/placedToken"
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/scene"
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/sync"
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/tableUser"
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/token"
+	"github.com/GarotoCowboy/vttProject/api/grpc/service/sync/broker"
+	"github.com/GarotoCowboy/vttProject/api/models"
+	"github.com/GarotoCowboy/vttProject/api/models/consts"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"gorm.io/gorm"
+)
+
+// buildSnapshot loads the table and the current scene with the same visibility rules the broker
+// applies to events, so a player never receives in the snapshot what would be filtered from the stream
+func (s *SyncServer) buildSnapshot(ctx context.Context, tableID, sceneID uint64, sub *broker.Subscriber, tableSequence, sceneSequence uint64) (*sync.Snapshot, error) {
+	s.Logger.InfoF("Building snapshot of table %d and scene %d for user %d", tableID, sceneID, sub.UserID)
+
+	// The current scene
+	snapshot, err := s.buildSceneSnapshot(ctx, tableID, sceneID, sub, sceneSequence)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.TableSequence = tableSequence
+
+	db := s.DB.WithContext(ctx)
+
+	// Scenes
+	var sceneModels []models.Scene
+	sceneQuery := db.Where("table_id = ?", tableID).Order("id ASC")
+	if !sub.IsMaster() {
+		sceneQuery = sceneQuery.Where("is_visible = ?", true)
+	}
+	if err := sceneQuery.Find(&sceneModels).Error; err != nil {
+		s.Logger.ErrorF("error fetching scenes of table %d for snapshot: %v", tableID, err)
+		return nil, status.Error(codes.Internal, "error building snapshot")
+	}
+	for _, sceneModel := range sceneModels {
+		snapshot.Scenes = append(snapshot.Scenes, sceneToProto(sceneModel))
+	}
+
+	// Token library with the bars
+	var tokenModels []models.Token
+	if err := visibleLibraryObjects(db.Preload("Bars").Where("table_id = ?", tableID), sub).
+		Order("id ASC").Find(&tokenModels).Error; err != nil {
+		s.Logger.ErrorF("error fetching tokens of table %d for snapshot: %v", tableID, err)
+		return nil, status.Error(codes.Internal, "error building snapshot")
+	}
+	for _, model := range tokenModels {
+		snapshot.Tokens = append(snapshot.Tokens, &token.Token{
+			TokenId:  uint64(model.ID),
+			TableId:  uint64(model.TableID),
+			Name:     model.Name,
+			ImageUrl: model.ImageURL,
+		})
+		for _, barModel := range model.Bars {
+			snapshot.Bars = append(snapshot.Bars, &bar.Bar{
+				BarId:    uint64(barModel.ID),
+				TokenId:  uint64(barModel.TokenID),
+				Name:     barModel.Name,
+				Value:    barModel.Value,
+				MaxValue: barModel.MaxValue,
+				Color:    barModel.Color,
+			})
+		}
+	}
+
+	// Image library
+	var imageModels []models.Image
+	if err := visibleLibraryObjects(db.Where("table_id = ?", tableID), sub).
+		Order("id ASC").Find(&imageModels).Error; err != nil {
+		s.Logger.ErrorF("error fetching images of table %d for snapshot: %v", tableID, err)
+		return nil, status.Error(codes.Internal, "error building snapshot")
+	}
+	for _, model := range imageModels {
+		snapshot.Images = append(snapshot.Images, &imageLibrary.Image{
+			TableId:     uint64(model.TableID),
+			ImageId:     uint64(model.ID),
+			Name:        model.Name,
+			Width:       uint32(model.Width),
+			Height:      uint32(model.Height),
+			ContentType: model.ContentType,
+			CreatedAt:   timestamppb.New(model.CreatedAt),
+			UpdatedAt:   timestamppb.New(model.UpdatedAt),
+			Checksum:    model.CheckSum,
+			ImageUrl:    fmt.Sprintf("http://localhost:8081/%s", filepath.ToSlash(model.ImagePath)),
+		})
+	}
+
+	// Members
+	var tableUserModels []models.TableUser
+	if err := db.Where("table_id = ?", tableID).Order("id ASC").Find(&tableUserModels).Error; err != nil {
+		s.Logger.ErrorF("error fetching members of table %d for snapshot: %v", tableID, err)
+		return nil, status.Error(codes.Internal, "error building snapshot")
+	}
+	for _, model := range tableUserModels {
+		snapshot.Members = append(snapshot.Members, &tableUser.TableUser{
+			TableId: uint64(model.TableID),
+			UserId:  uint64(model.UserID),
+			Role:    tableUser.Role(model.Role),
+		})
+	}
+
+	// Members with an open stream
+	snapshot.OnlineMembers = s.Presence.List(tableID)
+
+	s.Logger.InfoF("Snapshot of table %d built: %d scenes, %d placed tokens, %d placed images, %d tokens, %d images",
+		tableID, len(snapshot.Scenes), len(snapshot.PlacedTokens), len(snapshot.PlacedImages), len(snapshot.Tokens), len(snapshot.Images))
+
+	return snapshot, nil
+}
+
+// buildSceneSnapshot loads only the current scene, it is the snapshot sent when switching scenes.
+// Scene, placed tokens and placed images stay empty when the user cannot see the scene
+func (s *SyncServer) buildSceneSnapshot(ctx context.Context, tableID, sceneID uint64, sub *broker.Subscriber, sceneSequence uint64) (*sync.Snapshot, error) {
+	snapshot := &sync.Snapshot{
+		SceneSequence: sceneSequence,
+	}
+	if sceneID == 0 {
+		return snapshot, nil
+	}
+
+	db := s.DB.WithContext(ctx)
+
+	var sceneModel models.Scene
+	sceneQuery := db.Where("id = ? AND table_id = ?", sceneID, tableID)
+	if !sub.IsMaster() {
+		sceneQuery = sceneQuery.Where("is_visible = ?", true)
+	}
+	if err := sceneQuery.Limit(1).Find(&sceneModel).Error; err != nil {
+		s.Logger.ErrorF("error fetching scene %d for snapshot: %v", sceneID, err)
+		return nil, status.Error(codes.Internal, "error building snapshot")
+	}
+	if sceneModel.ID == 0 {
+		return snapshot, nil
+	}
+	snapshot.Scene = sceneToProto(sceneModel)
+
+	var placedTokenModels []models.PlacedToken
+	if err := visiblePlacedObjects(db.Where("scene_id = ?", sceneID), "placed_tokens", "placed_token_id", sub).
+		Order("id ASC").Find(&placedTokenModels).Error; err != nil {
+		s.Logger.ErrorF("error fetching placed tokens of scene %d for snapshot: %v", sceneID, err)
+		return nil, status.Error(codes.Internal, "error building snapshot")
+	}
+	for _, model := range placedTokenModels {
+		snapshot.PlacedTokens = append(snapshot.PlacedTokens, &placedToken.PlacedToken{
+			SceneId:       uint64(model.SceneID),
+			PlacedTokenId: uint64(model.ID),
+			TokenId:       uint64(model.TokenID),
+			Size:          model.Size,
+			Layer:         placedToken.LayerType(model.LayerType),
+			PosX:          model.PosX,
+			PosY:          model.PosY,
+			Rotation:      int32(model.Rotation),
+			CreatedAt:     timestamppb.New(model.CreatedAt),
+			UpdatedAt:     timestamppb.New(model.UpdatedAt),
+		})
+	}
+
+	var placedImageModels []models.PlacedImage
+	if err := visiblePlacedObjects(db.Where("scene_id = ?", sceneID), "placed_images", "placed_image_id", sub).
+		Order("id ASC").Find(&placedImageModels).Error; err != nil {
+		s.Logger.ErrorF("error fetching placed images of scene %d for snapshot: %v", sceneID, err)
+		return nil, status.Error(codes.Internal, "error building snapshot")
+	}
+	for _, model := range placedImageModels {
+		snapshot.PlacedImages = append(snapshot.PlacedImages, &placedImage.PlacedImage{
+			SceneId:       uint64(model.SceneID),
+			PlacedImageId: uint64(model.ID),
+			ImageId:       uint64(model.ImageID),
+			Width:         uint64(model.Width),
+			Height:        uint64(model.Height),
+			Layer:         placedImage.LayerType(model.LayerType),
+			PosX:          model.PosX,
+			PosY:          model.PosY,
+			Rotation:      int32(model.Rotation),
+			CreatedAt:     timestamppb.New(model.CreatedAt),
+			UpdatedAt:     timestamppb.New(model.UpdatedAt),
+		})
+	}
+
+	return snapshot, nil
+}
+
+// visiblePlacedObjects filters placed tokens/images a player cannot see: the master layer and
+// objects whose CanBeViewedBy does not include the player (owners are in game_object_owners)
+func visiblePlacedObjects(query *gorm.DB, table, ownerColumn string, sub *broker.Subscriber) *gorm.DB {
+	if sub.IsMaster() {
+		return query
+	}
+	return query.Where("layer_type <> ?", consts.MasterLayer).
+		Where("can_be_viewed_by >= ? OR (can_be_viewed_by = ? AND EXISTS (SELECT 1 FROM game_object_owners o WHERE o."+ownerColumn+" = "+table+".id AND o.user_id = ? AND o.deleted_at IS NULL))",
+			consts.PermissionAllPlayers, consts.PermissionOwnerAndMaster, sub.UserID)
+}
+
+// visibleLibraryObjects filters library tokens/images a player cannot see, they have no owners
+func visibleLibraryObjects(query *gorm.DB, sub *broker.Subscriber) *gorm.DB {
+	if sub.IsMaster() {
+		return query
+	}
+	return query.Where("can_be_viewed_by >= ?", consts.PermissionAllPlayers)
+}
+
+func sceneToProto(sceneModel models.Scene) *scene.Scene {
+	return &scene.Scene{
+		SceneId:             uint64(sceneModel.ID),
+		TableId:             uint64(sceneModel.TableID),
+		Name:                sceneModel.Name,
+		Width:               uint32(sceneModel.Width),
+		Height:              uint32(sceneModel.Height),
+		IsVisible:           wrapperspb.Bool(sceneModel.IsVisible),
+		GridCellDistance:    uint64(sceneModel.GridCellDistance),
+		GridType:            scene.GridType(sceneModel.GridType),
+		BackgroundImagePath: sceneModel.BackgroundImagePath,
+		BackgroundColor:     sceneModel.BackGroundColor,
+		CreatedAt:           timestamppb.New(sceneModel.CreatedAt),
+		UpdatedAt:           timestamppb.New(sceneModel.UpdatedAt),
+	}
+}
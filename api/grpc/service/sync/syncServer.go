@@ -1,20 +1,56 @@
 package sync
 
 import (
+	"os"
+	"time"
+
 	syncBroker "github.com/GarotoCowboy/vttProject/api/grpc/pb/sync"
 	"github.com/GarotoCowboy/vttProject/api/grpc/service/sync/broker"
 	"github.com/GarotoCowboy/vttProject/config"
+	"gorm.io/gorm"
 )
 
 type SyncServer struct {
 	syncBroker.UnimplementedSyncServiceServer
-	Broker *broker.Broker
-	Logger *config.Logger
+	DB       *gorm.DB
+	Broker   broker.Broker
+	Logger   *config.Logger
+	Presence *Presence
+
+	// HeartbeatInterval is how often idle streams receive a Heartbeat, 0 disables them
+	HeartbeatInterval time.Duration
+	// IdleTimeout closes sessions whose client sent nothing for this long, 0 disables it. Opt-in, clients older
+	// than the heartbeat send nothing after joining and the gRPC keepalive already drops dead connections
+	IdleTimeout time.Duration
+	// MemberCheckInterval is how often a session checks its user is still a member of the table, 0 disables it.
+	// The stream interceptor only checks the messages of the client, which may only send heartbeats
+	MemberCheckInterval time.Duration
 }
 
-func NewSyncServer(broker *broker.Broker, Logger *config.Logger) *SyncServer {
+const (
+	defaultHeartbeatInterval   = 15 * time.Second
+	defaultMemberCheckInterval = 30 * time.Second
+)
+
+func NewSyncServer(db *gorm.DB, broker broker.Broker, Logger *config.Logger) *SyncServer {
 	return &SyncServer{
-		Broker: broker,
-		Logger: Logger,
+		DB:       db,
+		Broker:   broker,
+		Logger:   Logger,
+		Presence: NewPresenceFromEnv(db, Logger),
+
+		HeartbeatInterval: durationFromEnv("SYNC_HEARTBEAT_INTERVAL", defaultHeartbeatInterval),
+		IdleTimeout:       durationFromEnv("SYNC_IDLE_TIMEOUT", 0),
+
+		MemberCheckInterval: defaultMemberCheckInterval,
+	}
+}
+
+// durationFromEnv reads a duration like "30s", "0" disables the feature
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || value < 0 {
+		return fallback
 	}
+	return value
 }
@@ -1,11 +1,18 @@
 package sync
 
 import (
+	"context"
 	"io"
 
+	"github.com/GarotoCowboy/vttProject/api/grpc/events"
 	"github.com/GarotoCowboy/vttProject/api/grpc/pb/sync"
+	"github.com/GarotoCowboy/vttProject/api/grpc/service/sync/broker"
+	"github.com/GarotoCowboy/vttProject/api/models"
 	"github.com/GarotoCowboy/vttProject/api/models/consts/pubSubSyncConst"
+	"github.com/GarotoCowboy/vttProject/api/utils"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func (s *SyncServer) Sync(stream grpc.BidiStreamingServer[sync.SyncRequest, sync.SyncResponse]) error {
@@ -18,45 +25,145 @@ func (s *SyncServer) Sync(stream grpc.BidiStreamingServer[sync.SyncRequest, sync
 		return nil
 	}
 
+	// Authentication and table/scene membership are checked by the stream interceptors on Recv
 	if err != nil {
 		s.Logger.ErrorF("error to receive first syncRequest: %v", err)
-		return nil
+		return err
 	}
 
 	sceneID := req.GetSceneId()
 	tableId := req.GetTableId()
 
-	s.Logger.InfoF("Client connected for table: %v and scene: %v", tableId, sceneID)
+	if tableId == 0 {
+		s.Logger.ErrorF("first syncRequest without table id")
+		return status.Error(codes.InvalidArgument, "table_id is required")
+	}
 
-	msgChan := make(chan *sync.SyncResponse, 100)
+	userID, err := utils.PickUserIdJWT(stream.Context())
+	if err != nil {
+		return err
+	}
 
-	s.Broker.SubscribeToTopic(pubSubSyncConst.TableSync, tableId, msgChan)
-	s.Broker.SubscribeToTopic(pubSubSyncConst.SceneSync, sceneID, msgChan)
+	// The tableUser carries the role used by the broker to filter hidden events
+	var tableUserModel models.TableUser
+	if err := s.DB.WithContext(stream.Context()).Preload("User").Where("user_id = ? AND table_id = ?", userID, tableId).First(&tableUserModel).Error; err != nil {
+		s.Logger.ErrorF("error fetching tableUser of user %d in table %d: %v", userID, tableId, err)
+		return status.Error(codes.Internal, "error fetching tableUser")
+	}
 
-	defer func() {
-		s.Logger.InfoF("Cleaning up subscriptions for clients from table: %v and scene:", tableId, sceneID)
-		s.Broker.UnsubscribeToTopic(pubSubSyncConst.TableSync, tableId, msgChan)
-		s.Broker.UnsubscribeToTopic(pubSubSyncConst.SceneSync, sceneID, msgChan)
+	s.Logger.InfoF("User %d connected for table: %v and scene: %v", userID, tableId, sceneID)
 
-		close(msgChan)
-	}()
+	session := &syncSession{
+		server:    s,
+		stream:    stream,
+		sub:       broker.NewSubscriber(userID, tableUserModel.ID, tableUserModel.Role, 100),
+		tableID:   tableId,
+		sceneID:   sceneID,
+		lastSent:  make(map[string]uint64),
+		skipUntil: make(map[string]uint64),
+	}
 
-	ctx := stream.Context()
+	if err := s.checkSceneVisible(stream.Context(), sceneID, session.sub); err != nil {
+		return err
+	}
 
-	go func() {
-		<-ctx.Done()
-		s.Logger.InfoF("client disconnected from scene %v", sceneID)
-	}()
+	// When reconnecting the events published since the last sequence seen are returned with the subscription
+	tableReplay := s.Broker.SubscribeToTopicFrom(pubSubSyncConst.TableSync, tableId, session.sub, req.GetLastSeenSequence())
+	sceneReplay := s.Broker.SubscribeToTopicFrom(pubSubSyncConst.SceneSync, sceneID, session.sub, req.GetLastSeenSceneSequence())
+	userReplay := s.Broker.SubscribeToTopicFrom(pubSubSyncConst.UserSync, uint64(userID), session.sub, 0)
+	session.tableTopic = tableReplay.Topic
+	session.sceneTopic = sceneReplay.Topic
+	session.userTopic = userReplay.Topic
+	session.lastSent[tableReplay.Topic] = tableReplay.Sequence
+	session.lastSent[sceneReplay.Topic] = sceneReplay.Sequence
+
+	defer session.close()
 
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case msg := <-msgChan:
-			if err := stream.Send(msg); err != nil {
-				s.Logger.ErrorF("error to send message from client scene: %d %v", sceneID, err)
+	// A new session, or one whose missed events are gone, starts with the whole state instead of a replay
+	newSession := req.GetLastSeenSequence() == 0 || (sceneID != 0 && req.GetLastSeenSceneSequence() == 0)
+	if newSession || tableReplay.Resync || sceneReplay.Resync {
+		// the ResyncRequired tells a reconnecting client to drop its local state before the snapshot
+		if tableReplay.Resync {
+			if err := s.sendReplay(stream, tableReplay, tableId, sceneID, req.GetLastSeenSequence()); err != nil {
 				return err
 			}
 		}
+		if sceneReplay.Resync {
+			if err := s.sendReplay(stream, sceneReplay, tableId, sceneID, req.GetLastSeenSceneSequence()); err != nil {
+				return err
+			}
+		}
+
+		if err := session.sendSnapshot(); err != nil {
+			return err
+		}
+	} else {
+		if err := s.sendReplay(stream, tableReplay, tableId, sceneID, req.GetLastSeenSequence()); err != nil {
+			return err
+		}
+		if err := s.sendReplay(stream, sceneReplay, tableId, sceneID, req.GetLastSeenSceneSequence()); err != nil {
+			return err
+		}
+	}
+
+	// Presence is only published once the client has its initial state
+	if member, first := s.Presence.join(session, tableUserModel.User.Username); first {
+		s.Broker.Publish(pubSubSyncConst.TableSync, tableId, events.NewUserJoinedEvent(tableId, member))
+	}
+	defer func() {
+		if last := s.Presence.leave(session); last {
+			s.Broker.Publish(pubSubSyncConst.TableSync, tableId, events.NewUserLeftEvent(tableId, uint64(userID), uint64(tableUserModel.ID)))
+		}
+	}()
+
+	return session.serve()
+}
+
+func (s *SyncServer) sendSnapshot(stream grpc.BidiStreamingServer[sync.SyncRequest, sync.SyncResponse], tableID, sceneID uint64, sub *broker.Subscriber, tableSequence, sceneSequence uint64) error {
+	snapshot, err := s.buildSnapshot(stream.Context(), tableID, sceneID, sub, tableSequence, sceneSequence)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(events.NewSnapshotEvent(tableID, sceneID, snapshot)); err != nil {
+		s.Logger.ErrorF("error to send snapshot of table %d: %v", tableID, err)
+		return err
+	}
+	return nil
+}
+
+// sendReplay sends the missed events of a topic, or a ResyncRequired when they are no longer buffered
+func (s *SyncServer) sendReplay(stream grpc.BidiStreamingServer[sync.SyncRequest, sync.SyncResponse], replay broker.Replay, tableID, sceneID, lastSeen uint64) error {
+	if replay.Resync {
+		s.Logger.WarningF("events of %s after sequence %d are no longer buffered, client must resync", replay.Topic, lastSeen)
+		return stream.Send(events.NewResyncRequiredEvent(tableID, sceneID, replay.Topic, lastSeen, replay.Sequence))
+	}
+
+	if len(replay.Missed) > 0 {
+		s.Logger.InfoF("replaying %d events of %s after sequence %d", len(replay.Missed), replay.Topic, lastSeen)
+	}
+	for _, msg := range replay.Missed {
+		if err := stream.Send(msg); err != nil {
+			s.Logger.ErrorF("error to replay message of %s: %v", replay.Topic, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSceneVisible keeps players out of the scenes hidden by the masters
+func (s *SyncServer) checkSceneVisible(ctx context.Context, sceneID uint64, sub *broker.Subscriber) error {
+	if sceneID == 0 || sub.IsMaster() {
+		return nil
+	}
+
+	var sceneModel models.Scene
+	if err := s.DB.WithContext(ctx).Select("id", "is_visible").Where("id = ?", sceneID).First(&sceneModel).Error; err != nil {
+		s.Logger.ErrorF("error fetching scene %d: %v", sceneID, err)
+		return status.Error(codes.Internal, "error fetching scene")
+	}
+	if !sceneModel.IsVisible {
+		s.Logger.WarningF("user %d tried to join the hidden scene %d", sub.UserID, sceneID)
+		return status.Error(codes.PermissionDenied, "scene is hidden from players")
 	}
+	return nil
 }
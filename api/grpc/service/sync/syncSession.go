@@ -0,0 +1,282 @@
+package sync
+
+import (
+	"io"
+	"time"
+
+	"github.com/GarotoCowboy/vttProject/api/grpc/events"
+	"github.com/GarotoCowboy/vttProject/api/grpc/pb/sync"
+	"github.com/GarotoCowboy/vttProject/api/grpc/service/sync/broker"
+	"github.com/GarotoCowboy/vttProject/api/models/consts/pubSubSyncConst"
+	"github.com/GarotoCowboy/vttProject/api/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// syncSession is the state of one Sync stream. It is only used by the goroutine running
+// the Sync loop, so it needs no lock of its own
+type syncSession struct {
+	server *SyncServer
+	stream grpc.BidiStreamingServer[sync.SyncRequest, sync.SyncResponse]
+	sub    *broker.Subscriber
+
+	tableID    uint64
+	sceneID    uint64
+	tableTopic string
+	sceneTopic string
+	// userTopic has the notifications of the user, it is never replayed, the inbox is kept in the database
+	userTopic string
+
+	// last sequence sent per topic, it tells the client where events were lost
+	lastSent map[string]uint64
+	// events already included in a snapshot are not sent again
+	skipUntil map[string]uint64
+}
+
+// send drops events of topics the session left (e.g. the previous scene, still queued in the
+// channel) and events already covered by a snapshot. Ephemeral events have no sequence
+func (ss *syncSession) send(msg *sync.SyncResponse) error {
+	topic := msg.GetTopic()
+	if topic != ss.tableTopic && topic != ss.sceneTopic && topic != ss.userTopic {
+		return nil
+	}
+	if msg.GetSequence() == 0 {
+		return ss.stream.Send(msg)
+	}
+	if msg.GetSequence() <= ss.skipUntil[topic] {
+		return nil
+	}
+
+	if err := ss.stream.Send(msg); err != nil {
+		return err
+	}
+	ss.lastSent[topic] = msg.GetSequence()
+	return nil
+}
+
+// sendSnapshot sends the state at the current sequences of the topics. The events up to them were published,
+// and usually queued for the session, before the state was read, so they are in the snapshot and are skipped
+func (ss *syncSession) sendSnapshot() error {
+	s := ss.server
+
+	tableSequence := s.Broker.Sequence(pubSubSyncConst.TableSync, ss.tableID)
+	sceneSequence := s.Broker.Sequence(pubSubSyncConst.SceneSync, ss.sceneID)
+	if err := s.sendSnapshot(ss.stream, ss.tableID, ss.sceneID, ss.sub, tableSequence, sceneSequence); err != nil {
+		return err
+	}
+
+	ss.skipUntil[ss.tableTopic] = tableSequence
+	ss.lastSent[ss.tableTopic] = tableSequence
+	ss.skipUntil[ss.sceneTopic] = sceneSequence
+	ss.lastSent[ss.sceneTopic] = sceneSequence
+	return nil
+}
+
+// resyncLostTopics sends a ResyncRequired for each topic that dropped events, then a snapshot
+func (ss *syncSession) resyncLostTopics() error {
+	s := ss.server
+
+	var lostTopics []string
+	for _, topic := range ss.sub.TakeLostTopics() {
+		if topic == ss.tableTopic || topic == ss.sceneTopic {
+			lostTopics = append(lostTopics, topic)
+		}
+	}
+	if len(lostTopics) == 0 {
+		return nil
+	}
+	s.Logger.WarningF("user %d lost events of %v, %d events dropped so far", ss.sub.UserID, lostTopics, ss.sub.Dropped())
+
+	tableSequence := s.Broker.Sequence(pubSubSyncConst.TableSync, ss.tableID)
+	sceneSequence := s.Broker.Sequence(pubSubSyncConst.SceneSync, ss.sceneID)
+	current := map[string]uint64{
+		ss.tableTopic: tableSequence,
+		ss.sceneTopic: sceneSequence,
+	}
+
+	for _, topic := range lostTopics {
+		if err := ss.stream.Send(events.NewResyncRequiredEvent(ss.tableID, ss.sceneID, topic, ss.lastSent[topic], current[topic])); err != nil {
+			s.Logger.ErrorF("error to send resync of %s: %v", topic, err)
+			return err
+		}
+	}
+	if err := s.sendSnapshot(ss.stream, ss.tableID, ss.sceneID, ss.sub, tableSequence, sceneSequence); err != nil {
+		return err
+	}
+
+	for topic, sequence := range current {
+		ss.skipUntil[topic] = sequence
+		ss.lastSent[topic] = sequence
+	}
+	return nil
+}
+
+// switchScene moves the session to the scene of a new SyncRequest. The scene was already
+// checked against the table by the stream interceptor when the request was received, players
+// are still kept out of hidden scenes
+func (ss *syncSession) switchScene(req *sync.SyncRequest) error {
+	s := ss.server
+
+	// the interceptor only checks the scene when the table is sent, and the table is fixed per session
+	if req.GetTableId() != ss.tableID {
+		s.Logger.ErrorF("user %d tried to change table %d to %d inside a sync session", ss.sub.UserID, ss.tableID, req.GetTableId())
+		return status.Error(codes.InvalidArgument, "table_id cannot change inside a sync session, open a new stream")
+	}
+
+	if err := s.checkSceneVisible(ss.stream.Context(), req.GetSceneId(), ss.sub); err != nil {
+		return err
+	}
+
+	previousSceneID := ss.sceneID
+	s.Logger.InfoF("user %d switching from scene %d to scene %d", ss.sub.UserID, previousSceneID, req.GetSceneId())
+
+	s.Broker.UnsubscribeToTopic(pubSubSyncConst.SceneSync, previousSceneID, ss.sub)
+	replay := s.Broker.SubscribeToTopicFrom(pubSubSyncConst.SceneSync, req.GetSceneId(), ss.sub, 0)
+
+	// from here on, events of the previous scene still queued are dropped by send
+	ss.sceneID = req.GetSceneId()
+	ss.sceneTopic = replay.Topic
+
+	if previous, changed := s.Presence.changeScene(ss, ss.sceneID); changed {
+		s.Broker.Publish(pubSubSyncConst.TableSync, ss.tableID, events.NewUserChangedSceneEvent(ss.tableID, uint64(ss.sub.UserID), uint64(ss.sub.TableUserID), previous, ss.sceneID))
+	}
+
+	// like sendSnapshot, the events already in the snapshot are skipped
+	sceneSequence := s.Broker.Sequence(pubSubSyncConst.SceneSync, ss.sceneID)
+	snapshot, err := s.buildSceneSnapshot(ss.stream.Context(), ss.tableID, ss.sceneID, ss.sub, sceneSequence)
+	if err != nil {
+		return err
+	}
+	ss.skipUntil[ss.sceneTopic] = sceneSequence
+	ss.lastSent[ss.sceneTopic] = sceneSequence
+	if err := ss.stream.Send(events.NewSceneSwitchedEvent(ss.tableID, ss.sceneID, previousSceneID, snapshot)); err != nil {
+		s.Logger.ErrorF("error to send scene switch of user %d: %v", ss.sub.UserID, err)
+		return err
+	}
+	return nil
+}
+
+// serve runs the session until the client leaves, the stream fails or the client is idle for
+// longer than the idle timeout. Any message from the client resets the idle timeout
+func (ss *syncSession) serve() error {
+	s := ss.server
+	ctx := ss.stream.Context()
+	userID := ss.sub.UserID
+
+	// The following requests are read in their own goroutine, the loop below is the only one
+	// sending on the stream and changing the subscriptions, so it works as the session lock
+	requests := make(chan *sync.SyncRequest)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			req, err := ss.stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case requests <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// a nil channel never fires, so disabled timers just leave their case out of the select
+	var heartbeats <-chan time.Time
+	if s.HeartbeatInterval > 0 {
+		ticker := time.NewTicker(s.HeartbeatInterval)
+		defer ticker.Stop()
+		heartbeats = ticker.C
+	}
+
+	var memberChecks <-chan time.Time
+	if s.MemberCheckInterval > 0 {
+		ticker := time.NewTicker(s.MemberCheckInterval)
+		defer ticker.Stop()
+		memberChecks = ticker.C
+	}
+
+	var idle *time.Timer
+	var idleTimeout <-chan time.Time
+	if s.IdleTimeout > 0 {
+		idle = time.NewTimer(s.IdleTimeout)
+		defer idle.Stop()
+		idleTimeout = idle.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.Logger.InfoF("client disconnected from table %v", ss.tableID)
+			return ctx.Err()
+		case err := <-recvErr:
+			if err != io.EOF {
+				s.Logger.ErrorF("error to receive syncRequest from user %d: %v", userID, err)
+				return err
+			}
+			// the client closed its side, events keep flowing until the stream ends
+			s.Logger.InfoF("user %d will not send more syncRequests", userID)
+			recvErr = nil
+		case req := <-requests:
+			if idle != nil {
+				resetTimer(idle, s.IdleTimeout)
+			}
+			if req.GetHeartbeat() {
+				continue
+			}
+			if err := ss.switchScene(req); err != nil {
+				return err
+			}
+		case <-idleTimeout:
+			s.Logger.WarningF("user %d sent nothing to table %d for %v, closing the idle session", userID, ss.tableID, s.IdleTimeout)
+			return status.Error(codes.DeadlineExceeded, "session idle for too long, send heartbeats to keep it open")
+		case <-memberChecks:
+			// a member removed from the table loses the session
+			if err := utils.CheckUserIsTableMember(ctx, s.DB, uint(ss.tableID)); err != nil {
+				s.Logger.WarningF("closing the session of user %d in table %d: %v", userID, ss.tableID, err)
+				return err
+			}
+		case <-heartbeats:
+			if err := ss.stream.Send(events.NewHeartbeatEvent(ss.tableID, ss.sceneID, s.IdleTimeout)); err != nil {
+				s.Logger.ErrorF("error to send heartbeat to user %d: %v", userID, err)
+				return err
+			}
+		case <-ss.sub.Done():
+			s.Logger.WarningF("user %d disconnected from table %d for being too slow, %d events dropped", userID, ss.tableID, ss.sub.Dropped())
+			return status.Error(codes.ResourceExhausted, "client is too slow, reconnect with the last sequence seen")
+		case <-ss.sub.Lost():
+			if err := ss.resyncLostTopics(); err != nil {
+				return err
+			}
+		case msg := <-ss.sub.Ch:
+			if err := ss.send(msg); err != nil {
+				s.Logger.ErrorF("error to send message from client scene: %d %v", ss.sceneID, err)
+				return err
+			}
+		}
+	}
+}
+
+// close removes the subscriptions of the session from the broker
+func (ss *syncSession) close() {
+	s := ss.server
+
+	// the scene can change during the session, ss.sceneID is the current one
+	s.Logger.InfoF("Cleaning up subscriptions for clients from table: %v and scene: %v", ss.tableID, ss.sceneID)
+	s.Broker.UnsubscribeToTopic(pubSubSyncConst.TableSync, ss.tableID, ss.sub)
+	s.Broker.UnsubscribeToTopic(pubSubSyncConst.SceneSync, ss.sceneID, ss.sub)
+	s.Broker.UnsubscribeToTopic(pubSubSyncConst.UserSync, uint64(ss.sub.UserID), ss.sub)
+}
+
+// resetTimer restarts a timer that may have fired without being read
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
@@ -0,0 +1,188 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	pbSync "github.com/GarotoCowboy/vttProject/api/grpc/pb/sync"
+	"github.com/GarotoCowboy/vttProject/api/grpc/service/sync/broker"
+	"github.com/GarotoCowboy/vttProject/api/models/consts"
+	"github.com/GarotoCowboy/vttProject/api/models/consts/pubSubSyncConst"
+	"github.com/GarotoCowboy/vttProject/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	testTableID = 1
+	testSceneID = 2
+)
+
+// fakeStream is the server side of a Sync stream, requests are what the client sends
+type fakeStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	requests chan *pbSync.SyncRequest
+
+	mu   sync.Mutex
+	sent []*pbSync.SyncResponse
+}
+
+func (f *fakeStream) Context() context.Context {
+	return f.ctx
+}
+
+func (f *fakeStream) Recv() (*pbSync.SyncRequest, error) {
+	select {
+	case req, ok := <-f.requests:
+		if !ok {
+			return nil, io.EOF
+		}
+		return req, nil
+	case <-f.ctx.Done():
+		return nil, f.ctx.Err()
+	}
+}
+
+func (f *fakeStream) Send(msg *pbSync.SyncResponse) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+func (f *fakeStream) heartbeats() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	count := 0
+	for _, msg := range f.sent {
+		if msg.GetHeartbeat() != nil {
+			count++
+		}
+	}
+	return count
+}
+
+// startSession subscribes a session like Sync does and serves it in a goroutine,
+// the returned channel receives the error of serve after the session was closed
+func startSession(ctx context.Context, server *SyncServer) (*fakeStream, <-chan error) {
+	stream := &fakeStream{ctx: ctx, requests: make(chan *pbSync.SyncRequest)}
+	session := &syncSession{
+		server:    server,
+		stream:    stream,
+		sub:       broker.NewSubscriber(1, 1, consts.Player, 100),
+		tableID:   testTableID,
+		sceneID:   testSceneID,
+		lastSent:  make(map[string]uint64),
+		skipUntil: make(map[string]uint64),
+	}
+	session.tableTopic = server.Broker.SubscribeToTopicFrom(pubSubSyncConst.TableSync, testTableID, session.sub, 0).Topic
+	session.sceneTopic = server.Broker.SubscribeToTopicFrom(pubSubSyncConst.SceneSync, testSceneID, session.sub, 0).Topic
+
+	done := make(chan error, 1)
+	go func() {
+		defer session.close()
+		done <- session.serve()
+	}()
+	return stream, done
+}
+
+func newTestServer(t *testing.T, heartbeatInterval, idleTimeout time.Duration) (*SyncServer, *broker.MemoryBroker) {
+	b := broker.NewMemoryBroker(nil, broker.BackpressurePolicy{Mode: broker.DropOldest})
+	t.Cleanup(b.Close)
+	return &SyncServer{
+		Broker:            b,
+		Logger:            config.GetLogger("test"),
+		Presence:          NewPresence(),
+		HeartbeatInterval: heartbeatInterval,
+		IdleTimeout:       idleTimeout,
+	}, b
+}
+
+func waitSession(t *testing.T, done <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("session did not end")
+		return nil
+	}
+}
+
+func assertUnsubscribed(t *testing.T, b *broker.MemoryBroker) {
+	t.Helper()
+	if count := b.SubscriberCount(pubSubSyncConst.TableSync, testTableID); count != 0 {
+		t.Errorf("table topic still has %d subscribers", count)
+	}
+	if count := b.SubscriberCount(pubSubSyncConst.SceneSync, testSceneID); count != 0 {
+		t.Errorf("scene topic still has %d subscribers", count)
+	}
+}
+
+func TestIdleSessionIsClosedAndUnsubscribed(t *testing.T) {
+	server, b := newTestServer(t, 0, 50*time.Millisecond)
+
+	_, done := startSession(context.Background(), server)
+	if count := b.SubscriberCount(pubSubSyncConst.TableSync, testTableID); count != 1 {
+		t.Fatalf("table topic has %d subscribers, want 1", count)
+	}
+
+	err := waitSession(t, done)
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("idle session ended with %v, want DeadlineExceeded", err)
+	}
+	assertUnsubscribed(t, b)
+}
+
+func TestClientHeartbeatsKeepSessionOpen(t *testing.T) {
+	server, b := newTestServer(t, 0, 100*time.Millisecond)
+
+	stream, done := startSession(context.Background(), server)
+
+	// heartbeats for three idle timeouts
+	for i := 0; i < 15; i++ {
+		stream.requests <- &pbSync.SyncRequest{Heartbeat: true}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	select {
+	case err := <-done:
+		t.Fatalf("session with heartbeats ended with %v", err)
+	default:
+	}
+
+	// without heartbeats the session is closed
+	err := waitSession(t, done)
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("idle session ended with %v, want DeadlineExceeded", err)
+	}
+	assertUnsubscribed(t, b)
+}
+
+func TestServerSendsHeartbeatsAndUnsubscribesOnDisconnect(t *testing.T) {
+	server, b := newTestServer(t, 10*time.Millisecond, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, done := startSession(ctx, server)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for stream.heartbeats() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("received %d heartbeats, want 3", stream.heartbeats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// the client vanishes, e.g. the transport was closed by keepalive
+	cancel()
+	if err := waitSession(t, done); !errors.Is(err, context.Canceled) {
+		t.Fatalf("disconnected session ended with %v, want context.Canceled", err)
+	}
+	assertUnsubscribed(t, b)
+}
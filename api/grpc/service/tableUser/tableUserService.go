@@ -11,10 +11,10 @@ type TableUserService struct {
 	tableUser.UnimplementedTableUserServiceServer
 	DB     *gorm.DB
 	Logger *config.Logger
-	Broker *broker.Broker
+	Broker broker.Broker
 }
 
-func NewTableUserService(db *gorm.DB, logger *config.Logger, broker *broker.Broker) *TableUserService {
+func NewTableUserService(db *gorm.DB, logger *config.Logger, broker broker.Broker) *TableUserService {
 	return &TableUserService{
 		DB:     db,
 		Logger: logger,
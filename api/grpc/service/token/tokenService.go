@@ -11,10 +11,10 @@ type TokenService struct {
 	token.UnimplementedTokenServiceServer
 	DB     *gorm.DB
 	Logger *config.Logger
-	Broker *broker.Broker
+	Broker broker.Broker
 }
 
-func NewTokenService(db *gorm.DB, logger *config.Logger, broker *broker.Broker) *TokenService {
+func NewTokenService(db *gorm.DB, logger *config.Logger, broker broker.Broker) *TokenService {
 	return &TokenService{
 		DB:     db,
 		Logger: logger,
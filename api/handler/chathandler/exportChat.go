@@ -0,0 +1,108 @@
+package chathandler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/GarotoCowboy/vttProject/api/dto/chatDTO"
+	"github.com/GarotoCowboy/vttProject/api/handler"
+	"github.com/GarotoCowboy/vttProject/api/service/chatExport"
+	"github.com/gin-gonic/gin"
+)
+
+// @BasePath /api/v1
+
+// ExportChatHandler
+// @Summary Export chat
+// @Schemes
+// @Description Downloads the chat of a table as HTML, Markdown or JSON. Private messages are only included for their participants and deleted messages are left out
+// @Tags Chat
+// @Produce html
+// @Produce json
+// @Param tableID path int true "Table ID"
+// @Param format query string false "html (default), md or json"
+// @Param from query string false "Only messages sent at or after this time (RFC 3339)"
+// @Param to query string false "Only messages sent before this time (RFC 3339)"
+// @Param tz query string false "IANA time zone of the times in the export, e.g. America/Sao_Paulo (default UTC)"
+// @Success 200 {file} file "The chat log"
+// @Failure 400 {object} chatDTO.ErrorResponse "Invalid parameters"
+// @Failure 403 {object} chatDTO.ErrorResponse "User is not a member of the table"
+// @Failure 500 {object} chatDTO.ErrorResponse "Internal server error"
+// @Router /tables/{tableID}/chat/export [get]
+func ExportChatHandler(ctx *gin.Context) {
+
+	userIDValue, exists := ctx.Get("user_id")
+	if !exists {
+		handler.SendError(ctx, http.StatusUnauthorized, "user_id not found in context")
+		return
+	}
+
+	userID, ok := userIDValue.(uint)
+	if !ok {
+		handler.SendError(ctx, http.StatusUnauthorized, "invalid user_id type in context")
+		return
+	}
+
+	tableID, err := strconv.ParseUint(ctx.Param("tableID"), 10, 64)
+	if err != nil || tableID == 0 {
+		handler.SendError(ctx, http.StatusBadRequest, "tableID must be a positive integer")
+		return
+	}
+
+	request := chatDTO.ExportChatRequest{
+		TableID: uint(tableID),
+		Format:  chatDTO.ExportFormat(ctx.DefaultQuery("format", string(chatDTO.ExportHTML))),
+	}
+	if request.From, err = parseTimeQuery(ctx, "from"); err != nil {
+		handler.SendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	if request.To, err = parseTimeQuery(ctx, "to"); err != nil {
+		handler.SendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	if tz := ctx.Query("tz"); tz != "" {
+		if request.Location, err = time.LoadLocation(tz); err != nil {
+			handler.SendError(ctx, http.StatusBadRequest, "tz must be an IANA time zone")
+			return
+		}
+	}
+
+	export, err := chatExport.NewChatExport(handler.GetHandlerDB(), request, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, chatExport.ErrNotMember):
+			handler.SendError(ctx, http.StatusForbidden, err.Error())
+		case errors.Is(err, chatExport.ErrInvalidExport):
+			handler.SendError(ctx, http.StatusBadRequest, err.Error())
+		default:
+			handler.GetHandlerLogger().ErrorF("error exporting chat of table %d: %v", tableID, err)
+			handler.SendError(ctx, http.StatusInternalServerError, "chat export error")
+		}
+		return
+	}
+
+	ctx.Header("Content-Type", export.ContentType())
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", export.FileName()))
+	ctx.Status(http.StatusOK)
+
+	// the status was already sent, a failure can only cut the download short
+	if err := export.WriteTo(ctx.Request.Context(), ctx.Writer); err != nil {
+		handler.GetHandlerLogger().ErrorF("error streaming chat export of table %d: %v", tableID, err)
+	}
+}
+
+func parseTimeQuery(ctx *gin.Context, name string) (*time.Time, error) {
+	value := ctx.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a RFC 3339 time, e.g. 2026-10-18T20:00:00-03:00", name)
+	}
+	return &t, nil
+}
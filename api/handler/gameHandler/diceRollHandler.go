@@ -1,6 +1,7 @@
 package gameHandler
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -46,9 +47,14 @@ func RollDiceHandler(ctx *gin.Context) {
 		return
 	}
 
+	if request.Expression != "" {
+		rollExpression(ctx, request.Expression, uint(tableID), userID)
+		return
+	}
+
 	roll, err := dice.Roll(request.NumDices, request.Sides, request.Bonuses, uint(tableID), userID, handler.GetHandlerDB())
 	if err != nil {
-		handler.SendError(ctx, http.StatusUnauthorized, err.Error())
+		sendDiceError(ctx, err)
 		return
 	}
 
@@ -61,8 +67,53 @@ func RollDiceHandler(ctx *gin.Context) {
 		SumOfRolls: roll.SumOfRolls,
 		Total:      roll.Total,
 		UserName:   userData.Username,
+		Proof:      roll.Proof,
 	}
 
 	handler.SendSucess(ctx, "roll dice", resp)
 
 }
+
+func rollExpression(ctx *gin.Context, expression string, tableID, userID uint) {
+	parsed, err := dice.Parse(expression)
+	if err != nil {
+		handler.SendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	result, err := dice.RollExpression(parsed, tableID, userID, false, handler.GetHandlerDB())
+	if err != nil {
+		sendDiceError(ctx, err)
+		return
+	}
+
+	userData, _ := user.GetUser(handler.GetHandlerDB(), userID)
+
+	resp := gameDTO.RollResultResponse{
+		Total:      result.Total,
+		UserName:   userData.Username,
+		Expression: result.Expression,
+		Breakdown:  result.Breakdown,
+		Terms:      result.Terms,
+		Proof:      result.Proof,
+	}
+
+	handler.SendSucess(ctx, "roll dice", resp)
+}
+
+// sendDiceError answers with the status of an error of the dice service
+func sendDiceError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, dice.ErrNotMember), errors.Is(err, dice.ErrNotMaster):
+		handler.SendError(ctx, http.StatusForbidden, err.Error())
+	case errors.Is(err, dice.ErrRollNotFound):
+		handler.SendError(ctx, http.StatusNotFound, err.Error())
+	case errors.Is(err, dice.ErrSeedNotRevealed):
+		handler.SendError(ctx, http.StatusConflict, err.Error())
+	case errors.Is(err, dice.ErrInvalidRoll), errors.Is(err, dice.ErrLimitExceeded):
+		handler.SendError(ctx, http.StatusBadRequest, err.Error())
+	default:
+		handler.GetHandlerLogger().ErrorF("error rolling dice: %v", err)
+		handler.SendError(ctx, http.StatusInternalServerError, "dice service error")
+	}
+}
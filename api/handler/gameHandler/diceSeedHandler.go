@@ -0,0 +1,131 @@
+package gameHandler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/GarotoCowboy/vttProject/api/dto/gameDTO"
+	"github.com/GarotoCowboy/vttProject/api/handler"
+	"github.com/GarotoCowboy/vttProject/api/service/gameService/dice"
+	"github.com/gin-gonic/gin"
+)
+
+// @BasePath /api/v1
+
+// GetDiceSeedHandler
+// @Summary Get the dice seed commitment
+// @Schemes
+// @Description Returns the SHA-256 commitment of the seed the next rolls of the table are drawn from. The seed itself stays secret until a master reveals it
+// @Tags Dice
+// @Produce json
+// @Param tableID path int true "Table ID"
+// @Success 200 {object} gameDTO.DiceSeedResponse
+// @Failure 400 {object} gameDTO.ErrorResponse "Invalid table ID"
+// @Failure 403 {object} gameDTO.ErrorResponse "User is not a member of the table"
+// @Router /tables/{tableID}/dice/seed [get]
+func GetDiceSeedHandler(ctx *gin.Context) {
+	userID, tableID, ok := tableRequest(ctx)
+	if !ok {
+		return
+	}
+
+	seed, err := dice.CurrentSeed(tableID, userID, handler.GetHandlerDB())
+	if err != nil {
+		sendDiceError(ctx, err)
+		return
+	}
+
+	handler.SendSucess(ctx, "get dice seed", gameDTO.NewDiceSeedResponse(seed))
+}
+
+// RevealDiceSeedHandler
+// @Summary Reveal the dice seed
+// @Schemes
+// @Description Reveals the seed of the table so every roll drawn from it can be verified, and commits the seed of the next rolls. Only masters can reveal, e.g. at the end of a session
+// @Tags Dice
+// @Produce json
+// @Param tableID path int true "Table ID"
+// @Success 200 {object} gameDTO.RevealDiceSeedResponse
+// @Failure 400 {object} gameDTO.ErrorResponse "Invalid table ID"
+// @Failure 403 {object} gameDTO.ErrorResponse "User is not a master of the table"
+// @Router /tables/{tableID}/dice/seed/reveal [post]
+func RevealDiceSeedHandler(ctx *gin.Context) {
+	userID, tableID, ok := tableRequest(ctx)
+	if !ok {
+		return
+	}
+
+	revealed, next, err := dice.RevealSeed(tableID, userID, handler.GetHandlerDB())
+	if err != nil {
+		sendDiceError(ctx, err)
+		return
+	}
+	handler.GetHandlerLogger().InfoF("dice seed %d of table %d revealed by user %d", revealed.ID, tableID, userID)
+
+	handler.SendSucess(ctx, "reveal dice seed", gameDTO.RevealDiceSeedResponse{
+		Revealed: gameDTO.NewDiceSeedResponse(revealed),
+		Next:     gameDTO.NewDiceSeedResponse(next),
+	})
+}
+
+// VerifyRollHandler
+// @Summary Verify a roll
+// @Schemes
+// @Description Recomputes a roll from its revealed seed: dice are drawn from HMAC-SHA256(seed, nonce || counter) and the SHA-256 of the seed must be the commitment published before the roll. Rolls to the masters can only be verified by the masters and who rolled them
+// @Tags Dice
+// @Produce json
+// @Param rollID path int true "Roll ID"
+// @Success 200 {object} dice.Verification
+// @Failure 400 {object} gameDTO.ErrorResponse "Invalid roll ID"
+// @Failure 404 {object} gameDTO.ErrorResponse "Roll not found"
+// @Failure 409 {object} gameDTO.ErrorResponse "The seed of the roll is not revealed yet"
+// @Router /dice/rolls/{rollID}/verify [get]
+func VerifyRollHandler(ctx *gin.Context) {
+	userID, ok := contextUserID(ctx)
+	if !ok {
+		return
+	}
+
+	rollID, err := strconv.ParseUint(ctx.Param("rollID"), 10, 64)
+	if err != nil || rollID == 0 {
+		handler.SendError(ctx, http.StatusBadRequest, "rollID must be a positive integer")
+		return
+	}
+
+	verification, err := dice.VerifyRoll(uint(rollID), userID, handler.GetHandlerDB())
+	if err != nil {
+		sendDiceError(ctx, err)
+		return
+	}
+
+	handler.SendSucess(ctx, "verify roll", verification)
+}
+
+// tableRequest reads the user of the context and the tableID path parameter, answering the error otherwise
+func tableRequest(ctx *gin.Context) (userID, tableID uint, ok bool) {
+	if userID, ok = contextUserID(ctx); !ok {
+		return 0, 0, false
+	}
+
+	id, err := strconv.ParseUint(ctx.Param("tableID"), 10, 64)
+	if err != nil || id == 0 {
+		handler.SendError(ctx, http.StatusBadRequest, "tableID must be a positive integer")
+		return 0, 0, false
+	}
+	return userID, uint(id), true
+}
+
+func contextUserID(ctx *gin.Context) (uint, bool) {
+	userIDValue, exists := ctx.Get("user_id")
+	if !exists {
+		handler.SendError(ctx, http.StatusUnauthorized, "user_id not found in context")
+		return 0, false
+	}
+
+	userID, ok := userIDValue.(uint)
+	if !ok {
+		handler.SendError(ctx, http.StatusUnauthorized, "invalid user_id type in context")
+		return 0, false
+	}
+	return userID, true
+}
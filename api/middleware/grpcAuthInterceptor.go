@@ -19,24 +19,43 @@ func GrpcAuthInterceptor(
 	handler grpc.UnaryHandler,
 ) (interface{}, error) {
 
+	tokenString, err := tokenFromMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, err := userIDFromToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	newCtx := context.WithValue(ctx, "user_id", userID)
+	return handler(newCtx, req)
+}
+
+// tokenFromMetadata extracts the bearer token from the "authorization" metadata header
+func tokenFromMetadata(ctx context.Context) (string, error) {
 	md, ok := metadata.FromIncomingContext(ctx)
 
 	if !ok {
-		return nil, status.Errorf(codes.Unauthenticated, "missing metadata")
+		return "", status.Errorf(codes.Unauthenticated, "missing metadata")
 	}
 
 	authHeaders := md.Get("authorization")
 	if len(authHeaders) == 0 {
-		return nil, status.Errorf(codes.Unauthenticated, "missing auth header")
+		return "", status.Errorf(codes.Unauthenticated, "missing auth header")
 	}
 	authHeader := authHeaders[0]
 
 	tokenParts := strings.Split(authHeader, " ")
 	if len(tokenParts) != 2 || strings.ToLower(tokenParts[0]) != "bearer" {
-		return nil, status.Errorf(codes.Unauthenticated, "invalid auth header")
+		return "", status.Errorf(codes.Unauthenticated, "invalid auth header")
 	}
-	tokenString := tokenParts[1]
+	return tokenParts[1], nil
+}
 
+// userIDFromToken validates the JWT and returns the user_id claim
+func userIDFromToken(tokenString string) (uint, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, status.Errorf(codes.Unauthenticated, "unexpected signing method")
@@ -44,13 +63,12 @@ func GrpcAuthInterceptor(
 		return config.JWT_SECRET, nil
 	})
 	if err != nil {
-		return nil, status.Errorf(codes.Unauthenticated, "invalid token or expirated: %v", err)
+		return 0, status.Errorf(codes.Unauthenticated, "invalid token or expirated: %v", err)
 	}
 	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
 		if userIDFloat, ok := claims["user_id"].(float64); ok {
-			newCtx := context.WithValue(ctx, "user_id", uint(userIDFloat))
-			return handler(newCtx, req)
+			return uint(userIDFloat), nil
 		}
 	}
-	return nil, status.Errorf(codes.Unauthenticated, "invalid token")
+	return 0, status.Errorf(codes.Unauthenticated, "invalid token")
 }
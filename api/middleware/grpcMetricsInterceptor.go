@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	grpcRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vtt",
+		Subsystem: "grpc",
+		Name:      "requests_total",
+		Help:      "Finished gRPC calls, by method, type (unary or stream) and status code.",
+	}, []string{"method", "type", "code"})
+
+	grpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "vtt",
+		Subsystem: "grpc",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of gRPC calls, by method and type. Streams are measured from open to close.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300, 1800, 3600},
+	}, []string{"method", "type"})
+
+	grpcActiveStreams = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "vtt",
+		Subsystem: "grpc",
+		Name:      "active_streams",
+		Help:      "Streams open on this instance, by method.",
+	}, []string{"method"})
+)
+
+// GrpcMetricsInterceptor records the duration and status of unary calls, it comes first in
+// the chain so calls rejected by the auth interceptors are counted too
+func GrpcMetricsInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	observeGrpcCall(info.FullMethod, "unary", start, err)
+	return resp, err
+}
+
+// GrpcStreamMetricsInterceptor records the duration and status of streams and how many are open
+func GrpcStreamMetricsInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	grpcActiveStreams.WithLabelValues(info.FullMethod).Inc()
+	defer grpcActiveStreams.WithLabelValues(info.FullMethod).Dec()
+
+	err := handler(srv, ss)
+	observeGrpcCall(info.FullMethod, "stream", start, err)
+	return err
+}
+
+func observeGrpcCall(method, callType string, start time.Time, err error) {
+	grpcRequests.WithLabelValues(method, callType, status.Code(err).String()).Inc()
+	grpcDuration.WithLabelValues(method, callType).Observe(time.Since(start).Seconds())
+}
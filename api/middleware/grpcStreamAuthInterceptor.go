@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/GarotoCowboy/vttProject/api/grpc/interfaces"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// reflectionService is left open so tools like grpcurl keep working
+const reflectionService = "/grpc.reflection."
+
+// authStream wraps a server stream and authenticates it with the JWT sent as metadata or,
+// when there is no metadata, with the auth_token of the first message received
+type authStream struct {
+	grpc.ServerStream
+
+	mu            sync.RWMutex
+	ctx           context.Context
+	authenticated bool
+}
+
+func (s *authStream) Context() context.Context {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ctx
+}
+
+func (s *authStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.authenticated {
+		return nil
+	}
+
+	tokenReq, ok := m.(interfaces.AuthTokenRequest)
+	if !ok || tokenReq.GetAuthToken() == "" {
+		return status.Errorf(codes.Unauthenticated, "missing auth token")
+	}
+
+	userID, err := userIDFromToken(strings.TrimPrefix(tokenReq.GetAuthToken(), "Bearer "))
+	if err != nil {
+		return err
+	}
+
+	s.ctx = context.WithValue(s.ctx, "user_id", userID)
+	s.authenticated = true
+	return nil
+}
+
+func GrpcStreamAuthInterceptor(
+	srv interface{},
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+
+	if strings.HasPrefix(info.FullMethod, reflectionService) {
+		return handler(srv, ss)
+	}
+
+	stream := &authStream{
+		ServerStream: ss,
+		ctx:          ss.Context(),
+	}
+
+	// Metadata has priority, the first message is only checked when it is missing
+	if tokenString, err := tokenFromMetadata(ss.Context()); err == nil {
+		userID, err := userIDFromToken(tokenString)
+		if err != nil {
+			return err
+		}
+		stream.ctx = context.WithValue(stream.ctx, "user_id", userID)
+		stream.authenticated = true
+	}
+
+	return handler(srv, stream)
+}
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/GarotoCowboy/vttProject/api/grpc/interfaces"
+	"github.com/GarotoCowboy/vttProject/api/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// tableMemberTTL is how long a membership checked by a stream is trusted, a member removed from the
+// table is refused by the next message received after it
+const tableMemberTTL = 30 * time.Second
+
+// tableMemberStream checks every received message that targets a table (and a scene)
+// before the handler can act on it, e.g. before the Sync handler subscribes to a topic
+type tableMemberStream struct {
+	grpc.ServerStream
+
+	db *gorm.DB
+
+	// when each table was last authorized, so long-lived streams don't hit the database on every message.
+	// The table of a scene never changes, scenes are cached for the whole stream
+	tables map[uint64]time.Time
+	scenes map[uint64]uint64
+}
+
+func (s *tableMemberStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	tableReq, ok := m.(interfaces.TableRequest)
+	if !ok || tableReq.GetTableId() == 0 {
+		return nil
+	}
+	tableID := tableReq.GetTableId()
+
+	if checkedAt, ok := s.tables[tableID]; !ok || time.Since(checkedAt) >= tableMemberTTL {
+		userID, ok := s.Context().Value("user_id").(uint)
+		if !ok {
+			return status.Error(codes.Internal, "tableUser id is not in context")
+		}
+
+		var tableUser models.TableUser
+		if err := s.db.WithContext(s.Context()).Where("user_id = ? AND table_id = ?", userID, tableID).First(&tableUser).Error; err != nil {
+			delete(s.tables, tableID)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return status.Errorf(codes.PermissionDenied, "tableUser does not have permission to access table %d", tableID)
+			}
+			return status.Errorf(codes.Internal, "error checking table permissions")
+		}
+		s.tables[tableID] = time.Now()
+	}
+
+	sceneReq, ok := m.(interfaces.SceneRequest)
+	if !ok || sceneReq.GetSceneId() == 0 {
+		return nil
+	}
+	sceneID := sceneReq.GetSceneId()
+
+	if owner, ok := s.scenes[sceneID]; ok {
+		if owner != tableID {
+			return status.Errorf(codes.NotFound, "scene %d not found in table %d", sceneID, tableID)
+		}
+		return nil
+	}
+
+	var sceneModel models.Scene
+	if err := s.db.WithContext(s.Context()).Select("id", "table_id").Where("id = ?", sceneID).First(&sceneModel).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return status.Errorf(codes.NotFound, "scene %d not found", sceneID)
+		}
+		return status.Errorf(codes.Internal, "error checking scene permissions")
+	}
+	s.scenes[sceneID] = uint64(sceneModel.TableID)
+
+	if uint64(sceneModel.TableID) != tableID {
+		return status.Errorf(codes.NotFound, "scene %d not found in table %d", sceneID, tableID)
+	}
+	return nil
+}
+
+func GrpcStreamTableMemberInterceptor(db *gorm.DB) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		if strings.HasPrefix(info.FullMethod, reflectionService) {
+			return handler(srv, ss)
+		}
+
+		return handler(srv, &tableMemberStream{
+			ServerStream: ss,
+			db:           db,
+			tables:       make(map[uint64]time.Time),
+			scenes:       make(map[uint64]uint64),
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/GarotoCowboy/vttProject/api/handler"
+	"github.com/gin-gonic/gin"
+)
+
+// InternalOnly only lets through requests made from loopback or private network addresses, e.g. the
+// Prometheus scraper. The address of the connection is checked, never the forwarding headers, and
+// proxied requests are refused: behind a reverse proxy every public request comes from an internal address
+func InternalOnly() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if ctx.GetHeader("X-Forwarded-For") != "" || ctx.GetHeader("X-Real-IP") != "" || ctx.GetHeader("Forwarded") != "" {
+			handler.SendError(ctx, http.StatusForbidden, "endpoint only available inside the network")
+			ctx.Abort()
+			return
+		}
+
+		host, _, err := net.SplitHostPort(ctx.Request.RemoteAddr)
+		ip := net.ParseIP(host)
+		if err != nil || ip == nil || !(ip.IsLoopback() || ip.IsPrivate()) {
+			handler.SendError(ctx, http.StatusForbidden, "endpoint only available inside the network")
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
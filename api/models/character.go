@@ -12,6 +12,7 @@ type Character struct {
 	TableUser TableUser `gorm:"foreignKey:TableUserID"`
 	PlayerName string `json:"player_name,omitempty"`
 	Name string `json:"character_name" gorm:"not null"`
+	PortraitURL *string `json:"portrait_url"`
 	SystemKey consts.SystemKey `json:"system_key" gorm:"not null"`
 	SheetData json.RawMessage `json:"sheet_data" gorm:"type:jsonb"`
 }
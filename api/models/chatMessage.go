@@ -9,6 +9,10 @@ import (
 	"gorm.io/gorm"
 )
 
+// ChatMessageSearchVector is the full text expression of the chat search, games are in pt-BR.
+// The GIN index of the search is built on this exact expression so the queries must use it as is
+const ChatMessageSearchVector = "to_tsvector('portuguese', message)"
+
 type ChatMessage struct {
 	ID        uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
 	CreatedAt time.Time
@@ -21,9 +25,12 @@ type ChatMessage struct {
 	TableUser   TableUser `gorm:"constraint:OnDelete:CASCADE"`
 	TableUserID uint      `gorm:"not null;index"`
 
-	//private message
-	ToTableUserId *uint      `gorm:"index"`
-	ToTableUser   *TableUser `gorm:"constraint:OnDelete:SET NULL"`
+	//private message, only the sender and the recipients can read it
+	Recipients []ChatMessageRecipient `gorm:"constraint:OnDelete:CASCADE"`
+
+	// previous texts of the message, oldest first
+	Revisions     []ChatMessageRevision `gorm:"constraint:OnDelete:CASCADE"`
+	RevisionCount int32                 `json:"revision_count" gorm:"not null;default:0"`
 
 	Message          string               `json:"message" gorm:"not null"`
 	MediaURL         *string              `json:"media_url"`
@@ -31,4 +38,37 @@ type ChatMessage struct {
 	ReplyToMessageId *string              `gorm:"index"`
 	MessageType      consts.MessageType   `json:"messageType" gorm:"not null"`
 	MessageStatus    consts.MessageStatus `json:"messageStatus" gorm:"not null"`
+
+	// Channel separates the in-character lines from the table talk and the GM announcements
+	Channel consts.ChatChannel `json:"channel" gorm:"not null;default:0;index"`
+	// the character the sender spoke as, only in the in-character channel
+	CharacterID *uint      `json:"character_id" gorm:"index"`
+	Character   *Character `gorm:"constraint:OnDelete:SET NULL"`
+
+	// moderation, set when a master deleted the message of someone else
+	DeletedByTableUserID *uint
+	DeletionReason       *string
+
+	// chat commands
+	// ToMasters the recipients were the masters of the table when it was sent (whisper to GM, /gmroll)
+	ToMasters bool           `json:"to_masters" gorm:"not null;default:false"`
+	Emote     bool           `json:"emote" gorm:"not null;default:false"`
+	Roll      datatypes.JSON `json:"roll" gorm:"type:jsonb"`
+}
+
+// ChatMessageRecipient is a recipient of a private message
+type ChatMessageRecipient struct {
+	ChatMessageID uuid.UUID `gorm:"primaryKey;type:uuid"`
+	TableUserID   uint      `gorm:"primaryKey;index"`
+	TableUser     TableUser `gorm:"constraint:OnDelete:CASCADE"`
+}
+
+// ChatMessageRevision is a text the message had before an edit
+type ChatMessageRevision struct {
+	ID            uint      `gorm:"primaryKey"`
+	ChatMessageID uuid.UUID `gorm:"not null;type:uuid;index"`
+	Message       string    `gorm:"not null"`
+	// WrittenAt is when the text was sent or last edited, CreatedAt is when the edit replaced it
+	WrittenAt time.Time `gorm:"not null"`
+	CreatedAt time.Time
 }
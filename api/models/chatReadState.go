@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// ChatReadState holds how far a table user has received and read the chat of their table.
+// The cursors are the sent time of the newest message delivered or read, every older message shares its status
+type ChatReadState struct {
+	ID uint `gorm:"primaryKey"`
+
+	TableUserID uint      `gorm:"not null;uniqueIndex"`
+	TableUser   TableUser `gorm:"constraint:OnDelete:CASCADE"`
+	TableID     uint      `gorm:"not null;index"`
+
+	DeliveredUpTo time.Time
+	ReadUpTo      time.Time
+	UpdatedAt     time.Time
+}
@@ -0,0 +1,9 @@
+package consts
+
+type ChatChannel uint8
+
+const (
+	OutOfCharacter ChatChannel = iota
+	InCharacter
+	GameMaster
+)
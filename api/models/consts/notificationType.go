@@ -0,0 +1,7 @@
+package consts
+
+type NotificationType uint8
+
+const (
+	Mention NotificationType = iota
+)
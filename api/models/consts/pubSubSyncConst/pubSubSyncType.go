@@ -6,4 +6,6 @@ const (
 	None = iota
 	TableSync
 	SceneSync
+	// UserSync is the topic of a single user, e.g. their notifications, delivered on every table they are connected to
+	UserSync
 )
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// DiceSeed is the secret the rolls of a table are drawn from. While it is in use only its Commitment,
+// the SHA-256 of the seed, is public; once a master reveals it anyone can recompute the rolls drawn from it.
+// A table has at most one seed that is not revealed, the next roll after a reveal commits a new one
+type DiceSeed struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+
+	TableID uint  `gorm:"not null;index"`
+	Table   Table `gorm:"constraint:OnDelete:CASCADE"`
+
+	// Seed is hex encoded and must not leave the server before RevealedAt
+	Seed       string `json:"-" gorm:"not null"`
+	Commitment string `gorm:"not null;uniqueIndex"`
+	// NextNonce is the nonce of the next roll, each roll of a seed has its own
+	NextNonce  uint64 `gorm:"not null;default:0"`
+	RevealedAt *time.Time
+}
+
+// DiceRoll is a roll made by the server, kept so it can be verified once its seed is revealed
+type DiceRoll struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+
+	TableID uint  `gorm:"not null;index"`
+	Table   Table `gorm:"constraint:OnDelete:CASCADE"`
+	UserID  uint  `gorm:"not null;index"`
+	User    User  `gorm:"constraint:OnDelete:CASCADE"`
+
+	SeedID uint     `gorm:"not null;uniqueIndex:idx_dice_rolls_seed_nonce"`
+	Seed   DiceSeed `gorm:"constraint:OnDelete:CASCADE"`
+	Nonce  uint64   `gorm:"not null;uniqueIndex:idx_dice_rolls_seed_nonce"`
+
+	// Expression in its canonical form, e.g. "4d6kh3+2"
+	Expression string `gorm:"not null"`
+	// Result is the dice.Result of the roll
+	Result datatypes.JSON `gorm:"type:jsonb;not null"`
+	Total  int            `gorm:"not null"`
+	// ToMasters rolls (/gmroll) can only be verified by the masters and who rolled them
+	ToMasters bool `gorm:"not null;default:false"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"github.com/GarotoCowboy/vttProject/api/models/consts"
+	"github.com/google/uuid"
+)
+
+// Notification is an entry of the inbox of a user, e.g. a mention in the chat of one of their tables
+type Notification struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+
+	UserID uint `gorm:"not null;index"`
+	User   User `gorm:"constraint:OnDelete:CASCADE"`
+
+	TableID uint  `gorm:"not null;index"`
+	Table   Table `gorm:"constraint:OnDelete:CASCADE"`
+
+	Type consts.NotificationType `gorm:"not null"`
+
+	// the message that mentioned the user, the notification is hidden while the message is deleted
+	ChatMessageID *uuid.UUID   `gorm:"type:uuid;index"`
+	ChatMessage   *ChatMessage `gorm:"constraint:OnDelete:CASCADE"`
+
+	// FromTableUserID is who caused the notification, e.g. the author of the mention
+	FromTableUserID uint      `gorm:"not null"`
+	FromTableUser   TableUser `gorm:"constraint:OnDelete:CASCADE"`
+
+	Preview string
+	ReadAt  *time.Time
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// SyncTopicSequence is the last sequence of a sync topic, shared by all server instances
+type SyncTopicSequence struct {
+	Topic    string `gorm:"primaryKey"`
+	Sequence uint64 `gorm:"not null;default:0"`
+}
+
+// SyncEventPayload holds events too large for a NOTIFY payload, the notification only carries the ID
+type SyncEventPayload struct {
+	ID        uint64    `gorm:"primaryKey"`
+	Payload   []byte    `gorm:"not null"`
+	CreatedAt time.Time `gorm:"index"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// SyncPresence is a table member with Sync streams open on one server instance. Each instance refreshes
+// LastSeen of its rows, the rows of an instance that stopped doing it are ignored and later removed
+type SyncPresence struct {
+	InstanceID  string `gorm:"primaryKey"`
+	TableID     uint   `gorm:"primaryKey;index"`
+	UserID      uint   `gorm:"primaryKey"`
+	TableUserID uint   `gorm:"not null"`
+	Username    string `gorm:"not null"`
+	SceneID     uint64
+	// Connections is how many streams the member has open on the instance
+	Connections uint32    `gorm:"not null"`
+	ConnectedAt time.Time `gorm:"not null"`
+	// SceneChangedAt is the time of the last scene change, the member is on the scene of the instance it changed last
+	SceneChangedAt time.Time `gorm:"not null"`
+	LastSeen       time.Time `gorm:"not null;index"`
+}
@@ -17,5 +17,9 @@ type Table struct {
 	Password      string `json:"password"`
 	ActiveSceneID *uint  `json:"active_scene_id"`
 	ActiveScene   Scene  `json:"active_scene"gorm:"foreignKey:ActiveSceneID;references:ID;constraint:OnUpdate:SET NULL,OnDelete:SET NULL;"`
+	// MastersReadWhispers lets masters receive and list the private messages between players
+	MastersReadWhispers bool `json:"masters_read_whispers" gorm:"not null;default:false"`
+	// ChatSlowModeSeconds is the minimum time between two messages of a player, 0 disables the slow mode
+	ChatSlowModeSeconds int32 `json:"chat_slow_mode_seconds" gorm:"not null;default:0"`
 	//ActionLog []string `json:"actionLog"`
 }
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// TableEvent is a sync event kept in the append-only history of a table. Rows are never
+// updated nor deleted, the audience of the event is saved as it was when it was published
+type TableEvent struct {
+	ID       uint64 `gorm:"primaryKey"`
+	TableID  uint   `gorm:"not null;index:idx_table_events_table_time,priority:1"`
+	SceneID  uint   `gorm:"index"`
+	Topic    string `gorm:"not null"`
+	Sequence uint64 `gorm:"not null"`
+	// Action is the name of the SyncResponse action, e.g. "placed_token_moved"
+	Action string `gorm:"not null"`
+	// Payload is the SyncResponse encoded with protobuf
+	Payload []byte `gorm:"not null"`
+
+	// Public events can be read by every member, the others by the listed viewers and by masters when Masters is set,
+	// e.g. whispers are only read by masters when the table allows it
+	Public             bool           `gorm:"not null;default:false"`
+	Masters            bool           `gorm:"not null;default:false"`
+	ViewerUserIDs      datatypes.JSON `gorm:"type:jsonb"`
+	ViewerTableUserIDs datatypes.JSON `gorm:"type:jsonb"`
+
+	CreatedAt time.Time `gorm:"not null;index:idx_table_events_table_time,priority:2"`
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"time"
+
 	"github.com/GarotoCowboy/vttProject/api/models/consts"
 	"gorm.io/gorm"
 )
@@ -15,6 +17,9 @@ type TableUser struct {
 
 	Role consts.Role
 
+	// ChatMutedUntil the member cannot send chat messages before this time
+	ChatMutedUntil *time.Time
+
 	Table Table `gorm:"constraint:OnDelete:CASCADE"`
 	User  User  `gorm:"constraint:OnDelete:CASCADE"`
 }
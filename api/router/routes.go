@@ -3,6 +3,7 @@ package router
 import (
 	"github.com/GarotoCowboy/vttProject/api/handler"
 	"github.com/GarotoCowboy/vttProject/api/handler/authhandler"
+	"github.com/GarotoCowboy/vttProject/api/handler/chathandler"
 	"github.com/GarotoCowboy/vttProject/api/handler/gameHandler"
 	"github.com/GarotoCowboy/vttProject/api/handler/tablehandler"
 	"github.com/GarotoCowboy/vttProject/api/handler/tableuserhandler"
@@ -11,6 +12,7 @@ import (
 	"github.com/GarotoCowboy/vttProject/api/middleware"
 	_ "github.com/GarotoCowboy/vttProject/docs"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
@@ -57,6 +59,12 @@ func initializeRoutes(router *gin.Engine) {
 
 			//gameService
 			authenticated.POST("/tables/:tableID/roll", gameHandler.RollDiceHandler)
+			authenticated.GET("/tables/:tableID/dice/seed", gameHandler.GetDiceSeedHandler)
+			authenticated.POST("/tables/:tableID/dice/seed/reveal", gameHandler.RevealDiceSeedHandler)
+			authenticated.GET("/dice/rolls/:rollID/verify", gameHandler.VerifyRollHandler)
+
+			//chat
+			authenticated.GET("/tables/:tableID/chat/export", chathandler.ExportChatHandler)
 
 			//v1.POST("/table/character",characterhandler.CreateCharacterHandler)
 		}
@@ -67,4 +75,7 @@ func initializeRoutes(router *gin.Engine) {
 
 	//Swagger
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+
+	//Prometheus metrics of the broker, gRPC calls and database queries, only for internal scrapers
+	router.GET("/metrics", middleware.InternalOnly(), gin.WrapH(promhttp.Handler()))
 }
@@ -0,0 +1,246 @@
+package chatExport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/GarotoCowboy/vttProject/api/dto/chatDTO"
+	"github.com/GarotoCowboy/vttProject/api/models"
+	"github.com/GarotoCowboy/vttProject/api/models/consts"
+	"github.com/GarotoCowboy/vttProject/api/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// messages loaded per query, the export is flushed after each batch
+const exportBatchSize = 500
+
+var (
+	// ErrInvalidExport is returned for parameters the export cannot use, e.g. an unknown format
+	ErrInvalidExport = errors.New("invalid chat export")
+	ErrNotMember     = errors.New("tableUser is not a member of this table")
+)
+
+// ChatExport is an authorized export of the chat of a table. It is checked before anything is written
+// so the handler can still answer with an error, then streamed in batches
+type ChatExport struct {
+	db        *gorm.DB
+	req       chatDTO.ExportChatRequest
+	tableUser models.TableUser
+	renderer  renderer
+}
+
+// exportedMessage is a message as written in the export
+type exportedMessage struct {
+	ID          string        `json:"id"`
+	SentAt      time.Time     `json:"sent_at"`
+	Edited      bool          `json:"edited"`
+	Sender      string        `json:"sender"`
+	Character   string        `json:"character,omitempty"`
+	Channel     string        `json:"channel"`
+	AvatarURL   string        `json:"avatar_url,omitempty"`
+	Type        string        `json:"type"`
+	Text        string        `json:"text"`
+	Emote       bool          `json:"emote,omitempty"`
+	MediaURL    string        `json:"media_url,omitempty"`
+	Attachments []string      `json:"attachments,omitempty"`
+	Recipients  []string      `json:"recipients,omitempty"`
+	ToMasters   bool          `json:"to_masters,omitempty"`
+	Roll        *exportedRoll `json:"roll,omitempty"`
+}
+
+type exportedRoll struct {
+	Expression string `json:"expression"`
+	NumDice    int    `json:"num_dice"`
+	Sides      int    `json:"sides"`
+	Rolls      []int  `json:"rolls"`
+	Bonuses    []int  `json:"bonuses"`
+	Total      int    `json:"total"`
+	Breakdown  string `json:"breakdown,omitempty"`
+}
+
+// savedRoll is the roll column of the chat commands, written by protojson
+type savedRoll struct {
+	Expression string `json:"expression"`
+	NumDice    int    `json:"numDice"`
+	Sides      int    `json:"sides"`
+	Rolls      []int  `json:"rolls"`
+	Bonuses    []int  `json:"bonuses"`
+	Total      int    `json:"total"`
+	Breakdown  string `json:"breakdown,omitempty"`
+}
+
+type exportInfo struct {
+	TableID    uint       `json:"table_id"`
+	TableName  string     `json:"table_name"`
+	From       *time.Time `json:"from,omitempty"`
+	To         *time.Time `json:"to,omitempty"`
+	ExportedAt time.Time  `json:"exported_at"`
+}
+
+func NewChatExport(db *gorm.DB, req chatDTO.ExportChatRequest, userID uint) (*ChatExport, error) {
+	if err := req.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidExport, err)
+	}
+	if req.Location == nil {
+		req.Location = time.UTC
+	}
+
+	var tableUser models.TableUser
+	if err := db.Preload("Table").Where("table_id = ? AND user_id = ?", req.TableID, userID).First(&tableUser).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotMember
+		}
+		return nil, err
+	}
+
+	export := &ChatExport{db: db, req: req, tableUser: tableUser}
+	switch req.Format {
+	case chatDTO.ExportHTML:
+		export.renderer = &htmlRenderer{}
+	case chatDTO.ExportMarkdown:
+		export.renderer = &markdownRenderer{}
+	case chatDTO.ExportJSON:
+		export.renderer = &jsonRenderer{}
+	}
+	return export, nil
+}
+
+func (e *ChatExport) ContentType() string {
+	return e.renderer.contentType()
+}
+
+// FileName is the name of the downloaded file, e.g. chat-table-3-2026-10-18.html
+func (e *ChatExport) FileName() string {
+	return fmt.Sprintf("chat-table-%d-%s.%s", e.req.TableID, time.Now().In(e.req.Location).Format("2006-01-02"), e.req.Format)
+}
+
+// WriteTo streams the messages the member can read, oldest first. Deleted messages are left out
+func (e *ChatExport) WriteTo(ctx context.Context, w io.Writer) error {
+	info := exportInfo{
+		TableID:    e.tableUser.TableID,
+		TableName:  e.tableUser.Table.Name,
+		From:       e.req.From,
+		To:         e.req.To,
+		ExportedAt: time.Now().In(e.req.Location),
+	}
+	if err := e.renderer.header(w, info); err != nil {
+		return err
+	}
+
+	var lastCreatedAt time.Time
+	var lastID uuid.UUID
+	first := true
+	for {
+		query := e.db.WithContext(ctx).
+			Preload("TableUser.User").
+			Preload("Recipients.TableUser.User").
+			Preload("Character", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).
+			Where("table_id = ?", e.tableUser.TableID).
+			Scopes(utils.VisibleChatMessages(e.tableUser)).
+			Order("created_at, id").
+			Limit(exportBatchSize)
+		if e.req.From != nil {
+			query = query.Where("created_at >= ?", *e.req.From)
+		}
+		if e.req.To != nil {
+			query = query.Where("created_at < ?", *e.req.To)
+		}
+		// keyset pagination, messages sent at the same time are ordered by id
+		if !first {
+			query = query.Where("(created_at, id) > (?, ?)", lastCreatedAt, lastID)
+		}
+
+		var messages []models.ChatMessage
+		if err := query.Find(&messages).Error; err != nil {
+			return err
+		}
+
+		for _, msg := range messages {
+			if err := e.renderer.message(w, e.exportMessage(msg)); err != nil {
+				return err
+			}
+		}
+		if flusher, ok := w.(interface{ Flush() }); ok {
+			flusher.Flush()
+		}
+
+		if len(messages) < exportBatchSize {
+			break
+		}
+		last := messages[len(messages)-1]
+		lastCreatedAt, lastID, first = last.CreatedAt, last.ID, false
+	}
+
+	return e.renderer.footer(w)
+}
+
+func (e *ChatExport) exportMessage(msg models.ChatMessage) exportedMessage {
+	exported := exportedMessage{
+		ID:        msg.ID.String(),
+		SentAt:    msg.CreatedAt.In(e.req.Location),
+		Edited:    msg.UpdatedAt.After(msg.CreatedAt),
+		Sender:    msg.TableUser.User.Username,
+		AvatarURL: msg.TableUser.User.ImageLink,
+		Type:      messageTypeName(msg.MessageType),
+		Text:      msg.Message,
+		Channel:   channelName(msg.Channel),
+		Emote:     msg.Emote,
+		ToMasters: msg.ToMasters,
+	}
+	if msg.Character != nil {
+		exported.Character = msg.Character.Name
+	}
+	if msg.MediaURL != nil {
+		exported.MediaURL = *msg.MediaURL
+	}
+	if msg.Attachments != nil {
+		// a broken column only loses the attachments, not the message
+		_ = json.Unmarshal(msg.Attachments, &exported.Attachments)
+	}
+	for _, recipient := range msg.Recipients {
+		exported.Recipients = append(exported.Recipients, recipient.TableUser.User.Username)
+	}
+	if msg.Roll != nil {
+		var roll savedRoll
+		if err := json.Unmarshal(msg.Roll, &roll); err == nil {
+			converted := exportedRoll(roll)
+			exported.Roll = &converted
+		}
+	}
+	return exported
+}
+
+func channelName(channel consts.ChatChannel) string {
+	switch channel {
+	case consts.OutOfCharacter:
+		return "ooc"
+	case consts.InCharacter:
+		return "ic"
+	case consts.GameMaster:
+		return "gm"
+	}
+	return "unknown"
+}
+
+func messageTypeName(messageType consts.MessageType) string {
+	switch messageType {
+	case consts.TEXT:
+		return "text"
+	case consts.IMAGE:
+		return "image"
+	case consts.VIDEO:
+		return "video"
+	case consts.AUDIO:
+		return "audio"
+	case consts.DOCUMENT:
+		return "document"
+	case consts.SYSTEM:
+		return "system"
+	}
+	return "unknown"
+}
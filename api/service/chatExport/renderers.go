@@ -0,0 +1,275 @@
+package chatExport
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// renderer writes one format of the export, message is called once per message in order
+type renderer interface {
+	contentType() string
+	header(w io.Writer, info exportInfo) error
+	message(w io.Writer, msg exportedMessage) error
+	footer(w io.Writer) error
+}
+
+// describeDice is the result of a roll, e.g. "1d20[13]+5 = 18", or "[13] +5 = 18" for rolls saved without a breakdown
+func describeDice(roll *exportedRoll) string {
+	if roll.Breakdown != "" {
+		return fmt.Sprintf("%s = %d", roll.Breakdown, roll.Total)
+	}
+
+	rolls := make([]string, len(roll.Rolls))
+	for i, r := range roll.Rolls {
+		rolls[i] = strconv.Itoa(r)
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "[%s]", strings.Join(rolls, ", "))
+	for _, bonus := range roll.Bonuses {
+		fmt.Fprintf(&text, " %+d", bonus)
+	}
+	fmt.Fprintf(&text, " = %d", roll.Total)
+	return text.String()
+}
+
+// speaker is the name shown for the message, the character with the player when spoken in character
+func speaker(msg exportedMessage) string {
+	if msg.Character != "" {
+		return msg.Character + " (" + msg.Sender + ")"
+	}
+	return msg.Sender
+}
+
+// audience is who could read a private message, empty for public messages
+func audience(msg exportedMessage) string {
+	switch {
+	case msg.ToMasters:
+		return "to the masters"
+	case len(msg.Recipients) > 0:
+		return "whisper to " + strings.Join(msg.Recipients, ", ")
+	}
+	return ""
+}
+
+// --- HTML ---
+
+// The page has no external stylesheet, script or image, avatars are only shown when they are data URLs
+var htmlTemplates = template.Must(template.New("export").Funcs(template.FuncMap{
+	"avatar":   safeAvatarURL,
+	"initial":  initial,
+	"dice":     describeDice,
+	"audience": audience,
+	"speaker":  speaker,
+}).Parse(`{{define "header"}}<!DOCTYPE html>
+<html lang="pt-BR">
+<head>
+<meta charset="utf-8">
+<title>{{.TableName}} - chat</title>
+<style>
+body{font-family:system-ui,sans-serif;background:#1e1f24;color:#e8e6e3;max-width:860px;margin:0 auto;padding:24px}
+h1{font-size:1.4em}h2{font-size:1em;color:#9aa0a6;border-bottom:1px solid #33353c;padding-bottom:4px;margin-top:28px}
+.meta{color:#9aa0a6;font-size:.85em}
+.msg{display:flex;gap:12px;padding:8px 0}
+.avatar{width:36px;height:36px;border-radius:50%;flex:none;object-fit:cover;background:#3b3f4a;color:#fff;display:flex;align-items:center;justify-content:center;font-weight:bold}
+.sender{font-weight:bold}.time{color:#9aa0a6;font-size:.8em;margin-left:6px}
+.text{white-space:pre-wrap;word-wrap:break-word}
+.emote .text{font-style:italic}.gm .text{color:#f0c674}
+.private{border-left:3px solid #8e6bd8;padding-left:9px}.tag{color:#b99cf0;font-size:.8em;margin-left:6px}
+.roll{background:#2a2d35;border-radius:6px;padding:6px 10px;display:inline-block}.total{font-size:1.2em;font-weight:bold;color:#f0c674}
+</style>
+</head>
+<body>
+<h1>{{.TableName}}</h1>
+<p class="meta">Exported at {{.ExportedAt.Format "2006-01-02 15:04 MST"}}{{if .From}} · from {{.From.Format "2006-01-02 15:04"}}{{end}}{{if .To}} · to {{.To.Format "2006-01-02 15:04"}}{{end}}</p>
+{{end}}
+
+{{define "day"}}<h2>{{.}}</h2>
+{{end}}
+
+{{define "message"}}<div class="msg{{if .Emote}} emote{{end}}{{if eq .Channel "gm"}} gm{{end}}{{if audience .}} private{{end}}">
+{{with avatar .AvatarURL}}<img class="avatar" src="{{.}}" alt="">{{else}}<div class="avatar">{{initial .Sender}}</div>{{end}}
+<div>
+<div><span class="sender">{{speaker .}}</span><span class="time">{{.SentAt.Format "15:04"}}{{if .Edited}} (edited){{end}}</span>{{with audience .}}<span class="tag">{{.}}</span>{{end}}</div>
+{{if .Roll}}<div class="roll">🎲 {{.Roll.Expression}}: {{dice .Roll}} <span class="total">{{.Roll.Total}}</span></div>
+{{else if .Emote}}<div class="text">* {{speaker .}} {{.Text}}</div>
+{{else}}{{if .Text}}<div class="text">{{.Text}}</div>{{end}}{{end}}
+{{with .MediaURL}}<div><a href="{{.}}">{{.}}</a></div>{{end}}
+{{range .Attachments}}<div><a href="{{.}}">{{.}}</a></div>{{end}}
+</div>
+</div>
+{{end}}`))
+
+// maxEmbeddedAvatar bounds each avatar embedded in the page, they are repeated on every message
+const maxEmbeddedAvatar = 64 << 10
+
+// safeAvatarURL keeps the avatars that are small data image URLs. Links are dropped, the page must work
+// offline and opening it must not tell other hosts who reads it, so those members get their initial
+func safeAvatarURL(link string) template.URL {
+	if len(link) > maxEmbeddedAvatar {
+		return ""
+	}
+	if strings.HasPrefix(link, "data:image/png;base64,") || strings.HasPrefix(link, "data:image/jpeg;base64,") {
+		return template.URL(link)
+	}
+	return ""
+}
+
+func initial(username string) string {
+	for _, r := range username {
+		return strings.ToUpper(string(r))
+	}
+	return "?"
+}
+
+type htmlRenderer struct {
+	day string
+}
+
+func (r *htmlRenderer) contentType() string {
+	return "text/html; charset=utf-8"
+}
+
+func (r *htmlRenderer) header(w io.Writer, info exportInfo) error {
+	return htmlTemplates.ExecuteTemplate(w, "header", info)
+}
+
+func (r *htmlRenderer) message(w io.Writer, msg exportedMessage) error {
+	if day := msg.SentAt.Format("2006-01-02"); day != r.day {
+		r.day = day
+		if err := htmlTemplates.ExecuteTemplate(w, "day", day); err != nil {
+			return err
+		}
+	}
+	return htmlTemplates.ExecuteTemplate(w, "message", msg)
+}
+
+func (r *htmlRenderer) footer(w io.Writer) error {
+	_, err := io.WriteString(w, "</body>\n</html>\n")
+	return err
+}
+
+// --- Markdown ---
+
+type markdownRenderer struct {
+	day string
+}
+
+func (r *markdownRenderer) contentType() string {
+	return "text/markdown; charset=utf-8"
+}
+
+func (r *markdownRenderer) header(w io.Writer, info exportInfo) error {
+	_, err := fmt.Fprintf(w, "# %s\n\n_Exported at %s_\n", escapeMarkdown(info.TableName), info.ExportedAt.Format("2006-01-02 15:04 MST"))
+	return err
+}
+
+func (r *markdownRenderer) message(w io.Writer, msg exportedMessage) error {
+	if day := msg.SentAt.Format("2006-01-02"); day != r.day {
+		r.day = day
+		if _, err := fmt.Fprintf(w, "\n## %s\n\n", day); err != nil {
+			return err
+		}
+	}
+
+	// what the users wrote is escaped, the markup below is the only Markdown of the line
+	msg.Sender = escapeMarkdown(msg.Sender)
+	msg.Character = escapeMarkdown(msg.Character)
+	msg.Text = escapeMarkdown(msg.Text)
+	recipients := make([]string, len(msg.Recipients))
+	for i, recipient := range msg.Recipients {
+		recipients[i] = escapeMarkdown(recipient)
+	}
+	msg.Recipients = recipients
+
+	var line strings.Builder
+	fmt.Fprintf(&line, "- `%s` ", msg.SentAt.Format("15:04"))
+	if tag := audience(msg); tag != "" {
+		fmt.Fprintf(&line, "_(%s)_ ", tag)
+	}
+	switch {
+	case msg.Roll != nil:
+		fmt.Fprintf(&line, "🎲 **%s** rolled `%s`: %s", msg.Sender, msg.Roll.Expression, escapeMarkdown(describeDice(msg.Roll)))
+	case msg.Emote:
+		fmt.Fprintf(&line, "_\\* %s %s_", speaker(msg), msg.Text)
+	default:
+		fmt.Fprintf(&line, "**%s**: %s", speaker(msg), msg.Text)
+	}
+	if msg.Edited {
+		line.WriteString(" _(edited)_")
+	}
+	if msg.MediaURL != "" {
+		fmt.Fprintf(&line, " <%s>", markdownLinkEscaper.Replace(msg.MediaURL))
+	}
+	for _, attachment := range msg.Attachments {
+		fmt.Fprintf(&line, " <%s>", markdownLinkEscaper.Replace(attachment))
+	}
+	// a line break inside the text must not end the list item
+	_, err := io.WriteString(w, strings.ReplaceAll(line.String(), "\n", "  \n  ")+"\n")
+	return err
+}
+
+func (r *markdownRenderer) footer(w io.Writer) error {
+	return nil
+}
+
+// markdownEscaper puts a backslash before the characters of Markdown and HTML, so a text is shown as written
+var markdownEscaper = func() *strings.Replacer {
+	var pairs []string
+	for _, c := range "\\`*_{}[]()<>#+-.!|~&" {
+		pairs = append(pairs, string(c), "\\"+string(c))
+	}
+	return strings.NewReplacer(pairs...)
+}()
+
+// markdownLinkEscaper percent-encodes what would end an autolink, e.g. <https://a b>
+var markdownLinkEscaper = strings.NewReplacer(" ", "%20", "<", "%3C", ">", "%3E", "\n", "%0A")
+
+func escapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+// --- JSON ---
+
+// jsonRenderer writes {"table_id":..., "messages":[...]} one message at a time
+type jsonRenderer struct {
+	count int
+}
+
+func (r *jsonRenderer) contentType() string {
+	return "application/json; charset=utf-8"
+}
+
+func (r *jsonRenderer) header(w io.Writer, info exportInfo) error {
+	header, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	// reopen the object to append the messages
+	_, err = fmt.Fprintf(w, "%s,\"messages\":[", header[:len(header)-1])
+	return err
+}
+
+func (r *jsonRenderer) message(w io.Writer, msg exportedMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if r.count > 0 {
+		if _, err := io.WriteString(w, ",\n"); err != nil {
+			return err
+		}
+	}
+	r.count++
+	_, err = w.Write(data)
+	return err
+}
+
+func (r *jsonRenderer) footer(w io.Writer) error {
+	_, err := io.WriteString(w, "]}\n")
+	return err
+}
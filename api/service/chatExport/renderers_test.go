@@ -0,0 +1,59 @@
+package chatExport
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarkdownEscapesWhatUsersWrote(t *testing.T) {
+	msg := exportedMessage{
+		SentAt:     time.Date(2026, 10, 18, 20, 0, 0, 0, time.UTC),
+		Sender:     "**gm**",
+		Text:       "<script>alert(1)</script> [click](https://evil) _hi_",
+		Recipients: []string{"a_b"},
+		MediaURL:   "https://img> [x](y)",
+	}
+
+	var out strings.Builder
+	if err := (&markdownRenderer{}).message(&out, msg); err != nil {
+		t.Fatalf("message returned error: %v", err)
+	}
+
+	want := "- `20:00` _(whisper to a\\_b)_ **\\*\\*gm\\*\\***: " +
+		"\\<script\\>alert\\(1\\)\\</script\\> \\[click\\]\\(https://evil\\) \\_hi\\_ <https://img%3E%20[x](y)>\n"
+	if got := strings.TrimPrefix(out.String(), "\n## 2026-10-18\n\n"); got != want {
+		t.Errorf("message =\n%q\nwant\n%q", got, want)
+	}
+	if msg.Recipients[0] != "a_b" {
+		t.Errorf("the recipients of the message were changed to %v", msg.Recipients)
+	}
+}
+
+func TestHTMLOnlyEmbedsDataAvatars(t *testing.T) {
+	embedded := "data:image/png;base64,iVBORw0KGgo="
+	for avatar, wantImage := range map[string]bool{
+		embedded:                             true,
+		"https://tracker.example/me.png":     false,
+		"data:image/svg+xml;base64,PHN2Zz4=": false,
+		"data:image/png;base64," + strings.Repeat("A", maxEmbeddedAvatar): false,
+	} {
+		msg := exportedMessage{
+			SentAt:    time.Date(2026, 10, 18, 20, 0, 0, 0, time.UTC),
+			Sender:    "pedro",
+			AvatarURL: avatar,
+		}
+
+		var out strings.Builder
+		if err := (&htmlRenderer{}).message(&out, msg); err != nil {
+			t.Fatalf("message returned error: %v", err)
+		}
+
+		if got := strings.Contains(out.String(), "<img"); got != wantImage {
+			t.Errorf("avatar %.40q: image shown %v, want %v", avatar, got, wantImage)
+		}
+		if !wantImage && !strings.Contains(out.String(), `<div class="avatar">P</div>`) {
+			t.Errorf("avatar %.40q: the initial is not shown", avatar)
+		}
+	}
+}
@@ -1,11 +1,13 @@
 package dice
 
 import (
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/GarotoCowboy/vttProject/api/models"
-	"github.com/GarotoCowboy/vttProject/api/utils"
 	"gorm.io/gorm"
 )
 
@@ -15,44 +17,144 @@ type RollResult struct {
 	SumOfRolls int   `json:"sum_of_rolls"`
 	SumOfBonus int   `json:"sum_of_bonus"`
 	Total      int   `json:"total"`
+	Proof
+}
+
+// Proof identifies a persisted roll: the commitment of the seed it was drawn from and its nonce.
+// Once the seed is revealed VerifyRoll recomputes the roll
+type Proof struct {
+	RollID     uint   `json:"roll_id"`
+	Commitment string `json:"commitment"`
+	Nonce      uint64 `json:"nonce"`
+}
+
+// FairRoll is an evaluated expression and the proof of the roll
+type FairRoll struct {
+	*Result
+	Proof
 }
 
 func Roll(numDice, sides int, bonuses []int, tableID, userID uint, db *gorm.DB) (*RollResult, error) {
 
-	var membership models.TableUser
-	if err := db.Where("table_id = ? AND user_id = ?", tableID, userID).First(&membership).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("tableUser is not a member of this table")
-		}
+	if err := checkMembership(tableID, userID, db); err != nil {
 		return nil, err
 	}
 
 	if numDice <= 0 {
-		return nil, fmt.Errorf("numDice must be positive")
+		return nil, fmt.Errorf("%w: numDice must be positive", ErrInvalidRoll)
 	}
 
 	if sides <= 1 {
-		return nil, fmt.Errorf("sides must be greater than 1")
-	}
-
-	rolls := make([]int, numDice)
-	sumOfRolls := 0
-	for i := 0; i < numDice; i++ {
-		roll := utils.SeededRand.Intn(sides) + 1
-		rolls[i] = roll
-		sumOfRolls += roll
+		return nil, fmt.Errorf("%w: sides must be greater than 1", ErrInvalidRoll)
 	}
 
+	// the dice are rolled as an expression, "NdS+B1-B2...", so they are drawn and recorded like any other roll
+	var expr strings.Builder
+	fmt.Fprintf(&expr, "%dd%d", numDice, sides)
 	sumOfBonus := 0
 	for _, bonus := range bonuses {
+		fmt.Fprintf(&expr, "%+d", bonus)
 		sumOfBonus += bonus
 	}
+	expression, err := Parse(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRoll, err)
+	}
+
+	roll, err := record(expression, tableID, userID, false, db)
+	if err != nil {
+		return nil, err
+	}
+
+	rolls := make([]int, numDice)
+	for i, die := range roll.Terms[0].Dice {
+		rolls[i] = die.Value
+	}
 	result := &RollResult{
 		Rolls:      rolls,
 		Bonuses:    bonuses,
-		SumOfRolls: sumOfRolls,
+		SumOfRolls: roll.Terms[0].Value,
 		SumOfBonus: sumOfBonus,
-		Total:      sumOfRolls + sumOfBonus,
+		Total:      roll.Total,
+		Proof:      roll.Proof,
 	}
 	return result, nil
 }
+
+// RollExpression rolls a parsed expression for a member of the table with the current seed of the table and
+// records the roll. toMasters hides the roll from the players other than userID, e.g. a /gmroll
+func RollExpression(expression *Expression, tableID, userID uint, toMasters bool, db *gorm.DB) (*FairRoll, error) {
+
+	if err := checkMembership(tableID, userID, db); err != nil {
+		return nil, err
+	}
+
+	return record(expression, tableID, userID, toMasters, db)
+}
+
+// record draws the roll from the next nonce of the current seed of the table and saves it
+func record(expression *Expression, tableID, userID uint, toMasters bool, db *gorm.DB) (*FairRoll, error) {
+	var roll *FairRoll
+	err := db.Transaction(func(tx *gorm.DB) error {
+		seed, err := currentSeed(tx, tableID)
+		if err != nil {
+			return err
+		}
+		secret, err := hex.DecodeString(seed.Seed)
+		if err != nil {
+			return fmt.Errorf("invalid seed %d: %w", seed.ID, err)
+		}
+
+		result, err := expression.Evaluate(SeedRoller(secret, seed.NextNonce))
+		if err != nil {
+			return err
+		}
+		resultJSON, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+
+		saved := models.DiceRoll{
+			TableID:    tableID,
+			UserID:     userID,
+			SeedID:     seed.ID,
+			Nonce:      seed.NextNonce,
+			Expression: result.Expression,
+			Result:     resultJSON,
+			Total:      result.Total,
+			ToMasters:  toMasters,
+		}
+		if err := tx.Create(&saved).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(seed).Update("next_nonce", seed.NextNonce+1).Error; err != nil {
+			return err
+		}
+
+		roll = &FairRoll{
+			Result: result,
+			Proof:  Proof{RollID: saved.ID, Commitment: seed.Commitment, Nonce: saved.Nonce},
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return roll, nil
+}
+
+func checkMembership(tableID, userID uint, db *gorm.DB) error {
+	_, err := findMember(tableID, userID, db)
+	return err
+}
+
+func findMember(tableID, userID uint, db *gorm.DB) (*models.TableUser, error) {
+	var membership models.TableUser
+	if err := db.Where("table_id = ? AND user_id = ?", tableID, userID).First(&membership).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotMember
+		}
+		return nil, err
+	}
+	return &membership, nil
+}
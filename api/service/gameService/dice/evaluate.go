@@ -0,0 +1,256 @@
+package dice
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Result is an evaluated expression with every die that was rolled
+type Result struct {
+	// Expression in its canonical form, e.g. "4d6kh3+2"
+	Expression string `json:"expression"`
+	// Terms are the dice and groups of the expression, in the order they were rolled
+	Terms []Term `json:"terms"`
+	// Breakdown is the expression with the dice replaced by their values, e.g. "4d6kh3[6, 5, 4, ~1~]+2".
+	// Dice that do not count are between ~, exploded dice end with ! and successes with *
+	Breakdown string `json:"breakdown"`
+	Total     int    `json:"total"`
+}
+
+// Term is a dice term ("4d6kh3") or a group ("{1d8, 1d6}kh1") of the expression
+type Term struct {
+	Expression string `json:"expression"`
+	// Sides of the dice, 0 for groups
+	Sides int `json:"sides,omitempty"`
+	// Dice in the order they were rolled, for groups the value of each expression
+	Dice []Die `json:"dice"`
+	// CountsSuccesses is set when Value is the number of successes instead of the sum of the dice
+	CountsSuccesses bool `json:"counts_successes,omitempty"`
+	Value           int  `json:"value"`
+}
+
+type Die struct {
+	Value int `json:"value"`
+	// Dropped by a keep or drop modifier
+	Dropped bool `json:"dropped,omitempty"`
+	// Rerolled dice were replaced by the next die
+	Rerolled bool `json:"rerolled,omitempty"`
+	// Exploded dice added the next die
+	Exploded bool `json:"exploded,omitempty"`
+	Success  bool `json:"success,omitempty"`
+}
+
+// Counts tells whether the die is part of the value of its term
+func (d Die) Counts() bool {
+	return !d.Dropped && !d.Rerolled
+}
+
+func (d Die) String() string {
+	text := strconv.Itoa(d.Value)
+	switch {
+	case !d.Counts():
+		return "~" + text + "~"
+	case d.Exploded:
+		text += "!"
+	}
+	if d.Success {
+		text += "*"
+	}
+	return text
+}
+
+type evaluator struct {
+	roll  Roller
+	rolls int
+	terms []Term
+}
+
+func (ev *evaluator) rollDie(sides int) (Die, error) {
+	ev.rolls++
+	if ev.rolls > MaxRolls {
+		return Die{}, fmt.Errorf("%w: it rolls more than %d dice", ErrLimitExceeded, MaxRolls)
+	}
+	return Die{Value: ev.roll(sides)}, nil
+}
+
+func (n *number) eval(ev *evaluator) (int, string, error) {
+	return n.value, strconv.Itoa(n.value), nil
+}
+
+func (n *paren) eval(ev *evaluator) (int, string, error) {
+	value, breakdown, err := n.inner.eval(ev)
+	return value, "(" + breakdown + ")", err
+}
+
+func (n *negate) eval(ev *evaluator) (int, string, error) {
+	value, breakdown, err := n.operand.eval(ev)
+	return -value, "-" + breakdown, err
+}
+
+func (n *binary) eval(ev *evaluator) (int, string, error) {
+	left, leftBreakdown, err := n.left.eval(ev)
+	if err != nil {
+		return 0, "", err
+	}
+	right, rightBreakdown, err := n.right.eval(ev)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var value int
+	switch n.op {
+	case '+':
+		value = left + right
+	case '-':
+		value = left - right
+	case '*':
+		if left != 0 && abs(right) > maxValue/abs(left) {
+			return 0, "", fmt.Errorf("%w: the result is larger than %d", ErrLimitExceeded, maxValue)
+		}
+		value = left * right
+	case '/':
+		if right == 0 {
+			return 0, "", fmt.Errorf("division by zero in %s", n.String())
+		}
+		value = left / right
+	}
+	if abs(value) > maxValue {
+		return 0, "", fmt.Errorf("%w: the result is larger than %d", ErrLimitExceeded, maxValue)
+	}
+	return value, leftBreakdown + string(n.op) + rightBreakdown, nil
+}
+
+// eval rolls the dice, then rerolls, explodes, keeps and counts the successes, in this order
+func (n *diceTerm) eval(ev *evaluator) (int, string, error) {
+	var explodeOn *comparePoint
+	if n.explode != nil {
+		explodeOn = n.explode.explodesOn(n.sides)
+	}
+
+	dice := make([]Die, 0, n.count)
+	for i := 0; i < n.count; i++ {
+		die, err := ev.rollDie(n.sides)
+		if err != nil {
+			return 0, "", err
+		}
+		dice = append(dice, die)
+
+		for n.reroll != nil && n.reroll.point.matches(dice[len(dice)-1].Value) {
+			dice[len(dice)-1].Rerolled = true
+			if die, err = ev.rollDie(n.sides); err != nil {
+				return 0, "", err
+			}
+			dice = append(dice, die)
+			if n.reroll.once {
+				break
+			}
+		}
+
+		for explodeOn != nil && explodeOn.matches(dice[len(dice)-1].Value) {
+			dice[len(dice)-1].Exploded = true
+			if die, err = ev.rollDie(n.sides); err != nil {
+				return 0, "", err
+			}
+			dice = append(dice, die)
+		}
+	}
+
+	term := Term{Expression: n.String(), Sides: n.sides, Dice: dice}
+	term.Value = resolve(term.Dice, n.keep, n.success)
+	term.CountsSuccesses = n.success != nil
+	ev.terms = append(ev.terms, term)
+
+	values := make([]string, len(dice))
+	for i, die := range dice {
+		values[i] = die.String()
+	}
+	return term.Value, n.String() + "[" + strings.Join(values, ", ") + "]", nil
+}
+
+func (n *group) eval(ev *evaluator) (int, string, error) {
+	dice := make([]Die, len(n.items))
+	breakdowns := make([]string, len(n.items))
+	for i, item := range n.items {
+		value, breakdown, err := item.eval(ev)
+		if err != nil {
+			return 0, "", err
+		}
+		dice[i] = Die{Value: value}
+		breakdowns[i] = breakdown
+	}
+
+	term := Term{Expression: n.String(), Dice: dice}
+	term.Value = resolve(term.Dice, n.keep, n.success)
+	term.CountsSuccesses = n.success != nil
+	ev.terms = append(ev.terms, term)
+
+	for i, die := range dice {
+		if !die.Counts() {
+			breakdowns[i] = "~" + breakdowns[i] + "~"
+		} else if die.Success {
+			breakdowns[i] += "*"
+		}
+	}
+	breakdown := "{" + strings.Join(breakdowns, ", ") + "}"
+	if n.keep != nil {
+		breakdown += n.keep.String()
+	}
+	if n.success != nil {
+		breakdown += successString(*n.success)
+	}
+	return term.Value, breakdown, nil
+}
+
+// resolve applies the keep and success modifiers to the dice that were not rerolled and returns the value of
+// the term: the number of successes, or the sum of the dice that were kept
+func resolve(dice []Die, keep *keepModifier, success *comparePoint) int {
+	var counted []int
+	for i, die := range dice {
+		if die.Counts() {
+			counted = append(counted, i)
+		}
+	}
+
+	if keep != nil {
+		// lowest first, ties keep the order they were rolled
+		sort.SliceStable(counted, func(a, b int) bool { return dice[counted[a]].Value < dice[counted[b]].Value })
+		n := min(keep.n, len(counted))
+		var dropped []int
+		switch keep.kind {
+		case "kh":
+			dropped = counted[:len(counted)-n]
+		case "kl":
+			dropped = counted[n:]
+		case "dh":
+			dropped = counted[len(counted)-n:]
+		case "dl":
+			dropped = counted[:n]
+		}
+		for _, i := range dropped {
+			dice[i].Dropped = true
+		}
+	}
+
+	value := 0
+	for i := range dice {
+		if !dice[i].Counts() {
+			continue
+		}
+		if success == nil {
+			value += dice[i].Value
+		} else if success.matches(dice[i].Value) {
+			dice[i].Success = true
+			value++
+		}
+	}
+	return value
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
@@ -0,0 +1,83 @@
+package dice
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Limits of an expression, a roll is typed by players so every part of it is bounded
+const (
+	MaxExpressionLength = 200
+	// MaxDice is the number of dice of a single term, e.g. the 100 of "100d6"
+	MaxDice  = 100
+	MaxSides = 1000
+	// MaxRolls is the number of dice rolled by the whole expression, explosions and rerolls included
+	MaxRolls = 500
+	// MaxGroupItems is the number of expressions inside a {} group
+	MaxGroupItems = 20
+	maxNesting    = 10
+	maxNumber     = 1000000
+	maxValue      = 1000000000000
+)
+
+// ErrLimitExceeded is returned when evaluating an expression rolls too many dice or gets too large a result
+var ErrLimitExceeded = errors.New("dice expression exceeds the limits")
+
+// Roller returns a random value between 1 and sides
+type Roller func(sides int) int
+
+// Expression is a parsed roll, e.g. "4d6kh3", "1d20+5" or "{1d8+2, 1d6}kh1". It can be evaluated any number of times.
+//
+// The language has integers, dice "NdS" (N defaults to 1, "d%" is a d100), + - * / (integer division,
+// rounding toward zero), parentheses, and {a, b, ...} groups. Dice take the modifiers, in any order:
+//   - kh N / k N, kl N: keep the N highest or lowest dice (N defaults to 1); dh N, dl N drop them
+//   - ! explodes on the highest face, or !>N, !N...: each matching die adds another die
+//   - r N, r<N...: rerolls the matching dice until they stop matching; ro only rerolls once
+//   - >=N, >N, <=N, <N, =N: the term counts the dice that match instead of adding them
+//
+// Groups take the keep, drop and success modifiers, applied to the value of each expression
+type Expression struct {
+	root node
+}
+
+// Parse reads an expression, spaces are ignored and the letters are case-insensitive
+func Parse(expr string) (*Expression, error) {
+	if len(expr) > MaxExpressionLength {
+		return nil, fmt.Errorf("dice expression cannot be longer than %d characters", MaxExpressionLength)
+	}
+	input := strings.ToLower(strings.Join(strings.Fields(expr), ""))
+	if input == "" {
+		return nil, fmt.Errorf("dice expression is empty")
+	}
+
+	p := &parser{input: input}
+	root, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.input) {
+		return nil, p.errorf("unexpected %q", p.input[p.pos])
+	}
+	return &Expression{root: root}, nil
+}
+
+// String is the expression written in its canonical form, e.g. "d20 + 5" is "1d20+5"
+func (e *Expression) String() string {
+	return e.root.String()
+}
+
+// Evaluate rolls the dice of the expression with roll
+func (e *Expression) Evaluate(roll Roller) (*Result, error) {
+	ev := &evaluator{roll: roll}
+	total, breakdown, err := e.root.eval(ev)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{
+		Expression: e.String(),
+		Terms:      ev.terms,
+		Breakdown:  breakdown,
+		Total:      total,
+	}, nil
+}
@@ -0,0 +1,327 @@
+package dice
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// scripted returns the values in order, then the highest face forever
+func scripted(values ...int) Roller {
+	return func(sides int) int {
+		if len(values) == 0 {
+			return sides
+		}
+		value := values[0]
+		values = values[1:]
+		return value
+	}
+}
+
+func TestParseCanonicalForm(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"1d20+5", "1d20+5"},
+		{"d20 + 5", "1d20+5"},
+		{"4D6KH3", "4d6kh3"},
+		{"4d6k3", "4d6kh3"},
+		{"4d6k", "4d6kh1"},
+		{"2d20kl1", "2d20kl1"},
+		{"4d6dl1", "4d6dl1"},
+		{"3d6dh", "3d6dh1"},
+		{"1d6!", "1d6!"},
+		{"1d6!>5", "1d6!>5"},
+		{"1d6!5", "1d6!5"},
+		{"2d10r1", "2d10r1"},
+		{"2d10ro<3", "2d10ro<3"},
+		{"5d10>=8", "5d10>=8"},
+		{"4d6kh3!r1", "4d6r1!kh3"},
+		{"d%", "1d%"},
+		{"1d8+1d6+4", "1d8+1d6+4"},
+		{"(1d4+1)*2", "(1d4+1)*2"},
+		{"-1d4", "-1d4"},
+		{"10/3", "10/3"},
+		{"{1d8+2, 1d6}kh1", "{1d8+2,1d6}kh1"},
+		{"{4d6, 4d6, 4d6}>=12", "{4d6,4d6,4d6}>=12"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			expression, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q) returned error: %v", tt.expr, err)
+			}
+			if got := expression.String(); got != tt.want {
+				t.Errorf("Parse(%q).String() = %q, want %q", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		wantErr string
+	}{
+		{"empty", "   ", "empty"},
+		{"too long", strings.Repeat("1+", 100) + "1", "longer than"},
+		{"no sides", "2d", "expected a number"},
+		{"one side", "1d1", "sides must be between"},
+		{"too many sides", "1d1001", "sides must be between"},
+		{"zero dice", "0d6", "number of dice must be between"},
+		{"too many dice", "101d6", "number of dice must be between"},
+		{"huge number", "99999999", "larger than"},
+		{"unclosed paren", "(1d6+2", "expected \")\""},
+		{"unclosed group", "{1d6, 1d8", "expected \",\" or \"}\""},
+		{"trailing operator", "1d6+", "expected a number or dice"},
+		{"unknown character", "1d6+x", "unexpected 'x'"},
+		{"two keeps", "4d6kh3kl1", "only one keep"},
+		{"keep zero", "4d6kh0", "at least 1 die"},
+		{"explode every face", "1d6!>=1", "explode on every face"},
+		{"reroll every face", "1d6r<7", "rerolled on every face"},
+		{"reroll without point", "1d6r", "expected a number"},
+		{"too deep", strings.Repeat("(", 11) + "1" + strings.Repeat(")", 11), "nested levels"},
+		{"too many group items", "{" + strings.Repeat("1,", MaxGroupItems) + "1}", "more than"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.expr)
+			if err == nil {
+				t.Fatalf("Parse(%q) returned no error", tt.expr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Parse(%q) error = %q, want it to contain %q", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name          string
+		expr          string
+		rolls         []int
+		wantTotal     int
+		wantBreakdown string
+		// wantDice is the dice of the first term
+		wantDice []Die
+	}{
+		{
+			name:          "flat modifier",
+			expr:          "1d20+5",
+			rolls:         []int{13},
+			wantTotal:     18,
+			wantBreakdown: "1d20[13]+5",
+			wantDice:      []Die{{Value: 13}},
+		},
+		{
+			name:          "keep highest",
+			expr:          "4d6kh3",
+			rolls:         []int{6, 1, 4, 5},
+			wantTotal:     15,
+			wantBreakdown: "4d6kh3[6, ~1~, 4, 5]",
+			wantDice:      []Die{{Value: 6}, {Value: 1, Dropped: true}, {Value: 4}, {Value: 5}},
+		},
+		{
+			name:          "keep lowest",
+			expr:          "2d20kl1",
+			rolls:         []int{17, 3},
+			wantTotal:     3,
+			wantBreakdown: "2d20kl1[~17~, 3]",
+			wantDice:      []Die{{Value: 17, Dropped: true}, {Value: 3}},
+		},
+		{
+			name:      "drop lowest ties drop the first die",
+			expr:      "3d6dl1",
+			rolls:     []int{2, 2, 5},
+			wantTotal: 7,
+			wantDice:  []Die{{Value: 2, Dropped: true}, {Value: 2}, {Value: 5}},
+		},
+		{
+			name:          "exploding",
+			expr:          "1d6!",
+			rolls:         []int{6, 6, 2},
+			wantTotal:     14,
+			wantBreakdown: "1d6![6!, 6!, 2]",
+			wantDice:      []Die{{Value: 6, Exploded: true}, {Value: 6, Exploded: true}, {Value: 2}},
+		},
+		{
+			name:      "exploding on a point",
+			expr:      "2d6!>4",
+			rolls:     []int{5, 1, 3},
+			wantTotal: 9,
+			wantDice:  []Die{{Value: 5, Exploded: true}, {Value: 1}, {Value: 3}},
+		},
+		{
+			name:          "reroll until it stops matching",
+			expr:          "1d10r1",
+			rolls:         []int{1, 1, 7},
+			wantTotal:     7,
+			wantBreakdown: "1d10r1[~1~, ~1~, 7]",
+			wantDice:      []Die{{Value: 1, Rerolled: true}, {Value: 1, Rerolled: true}, {Value: 7}},
+		},
+		{
+			name:      "reroll once",
+			expr:      "1d10ro1",
+			rolls:     []int{1, 1},
+			wantTotal: 1,
+			wantDice:  []Die{{Value: 1, Rerolled: true}, {Value: 1}},
+		},
+		{
+			name:          "success counting",
+			expr:          "5d10>=8",
+			rolls:         []int{8, 3, 10, 7, 9},
+			wantTotal:     3,
+			wantBreakdown: "5d10>=8[8*, 3, 10*, 7, 9*]",
+			wantDice:      []Die{{Value: 8, Success: true}, {Value: 3}, {Value: 10, Success: true}, {Value: 7}, {Value: 9, Success: true}},
+		},
+		{
+			name:      "successes after keep",
+			expr:      "3d6kh2>4",
+			rolls:     []int{6, 5, 1},
+			wantTotal: 2,
+			wantDice:  []Die{{Value: 6, Success: true}, {Value: 5, Success: true}, {Value: 1, Dropped: true}},
+		},
+		{
+			name:          "mixed dice",
+			expr:          "1d8+1d6+4",
+			rolls:         []int{5, 2},
+			wantTotal:     11,
+			wantBreakdown: "1d8[5]+1d6[2]+4",
+			wantDice:      []Die{{Value: 5}},
+		},
+		{
+			name:          "precedence and parentheses",
+			expr:          "(1d4+1)*2-10/3",
+			rolls:         []int{3},
+			wantTotal:     5,
+			wantBreakdown: "(1d4[3]+1)*2-10/3",
+		},
+		{
+			name:      "negative dice",
+			expr:      "-1d4+2",
+			rolls:     []int{3},
+			wantTotal: -1,
+		},
+		{
+			name:      "percentile",
+			expr:      "d%",
+			rolls:     []int{42},
+			wantTotal: 42,
+		},
+		{
+			name:          "group keep highest",
+			expr:          "{1d8+2, 1d6}kh1",
+			rolls:         []int{3, 6},
+			wantTotal:     6,
+			wantBreakdown: "{~1d8[3]+2~, 1d6[6]}kh1",
+			wantDice:      []Die{{Value: 3}},
+		},
+		{
+			name:      "group successes",
+			expr:      "{2d6, 2d6, 2d6}>=7",
+			rolls:     []int{6, 5, 1, 2, 4, 4},
+			wantTotal: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expression, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q) returned error: %v", tt.expr, err)
+			}
+			result, err := expression.Evaluate(scripted(tt.rolls...))
+			if err != nil {
+				t.Fatalf("Evaluate(%q) returned error: %v", tt.expr, err)
+			}
+
+			if result.Total != tt.wantTotal {
+				t.Errorf("Evaluate(%q).Total = %d, want %d", tt.expr, result.Total, tt.wantTotal)
+			}
+			if tt.wantBreakdown != "" && result.Breakdown != tt.wantBreakdown {
+				t.Errorf("Evaluate(%q).Breakdown = %q, want %q", tt.expr, result.Breakdown, tt.wantBreakdown)
+			}
+			if tt.wantDice != nil {
+				if len(result.Terms) == 0 {
+					t.Fatalf("Evaluate(%q) returned no terms", tt.expr)
+				}
+				got := result.Terms[0].Dice
+				if len(got) != len(tt.wantDice) {
+					t.Fatalf("Evaluate(%q) first term dice = %+v, want %+v", tt.expr, got, tt.wantDice)
+				}
+				for i := range got {
+					if got[i] != tt.wantDice[i] {
+						t.Errorf("Evaluate(%q) die %d = %+v, want %+v", tt.expr, i, got[i], tt.wantDice[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEvaluateGroupTerms(t *testing.T) {
+	expression, err := Parse("{1d8+2, 1d6}kh1")
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	result, err := expression.Evaluate(scripted(3, 6))
+	if err != nil {
+		t.Fatalf("Evaluate returned error: %v", err)
+	}
+
+	// the dice of the group come first, the group is the last term
+	if len(result.Terms) != 3 {
+		t.Fatalf("got %d terms, want 3", len(result.Terms))
+	}
+	group := result.Terms[2]
+	if group.Sides != 0 || group.Expression != "{1d8+2,1d6}kh1" {
+		t.Errorf("group term = %+v", group)
+	}
+	want := []Die{{Value: 5, Dropped: true}, {Value: 6}}
+	for i := range want {
+		if group.Dice[i] != want[i] {
+			t.Errorf("group value %d = %+v, want %+v", i, group.Dice[i], want[i])
+		}
+	}
+}
+
+func TestEvaluateLimits(t *testing.T) {
+	tests := []struct {
+		name  string
+		expr  string
+		rolls []int
+	}{
+		// the scripted roller always returns the highest face once the values run out
+		{"endless explosion", "1d6!", nil},
+		{"too many dice in total", "100d6+100d6+100d6+100d6+100d6+1d6", []int{}},
+		{"result too large", "1000000*1000000*10", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expression, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q) returned error: %v", tt.expr, err)
+			}
+			_, err = expression.Evaluate(scripted(tt.rolls...))
+			if !errors.Is(err, ErrLimitExceeded) {
+				t.Errorf("Evaluate(%q) error = %v, want ErrLimitExceeded", tt.expr, err)
+			}
+		})
+	}
+}
+
+func TestEvaluateDivisionByZero(t *testing.T) {
+	expression, err := Parse("1d6/(2-2)")
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if _, err := expression.Evaluate(scripted(4)); err == nil || !strings.Contains(err.Error(), "division by zero") {
+		t.Errorf("Evaluate error = %v, want division by zero", err)
+	}
+}
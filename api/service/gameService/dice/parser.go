@@ -0,0 +1,511 @@
+package dice
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// node is a part of a parsed expression
+type node interface {
+	eval(ev *evaluator) (value int, breakdown string, err error)
+	String() string
+}
+
+type number struct {
+	value int
+}
+
+type paren struct {
+	inner node
+}
+
+type negate struct {
+	operand node
+}
+
+type binary struct {
+	op          byte
+	left, right node
+}
+
+type diceTerm struct {
+	count, sides int
+	percent      bool
+	reroll       *rerollModifier
+	explode      *explodeModifier
+	keep         *keepModifier
+	success      *comparePoint
+}
+
+type group struct {
+	items   []node
+	keep    *keepModifier
+	success *comparePoint
+}
+
+// keepModifier is one of kh, kl, dh or dl with how many dice it keeps or drops
+type keepModifier struct {
+	kind string
+	n    int
+}
+
+// explodeModifier explodes on the highest face when point is nil
+type explodeModifier struct {
+	point *comparePoint
+}
+
+type rerollModifier struct {
+	once  bool
+	point comparePoint
+}
+
+type comparePoint struct {
+	op    string
+	value int
+}
+
+func (c comparePoint) matches(value int) bool {
+	switch c.op {
+	case ">=":
+		return value >= c.value
+	case "<=":
+		return value <= c.value
+	case ">":
+		return value > c.value
+	case "<":
+		return value < c.value
+	}
+	return value == c.value
+}
+
+// matchesEveryFace tells whether a die could never stop exploding or being rerolled
+func (c comparePoint) matchesEveryFace(sides int) bool {
+	for face := 1; face <= sides; face++ {
+		if !c.matches(face) {
+			return false
+		}
+	}
+	return true
+}
+
+// String writes the point, a bare number means "=" after ! and r
+func (c comparePoint) String() string {
+	if c.op == "=" {
+		return strconv.Itoa(c.value)
+	}
+	return c.op + strconv.Itoa(c.value)
+}
+
+type parser struct {
+	input string
+	pos   int
+	depth int
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	near := "at the end"
+	if p.pos < len(p.input) {
+		rest := p.input[p.pos:]
+		if len(rest) > 10 {
+			rest = rest[:10]
+		}
+		near = fmt.Sprintf("near %q", rest)
+	}
+	return fmt.Errorf("invalid dice expression %s: %s", near, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) peek() byte {
+	if p.pos < len(p.input) {
+		return p.input[p.pos]
+	}
+	return 0
+}
+
+func (p *parser) consume(token string) bool {
+	if strings.HasPrefix(p.input[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+// enter and leave bound the nesting of parentheses, groups and signs
+func (p *parser) enter() error {
+	p.depth++
+	if p.depth > maxNesting {
+		return p.errorf("more than %d nested levels", maxNesting)
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+// parseSum reads terms joined by + and -
+func (p *parser) parseSum() (node, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+		p.pos++
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseProduct reads factors joined by * and /
+func (p *parser) parseProduct() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '*' || op == '/'; op = p.peek() {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if !p.consume("-") {
+		return p.parsePrimary()
+	}
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return &negate{operand: operand}, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	switch c := p.peek(); {
+	case c == '(':
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+		p.pos++
+		inner, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(")") {
+			return nil, p.errorf("expected \")\"")
+		}
+		return &paren{inner: inner}, nil
+	case c == '{':
+		return p.parseGroup()
+	case c == 'd':
+		return p.parseDice(1)
+	case isDigit(c):
+		value, err := p.parseNumber()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() == 'd' {
+			return p.parseDice(value)
+		}
+		return &number{value: value}, nil
+	case c == 0:
+		return nil, p.errorf("expected a number or dice")
+	default:
+		return nil, p.errorf("unexpected %q", c)
+	}
+}
+
+func (p *parser) parseNumber() (int, error) {
+	start := p.pos
+	for isDigit(p.peek()) {
+		p.pos++
+	}
+	if start == p.pos {
+		return 0, p.errorf("expected a number")
+	}
+	digits := p.input[start:p.pos]
+	value, err := strconv.Atoi(digits)
+	if err != nil || value > maxNumber {
+		return 0, fmt.Errorf("number %s is larger than %d", digits, maxNumber)
+	}
+	return value, nil
+}
+
+// parseDice reads "dS" and the modifiers after the number of dice
+func (p *parser) parseDice(count int) (node, error) {
+	p.pos++ // d
+	d := &diceTerm{count: count}
+	if p.consume("%") {
+		d.sides, d.percent = 100, true
+	} else {
+		sides, err := p.parseNumber()
+		if err != nil {
+			return nil, err
+		}
+		d.sides = sides
+	}
+
+	if count <= 0 || count > MaxDice {
+		return nil, fmt.Errorf("number of dice must be between 1 and %d", MaxDice)
+	}
+	if d.sides <= 1 || d.sides > MaxSides {
+		return nil, fmt.Errorf("sides must be between 2 and %d", MaxSides)
+	}
+
+	for {
+		switch {
+		case p.peek() == 'k' || p.consume("dh") || p.consume("dl"):
+			if d.keep != nil {
+				return nil, p.errorf("only one keep or drop modifier per dice")
+			}
+			keep, err := p.parseKeep()
+			if err != nil {
+				return nil, err
+			}
+			d.keep = keep
+		case p.consume("!"):
+			if d.explode != nil {
+				return nil, p.errorf("only one explode modifier per dice")
+			}
+			d.explode = &explodeModifier{}
+			if isComparison(p.peek()) || isDigit(p.peek()) {
+				point, err := p.parseComparePoint()
+				if err != nil {
+					return nil, err
+				}
+				d.explode.point = &point
+			}
+			if d.explode.explodesOn(d.sides) == nil {
+				return nil, p.errorf("dice cannot explode on every face")
+			}
+		case p.peek() == 'r':
+			if d.reroll != nil {
+				return nil, p.errorf("only one reroll modifier per dice")
+			}
+			p.pos++
+			reroll := &rerollModifier{once: p.consume("o")}
+			point, err := p.parseComparePoint()
+			if err != nil {
+				return nil, err
+			}
+			if point.matchesEveryFace(d.sides) {
+				return nil, p.errorf("dice cannot be rerolled on every face")
+			}
+			reroll.point = point
+			d.reroll = reroll
+		case isComparison(p.peek()):
+			if d.success != nil {
+				return nil, p.errorf("only one success condition per dice")
+			}
+			point, err := p.parseComparePoint()
+			if err != nil {
+				return nil, err
+			}
+			d.success = &point
+		default:
+			return d, nil
+		}
+	}
+}
+
+// parseGroup reads "{a, b, ...}" and its modifiers
+func (p *parser) parseGroup() (node, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	p.pos++ // {
+
+	g := &group{}
+	for {
+		item, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		g.items = append(g.items, item)
+		if len(g.items) > MaxGroupItems {
+			return nil, fmt.Errorf("a group cannot have more than %d expressions", MaxGroupItems)
+		}
+		if p.consume("}") {
+			break
+		}
+		if !p.consume(",") {
+			return nil, p.errorf("expected \",\" or \"}\"")
+		}
+	}
+
+	for {
+		switch {
+		case p.peek() == 'k' || p.consume("dh") || p.consume("dl"):
+			if g.keep != nil {
+				return nil, p.errorf("only one keep or drop modifier per group")
+			}
+			keep, err := p.parseKeep()
+			if err != nil {
+				return nil, err
+			}
+			g.keep = keep
+		case isComparison(p.peek()):
+			if g.success != nil {
+				return nil, p.errorf("only one success condition per group")
+			}
+			point, err := p.parseComparePoint()
+			if err != nil {
+				return nil, err
+			}
+			g.success = &point
+		default:
+			return g, nil
+		}
+	}
+}
+
+// parseKeep reads the rest of kh, kl, k, dh or dl. A "dh" or "dl" was already consumed
+func (p *parser) parseKeep() (*keepModifier, error) {
+	keep := &keepModifier{}
+	switch {
+	case p.consume("kl"):
+		keep.kind = "kl"
+	case p.consume("kh"), p.consume("k"):
+		keep.kind = "kh"
+	case p.input[p.pos-1] == 'h':
+		keep.kind = "dh"
+	default:
+		keep.kind = "dl"
+	}
+
+	keep.n = 1
+	if isDigit(p.peek()) {
+		n, err := p.parseNumber()
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, p.errorf("%s needs at least 1 die", keep.kind)
+		}
+		keep.n = n
+	}
+	return keep, nil
+}
+
+// parseComparePoint reads ">=N", ">N", "<=N", "<N", "=N" or a bare "N", which means "=N"
+func (p *parser) parseComparePoint() (comparePoint, error) {
+	point := comparePoint{op: "="}
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if p.consume(op) {
+			point.op = op
+			break
+		}
+	}
+	value, err := p.parseNumber()
+	if err != nil {
+		return point, err
+	}
+	point.value = value
+	return point, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isComparison(c byte) bool {
+	return c == '>' || c == '<' || c == '='
+}
+
+// explodesOn is the point the dice explode on, nil when it matches every face
+func (e *explodeModifier) explodesOn(sides int) *comparePoint {
+	point := comparePoint{op: "=", value: sides}
+	if e.point != nil {
+		point = *e.point
+	}
+	if point.matchesEveryFace(sides) {
+		return nil
+	}
+	return &point
+}
+
+func (n *number) String() string {
+	return strconv.Itoa(n.value)
+}
+
+func (n *paren) String() string {
+	return "(" + n.inner.String() + ")"
+}
+
+func (n *negate) String() string {
+	return "-" + n.operand.String()
+}
+
+func (n *binary) String() string {
+	return n.left.String() + string(n.op) + n.right.String()
+}
+
+// String writes the modifiers in the order they are applied: reroll, explode, keep and success
+func (n *diceTerm) String() string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "%dd", n.count)
+	if n.percent {
+		text.WriteString("%")
+	} else {
+		text.WriteString(strconv.Itoa(n.sides))
+	}
+	if n.reroll != nil {
+		text.WriteString("r")
+		if n.reroll.once {
+			text.WriteString("o")
+		}
+		text.WriteString(n.reroll.point.String())
+	}
+	if n.explode != nil {
+		text.WriteString("!")
+		if n.explode.point != nil {
+			text.WriteString(n.explode.point.String())
+		}
+	}
+	if n.keep != nil {
+		text.WriteString(n.keep.String())
+	}
+	if n.success != nil {
+		text.WriteString(successString(*n.success))
+	}
+	return text.String()
+}
+
+func (n *group) String() string {
+	items := make([]string, len(n.items))
+	for i, item := range n.items {
+		items[i] = item.String()
+	}
+	text := "{" + strings.Join(items, ",") + "}"
+	if n.keep != nil {
+		text += n.keep.String()
+	}
+	if n.success != nil {
+		text += successString(*n.success)
+	}
+	return text
+}
+
+func (k *keepModifier) String() string {
+	return k.kind + strconv.Itoa(k.n)
+}
+
+// successString always writes the operator, a bare number after the dice would be read as more sides
+func successString(c comparePoint) string {
+	return c.op + strconv.Itoa(c.value)
+}
@@ -0,0 +1,179 @@
+package dice
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/GarotoCowboy/vttProject/api/models"
+	"github.com/GarotoCowboy/vttProject/api/models/consts"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrInvalidRoll is returned by Roll for dice it cannot roll, e.g. more than MaxDice
+	ErrInvalidRoll     = errors.New("invalid roll")
+	ErrNotMember       = errors.New("tableUser is not a member of this table")
+	ErrNotMaster       = errors.New("only masters can reveal the dice seed")
+	ErrRollNotFound    = errors.New("roll not found")
+	ErrSeedNotRevealed = errors.New("the seed of this roll is not revealed yet")
+)
+
+// Verification is a roll recomputed from its revealed seed
+type Verification struct {
+	RollID     uint      `json:"roll_id"`
+	TableID    uint      `json:"table_id"`
+	UserID     uint      `json:"user_id"`
+	RolledAt   time.Time `json:"rolled_at"`
+	Seed       string    `json:"seed"`
+	Commitment string    `json:"commitment"`
+	Nonce      uint64    `json:"nonce"`
+	// CommitmentMatches tells whether the SHA-256 of the seed is the commitment published before the roll
+	CommitmentMatches bool    `json:"commitment_matches"`
+	Recorded          *Result `json:"recorded"`
+	Recomputed        *Result `json:"recomputed"`
+	// Valid is set when the commitment matches and the recomputed roll is the recorded one
+	Valid bool `json:"valid"`
+}
+
+// CurrentSeed is the seed the next rolls of the table are drawn from, committed now if the table has none.
+// Only its commitment may be shown before it is revealed
+func CurrentSeed(tableID, userID uint, db *gorm.DB) (*models.DiceSeed, error) {
+	if err := checkMembership(tableID, userID, db); err != nil {
+		return nil, err
+	}
+
+	var seed *models.DiceSeed
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		seed, err = currentSeed(tx, tableID)
+		return err
+	})
+	return seed, err
+}
+
+// RevealSeed ends the current seed of the table so its rolls can be verified and commits the seed of the next
+// rolls. Only masters can reveal, e.g. at the end of a session
+func RevealSeed(tableID, userID uint, db *gorm.DB) (revealed, next *models.DiceSeed, err error) {
+	member, err := findMember(tableID, userID, db)
+	if err != nil {
+		return nil, nil, err
+	}
+	if member.Role != consts.Master {
+		return nil, nil, ErrNotMaster
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if revealed, err = currentSeed(tx, tableID); err != nil {
+			return err
+		}
+		now := time.Now()
+		if err := tx.Model(revealed).Update("revealed_at", now).Error; err != nil {
+			return err
+		}
+		revealed.RevealedAt = &now
+
+		next, err = currentSeed(tx, tableID)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return revealed, next, nil
+}
+
+// VerifyRoll recomputes a roll from its revealed seed for a member of its table. Rolls to the masters can only
+// be verified by the masters and who rolled them
+func VerifyRoll(rollID, userID uint, db *gorm.DB) (*Verification, error) {
+	var roll models.DiceRoll
+	if err := db.Preload("Seed").First(&roll, rollID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRollNotFound
+		}
+		return nil, err
+	}
+
+	member, err := findMember(roll.TableID, userID, db)
+	if err != nil {
+		if errors.Is(err, ErrNotMember) {
+			return nil, ErrRollNotFound
+		}
+		return nil, err
+	}
+	if roll.ToMasters && member.Role != consts.Master && roll.UserID != userID {
+		return nil, ErrRollNotFound
+	}
+	if roll.Seed.RevealedAt == nil {
+		return nil, ErrSeedNotRevealed
+	}
+
+	secret, err := hex.DecodeString(roll.Seed.Seed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid seed %d: %w", roll.SeedID, err)
+	}
+	expression, err := Parse(roll.Expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression of roll %d: %w", roll.ID, err)
+	}
+	recomputed, err := expression.Evaluate(SeedRoller(secret, roll.Nonce))
+	if err != nil {
+		return nil, fmt.Errorf("could not recompute roll %d: %w", roll.ID, err)
+	}
+
+	recorded := &Result{}
+	if err := json.Unmarshal(roll.Result, recorded); err != nil {
+		return nil, fmt.Errorf("invalid result of roll %d: %w", roll.ID, err)
+	}
+
+	verification := &Verification{
+		RollID:            roll.ID,
+		TableID:           roll.TableID,
+		UserID:            roll.UserID,
+		RolledAt:          roll.CreatedAt,
+		Seed:              roll.Seed.Seed,
+		Commitment:        roll.Seed.Commitment,
+		Nonce:             roll.Nonce,
+		CommitmentMatches: Commit(secret) == roll.Seed.Commitment,
+		Recorded:          recorded,
+		Recomputed:        recomputed,
+	}
+	verification.Valid = verification.CommitmentMatches && sameResult(recorded, recomputed)
+	return verification, nil
+}
+
+// currentSeed returns the seed of the table that is not revealed, committing a new one if there is none.
+// The table row stays locked until tx ends, so the rolls of a table take their nonces one at a time
+func currentSeed(tx *gorm.DB, tableID uint) (*models.DiceSeed, error) {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&models.Table{}, tableID).Error; err != nil {
+		return nil, err
+	}
+
+	var seed models.DiceSeed
+	if err := tx.Where("table_id = ? AND revealed_at IS NULL", tableID).Order("id").Limit(1).Find(&seed).Error; err != nil {
+		return nil, err
+	}
+	if seed.ID != 0 {
+		return &seed, nil
+	}
+
+	secret, commitment, err := newSeed()
+	if err != nil {
+		return nil, err
+	}
+	seed = models.DiceSeed{TableID: tableID, Seed: secret, Commitment: commitment}
+	if err := tx.Create(&seed).Error; err != nil {
+		return nil, err
+	}
+	return &seed, nil
+}
+
+// sameResult compares the JSON of the results, the recorded one was read back from jsonb
+func sameResult(a, b *Result) bool {
+	aJSON, errA := json.Marshal(a)
+	bJSON, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(aJSON, bJSON)
+}
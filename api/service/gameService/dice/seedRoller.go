@@ -0,0 +1,62 @@
+package dice
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	endian "encoding/binary"
+	"encoding/hex"
+)
+
+// seedSize is the number of random bytes of a seed
+const seedSize = 32
+
+// SeedRoller draws the dice of the roll nonce from seed, the same seed and nonce always give the same dice.
+//
+// The dice are read from the blocks HMAC-SHA256(seed, nonce || counter), with nonce and counter as 8 byte big
+// endian integers and counter starting at 0. Each block is split in 8 big endian uint32 values, in order, and a
+// die of S sides is value % S + 1. Values at or above the largest multiple of S below 2^32 are skipped so every
+// face is equally likely
+func SeedRoller(seed []byte, nonce uint64) Roller {
+	var block []byte
+	var counter uint64
+	next := func() uint64 {
+		if len(block) == 0 {
+			var message [16]byte
+			endian.BigEndian.PutUint64(message[:8], nonce)
+			endian.BigEndian.PutUint64(message[8:], counter)
+			mac := hmac.New(sha256.New, seed)
+			mac.Write(message[:])
+			block = mac.Sum(nil)
+			counter++
+		}
+		value := endian.BigEndian.Uint32(block)
+		block = block[4:]
+		return uint64(value)
+	}
+
+	return func(sides int) int {
+		faces := uint64(sides)
+		limit := (1 << 32) / faces * faces
+		for {
+			if value := next(); value < limit {
+				return int(value%faces) + 1
+			}
+		}
+	}
+}
+
+// newSeed returns a hex encoded seed from crypto/rand and its commitment
+func newSeed() (seed, commitment string, err error) {
+	secret := make([]byte, seedSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(secret), Commit(secret), nil
+}
+
+// Commit is the public commitment of a seed: its SHA-256, hex encoded
+func Commit(seed []byte) string {
+	sum := sha256.Sum256(seed)
+	return hex.EncodeToString(sum[:])
+}
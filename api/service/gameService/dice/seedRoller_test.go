@@ -0,0 +1,89 @@
+package dice
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestCommit(t *testing.T) {
+	tests := []struct {
+		seed string
+		want string
+	}{
+		{"", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{"abc", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+	}
+
+	for _, tt := range tests {
+		if got := Commit([]byte(tt.seed)); got != tt.want {
+			t.Errorf("Commit(%q) = %s, want %s", tt.seed, got, tt.want)
+		}
+	}
+}
+
+func TestNewSeed(t *testing.T) {
+	seed, commitment, err := newSeed()
+	if err != nil {
+		t.Fatalf("newSeed returned error: %v", err)
+	}
+	secret, err := hex.DecodeString(seed)
+	if err != nil || len(secret) != seedSize {
+		t.Fatalf("seed %q is not %d hex encoded bytes", seed, seedSize)
+	}
+	if Commit(secret) != commitment {
+		t.Errorf("commitment %s is not the SHA-256 of the seed", commitment)
+	}
+
+	other, _, _ := newSeed()
+	if other == seed {
+		t.Errorf("two seeds are equal: %s", seed)
+	}
+}
+
+// TestSeedRollerIsDeterministic pins the dice drawn from a seed, verifiers outside the server must get the same
+func TestSeedRollerIsDeterministic(t *testing.T) {
+	seed := []byte("vtt")
+	tests := []struct {
+		nonce uint64
+		sides int
+		want  []int
+	}{
+		{0, 20, []int{20, 3, 10, 5, 14}},
+		{1, 20, []int{4, 13, 10, 16, 14}},
+		{0, 6, []int{2, 3, 4, 5, 4}},
+		{7, 100, []int{37, 71, 70, 75, 82}},
+	}
+
+	for _, tt := range tests {
+		roll := SeedRoller(seed, tt.nonce)
+		got := make([]int, len(tt.want))
+		for i := range got {
+			got[i] = roll(tt.sides)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("SeedRoller(nonce %d) d%d = %v, want %v", tt.nonce, tt.sides, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestSeedRollerRange(t *testing.T) {
+	tests := []int{2, 3, 6, 20, 100, MaxSides}
+
+	for _, sides := range tests {
+		roll := SeedRoller([]byte("range"), uint64(sides))
+		seen := make(map[int]bool)
+		for i := 0; i < sides*50; i++ {
+			value := roll(sides)
+			if value < 1 || value > sides {
+				t.Fatalf("d%d rolled %d", sides, value)
+			}
+			seen[value] = true
+		}
+		if sides <= 20 && len(seen) != sides {
+			t.Errorf("d%d only rolled %d different faces in %d rolls", sides, len(seen), sides*50)
+		}
+	}
+}
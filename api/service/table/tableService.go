@@ -179,6 +179,9 @@ func UpdateTable(db *gorm.DB, tableID, userID uint, req tableDTO.UpdateTableRequ
 
 		tableData.Password = string(hashedPassword)
 	}
+	if req.MastersReadWhispers != nil {
+		tableData.MastersReadWhispers = *req.MastersReadWhispers
+	}
 
 	if err := db.Save(&tableData).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket per key (usually a user ID). Each key gets `burst` tokens
+// that refill at `rate` tokens per second.
+type RateLimiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[uint]*bucket
+	lastPrune time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Buckets untouched for this long are full again and can be forgotten.
+const rateLimiterIdle = time.Minute
+
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		buckets:   make(map[uint]*bucket),
+		lastPrune: time.Now(),
+	}
+}
+
+// Allow reports whether key may act now, consuming one token if it can.
+func (r *RateLimiter) Allow(key uint) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.prune(now)
+
+	b, ok := r.buckets[key]
+	if !ok {
+		b = &bucket{tokens: r.burst, last: now}
+		r.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * r.rate
+	if b.tokens > r.burst {
+		b.tokens = r.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (r *RateLimiter) prune(now time.Time) {
+	if now.Sub(r.lastPrune) < rateLimiterIdle {
+		return
+	}
+	r.lastPrune = now
+	for key, b := range r.buckets {
+		if now.Sub(b.last) >= rateLimiterIdle {
+			delete(r.buckets, key)
+		}
+	}
+}
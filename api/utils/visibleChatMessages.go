@@ -0,0 +1,22 @@
+package utils
+
+import (
+	"github.com/GarotoCowboy/vttProject/api/models"
+	"github.com/GarotoCowboy/vttProject/api/models/consts"
+	"gorm.io/gorm"
+)
+
+// VisibleChatMessages limits a chat query to the messages the table user can read: public messages and
+// the private ones they sent or received. Masters read every whisper when the table allows it.
+// tableUser must have its Table loaded
+func VisibleChatMessages(tableUser models.TableUser) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if tableUser.Role == consts.Master && tableUser.Table.MastersReadWhispers {
+			return db
+		}
+		return db.Where(`NOT EXISTS (SELECT 1 FROM chat_message_recipients r WHERE r.chat_message_id = chat_messages.id)
+			OR chat_messages.table_user_id = ?
+			OR EXISTS (SELECT 1 FROM chat_message_recipients r WHERE r.chat_message_id = chat_messages.id AND r.table_user_id = ?)`,
+			tableUser.ID, tableUser.ID)
+	}
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"github.com/GarotoCowboy/vttProject/api/models"
+	"github.com/GarotoCowboy/vttProject/api/models/consts"
+	"gorm.io/gorm"
+)
+
+// migrateChatRecipients moves the recipient of private messages from the old to_table_user_id column
+// to the chat_message_recipients table, and gives the messages sent to the masters their recipients.
+// AutoMigrate never drops columns, so the column is dropped here once its data was copied
+func migrateChatRecipients(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if tx.Migrator().HasColumn(&models.ChatMessage{}, "to_table_user_id") {
+			if err := tx.Exec(`INSERT INTO chat_message_recipients (chat_message_id, table_user_id)
+				SELECT id, to_table_user_id FROM chat_messages WHERE to_table_user_id IS NOT NULL
+				ON CONFLICT DO NOTHING`).Error; err != nil {
+				return err
+			}
+			if err := tx.Migrator().DropColumn(&models.ChatMessage{}, "to_table_user_id"); err != nil {
+				return err
+			}
+		}
+
+		return tx.Exec(`INSERT INTO chat_message_recipients (chat_message_id, table_user_id)
+			SELECT m.id, tu.id FROM chat_messages m
+			JOIN table_users tu ON tu.table_id = m.table_id AND tu.role = ? AND tu.deleted_at IS NULL
+			WHERE m.to_masters AND NOT EXISTS (SELECT 1 FROM chat_message_recipients r WHERE r.chat_message_id = m.id)
+			ON CONFLICT DO NOTHING`, consts.Master).Error
+	})
+}
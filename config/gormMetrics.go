@@ -0,0 +1,103 @@
+package config
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gorm.io/gorm"
+)
+
+const gormMetricsStartKey = "metrics:start"
+
+var (
+	dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "vtt",
+		Subsystem: "db",
+		Name:      "query_duration_seconds",
+		Help:      "Duration of GORM queries, by operation (create, query, update, delete, row, raw) and table.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"operation", "table"})
+
+	dbQueryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vtt",
+		Subsystem: "db",
+		Name:      "query_errors_total",
+		Help:      "GORM queries that failed, by operation and table. Record not found is not an error.",
+	}, []string{"operation", "table"})
+)
+
+// gormMetrics is a GORM plugin timing every query with callbacks around the GORM ones
+type gormMetrics struct{}
+
+func (gormMetrics) Name() string {
+	return "metrics"
+}
+
+func (m gormMetrics) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+
+	if err := callback.Create().Before("gorm:create").Register("metrics:before_create", m.before); err != nil {
+		return err
+	}
+	if err := callback.Create().After("gorm:create").Register("metrics:after_create", m.after("create")); err != nil {
+		return err
+	}
+	if err := callback.Query().Before("gorm:query").Register("metrics:before_query", m.before); err != nil {
+		return err
+	}
+	if err := callback.Query().After("gorm:query").Register("metrics:after_query", m.after("query")); err != nil {
+		return err
+	}
+	if err := callback.Update().Before("gorm:update").Register("metrics:before_update", m.before); err != nil {
+		return err
+	}
+	if err := callback.Update().After("gorm:update").Register("metrics:after_update", m.after("update")); err != nil {
+		return err
+	}
+	if err := callback.Delete().Before("gorm:delete").Register("metrics:before_delete", m.before); err != nil {
+		return err
+	}
+	if err := callback.Delete().After("gorm:delete").Register("metrics:after_delete", m.after("delete")); err != nil {
+		return err
+	}
+	if err := callback.Row().Before("gorm:row").Register("metrics:before_row", m.before); err != nil {
+		return err
+	}
+	if err := callback.Row().After("gorm:row").Register("metrics:after_row", m.after("row")); err != nil {
+		return err
+	}
+	if err := callback.Raw().Before("gorm:raw").Register("metrics:before_raw", m.before); err != nil {
+		return err
+	}
+	return callback.Raw().After("gorm:raw").Register("metrics:after_raw", m.after("raw"))
+}
+
+func (gormMetrics) before(db *gorm.DB) {
+	db.InstanceSet(gormMetricsStartKey, time.Now())
+}
+
+func (gormMetrics) after(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(gormMetricsStartKey)
+		if !ok {
+			return
+		}
+		start, ok := value.(time.Time)
+		if !ok {
+			return
+		}
+
+		// raw SQL has no model, so it has no table
+		table := db.Statement.Table
+		if table == "" {
+			table = "none"
+		}
+
+		dbQueryDuration.WithLabelValues(operation, table).Observe(time.Since(start).Seconds())
+		if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+			dbQueryErrors.WithLabelValues(operation, table).Inc()
+		}
+	}
+}
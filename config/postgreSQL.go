@@ -58,10 +58,6 @@ func createDataBaseIfNotExists(logger *Logger) error {
 
 func initializePostgreSQL() (*gorm.DB, error) {
 
-	user := os.Getenv("DB_USERNAME")
-	password := os.Getenv("DB_PASSWORD")
-	host := os.Getenv("DB_HOST")
-
 	logger := GetLogger("postgreSQL")
 
 	if err := createDataBaseIfNotExists(logger); err != nil {
@@ -71,12 +67,8 @@ func initializePostgreSQL() (*gorm.DB, error) {
 
 	//creating database connection
 
-	//argument to DSN
-	argument := fmt.Sprintf("host=%v user=%v password=%v dbname=%v port=%v sslmode=disable TimeZone=%v",
-		host, user, password, dbname, port, timeZone)
-
 	db, err := gorm.Open(postgres.New(postgres.Config{
-		DSN:                  argument,
+		DSN:                  GetPostgresDSN(),
 		PreferSimpleProtocol: true, // disables implicit prepared statement usage
 	}), &gorm.Config{})
 	if err != nil {
@@ -84,6 +76,13 @@ func initializePostgreSQL() (*gorm.DB, error) {
 		return nil, err
 
 	}
+
+	//time every query for the /metrics endpoint
+	if err := db.Use(gormMetrics{}); err != nil {
+		logger.ErrorF("postgres metrics plugin error: %v", err)
+		return nil, err
+	}
+
 	//	Migrate the schema
 	//err = db.Migrator().DropTable(&models.TableUser{})
 	err = db.AutoMigrate(
@@ -92,18 +91,48 @@ func initializePostgreSQL() (*gorm.DB, error) {
 		&models.TableUser{},
 		&models.Character{},
 		&models.ChatMessage{},
+		&models.ChatMessageRecipient{},
+		&models.ChatMessageRevision{},
+		&models.ChatReadState{},
+		&models.Notification{},
+		&models.DiceSeed{},
+		&models.DiceRoll{},
 		&models.Scene{},
 		&models.Image{},
 		&models.GameObjectOwner{},
 		&models.PlacedImage{},
 		&models.PlacedToken{},
 		&models.Token{},
-		&models.Bar{})
+		&models.Bar{},
+		&models.SyncTopicSequence{},
+		&models.SyncEventPayload{},
+		&models.SyncPresence{},
+		&models.TableEvent{})
 	if err != nil {
 		logger.ErrorF("postgres  auto-migrating error: %v", err)
 		return nil, err
 	}
 
+	if err := migrateChatRecipients(db); err != nil {
+		logger.ErrorF("postgres chat recipients migration error: %v", err)
+		return nil, err
+	}
+
+	if err := db.Exec("CREATE INDEX IF NOT EXISTS idx_chat_messages_search ON chat_messages USING GIN (" + models.ChatMessageSearchVector + ")").Error; err != nil {
+		logger.ErrorF("postgres chat search index error: %v", err)
+		return nil, err
+	}
+
 	//return db
 	return db, err
 }
+
+// GetPostgresDSN returns the DSN of the vtt database, also used by connections outside gorm (e.g. LISTEN)
+func GetPostgresDSN() string {
+	user := os.Getenv("DB_USERNAME")
+	password := os.Getenv("DB_PASSWORD")
+	host := os.Getenv("DB_HOST")
+
+	return fmt.Sprintf("host=%v user=%v password=%v dbname=%v port=%v sslmode=disable TimeZone=%v",
+		host, user, password, dbname, port, timeZone)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/GarotoCowboy/vttProject/api/grpc/server"
+	"github.com/GarotoCowboy/vttProject/api/grpc/service/eventLog"
 	"github.com/GarotoCowboy/vttProject/api/grpc/service/sync/broker"
 	"github.com/GarotoCowboy/vttProject/api/router"
 	"github.com/GarotoCowboy/vttProject/config"
@@ -9,7 +10,7 @@ import (
 
 var (
 	logger    *config.Logger
-	AppBroker *broker.Broker
+	AppBroker broker.Broker
 )
 
 // @title VTT API
@@ -44,8 +45,6 @@ func main() {
 		return
 	}
 
-	AppBroker = broker.NewBroker()
-
 	//create a file folder if not exists example pdf
 	if err := config.CreateFileFolder(); err != nil {
 		logger.ErrorF("Error... Creating file folder: %v", err)
@@ -54,6 +53,17 @@ func main() {
 
 	db := config.GetPostgreSQL()
 
+	//the broker filters every event with the permissions saved in the database
+	AppBroker, err = broker.NewBrokerFromEnv(db, config.GetLogger("broker"))
+	if err != nil {
+		logger.ErrorF("Error... Creating sync broker: %v", err)
+		return
+	}
+
+	//every published event is also saved in the history of its table
+	recorder := eventLog.NewRecorder(db, config.GetLogger("eventLog"))
+	AppBroker = broker.WithObservers(AppBroker, recorder)
+
 	//Initialize the server
 	go server.RunGRPCServer(db, logger, AppBroker)
 	router.Initializer()